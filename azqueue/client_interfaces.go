@@ -0,0 +1,70 @@
+package azqueue
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// The interfaces in this file describe the wire-level operations used by the URL types. By default, each URL type
+// uses the generated client for its resource; NewXxxURLFromClient accepts any implementation instead, which lets unit
+// tests and alternative transports replace the wire layer below the public URL types. URL and Pipeline are used to
+// build derived URL objects (for example, QueueURL.NewMessagesURL), which use the generated clients unless the client
+// implements MessageIDClientFactory.
+
+// ServiceClient describes the Queue service operations of the generated client used by ServiceURL.
+type ServiceClient interface {
+	URL() url.URL
+	Pipeline() pipeline.Pipeline
+	GetProperties(ctx context.Context, timeout *int32, requestID *string) (*StorageServiceProperties, error)
+	GetStatistics(ctx context.Context, timeout *int32, requestID *string) (*StorageServiceStats, error)
+	ListQueuesSegment(ctx context.Context, prefix *string, marker *string, maxresults *int32, include ListQueuesIncludeType, timeout *int32, requestID *string) (*ListQueuesSegmentResponse, error)
+	SetProperties(ctx context.Context, storageServiceProperties StorageServiceProperties, timeout *int32, requestID *string) (*ServiceSetPropertiesResponse, error)
+}
+
+// QueueClient describes the queue operations of the generated client used by QueueURL.
+type QueueClient interface {
+	URL() url.URL
+	Pipeline() pipeline.Pipeline
+	Create(ctx context.Context, timeout *int32, metadata map[string]string, requestID *string) (*QueueCreateResponse, error)
+	Delete(ctx context.Context, timeout *int32, requestID *string) (*QueueDeleteResponse, error)
+	GetAccessPolicy(ctx context.Context, timeout *int32, requestID *string) (*SignedIdentifiers, error)
+	GetProperties(ctx context.Context, timeout *int32, requestID *string) (*QueueGetPropertiesResponse, error)
+	SetAccessPolicy(ctx context.Context, queueACL []SignedIdentifier, timeout *int32, requestID *string) (*QueueSetAccessPolicyResponse, error)
+	SetMetadata(ctx context.Context, timeout *int32, metadata map[string]string, requestID *string) (*QueueSetMetadataResponse, error)
+}
+
+// MessagesClient describes the message operations of the generated client used by MessagesURL.
+type MessagesClient interface {
+	URL() url.URL
+	Pipeline() pipeline.Pipeline
+	Clear(ctx context.Context, timeout *int32, requestID *string) (*MessagesClearResponse, error)
+	Dequeue(ctx context.Context, numberOfMessages *int32, visibilitytimeout *int32, timeout *int32, requestID *string) (*QueueMessagesList, error)
+	Enqueue(ctx context.Context, queueMessage QueueMessage, visibilitytimeout *int32, messageTimeToLive *int32, timeout *int32, requestID *string) (*EnqueueResponse, error)
+	Peek(ctx context.Context, numberOfMessages *int32, timeout *int32, requestID *string) (*PeekResponse, error)
+}
+
+// MessageIDClient describes the single-message operations of the generated client used by MessageIDURL.
+type MessageIDClient interface {
+	URL() url.URL
+	Pipeline() pipeline.Pipeline
+	Delete(ctx context.Context, popReceipt string, timeout *int32, requestID *string) (*MessageIDDeleteResponse, error)
+	Update(ctx context.Context, queueMessage QueueMessage, popReceipt string, visibilitytimeout int32, timeout *int32, requestID *string) (*MessageIDUpdateResponse, error)
+	// ExtendVisibility is an Update that sends no message text, which leaves the message's content unchanged.
+	ExtendVisibility(ctx context.Context, popReceipt string, visibilitytimeout int32, timeout *int32, requestID *string) (*MessageIDUpdateResponse, error)
+}
+
+// MessageIDClientFactory may be implemented by a MessagesClient passed to NewMessagesURLFromClient so that the
+// MessageIDURLs created by the MessagesURL's NewMessageIDURL method (including those used by QueueSemaphore) use the
+// MessageIDClient it returns instead of the generated client.
+type MessageIDClientFactory interface {
+	NewMessageIDClient(messageID MessageID) MessageIDClient
+}
+
+var (
+	_ ServiceClient   = servicePropertiesServiceClient{}
+	_ QueueClient     = queueClient{}
+	_ MessagesClient  = serviceTimeMessagesClient{}
+	_ MessageIDClient = visibilityMessageIDClient{}
+)
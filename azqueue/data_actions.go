@@ -0,0 +1,54 @@
+package azqueue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// queueRBACPrefix prefixes the names of the Azure RBAC actions and data actions for queues.
+const queueRBACPrefix = "Microsoft.Storage/storageAccounts/queueServices/queues/"
+
+// requiredPermission describes the Azure RBAC permission an operation needs when it is authorized with Azure AD.
+type requiredPermission struct {
+	action string   // Relative to queueRBACPrefix
+	roles  []string // Built-in roles that grant the action
+}
+
+const (
+	roleContributor = "Storage Queue Data Contributor"
+	roleReader      = "Storage Queue Data Reader"
+	roleSender      = "Storage Queue Data Message Sender"
+	roleProcessor   = "Storage Queue Data Message Processor"
+)
+
+// requiredPermissions maps operation names (see OperationName) to the permissions they need. Operations that can't
+// be authorized with Azure AD (like the access policy operations) or that need management roles aren't listed.
+var requiredPermissions = map[string]requiredPermission{
+	"ListQueues":         {"read", []string{roleReader, roleContributor}},
+	"CreateQueue":        {"write", []string{roleContributor}},
+	"DeleteQueue":        {"delete", []string{roleContributor}},
+	"GetQueueProperties": {"read", []string{roleReader, roleContributor}},
+	"SetQueueMetadata":   {"write", []string{roleContributor}},
+	"Enqueue":            {"messages/add/action", []string{roleSender, roleContributor}},
+	"Dequeue":            {"messages/process/action", []string{roleProcessor, roleContributor}},
+	"Peek":               {"messages/read", []string{roleReader, roleProcessor, roleContributor}},
+	"UpdateMessage":      {"messages/write", []string{roleContributor}},
+	"DeleteMessage":      {"messages/process/action", []string{roleProcessor, roleContributor}},
+	"ClearMessages":      {"messages/delete", []string{roleContributor}},
+}
+
+// authorizationHint returns guidance for an authorization failure of operation: the RBAC permission it requires and
+// the built-in roles that grant it. It returns "" if the code isn't an authorization failure or operation's
+// permission is unknown.
+func authorizationHint(code ServiceCodeType, operation string) string {
+	if code != ServiceCodeAuthorizationFailure && code != ServiceCodeAuthorizationPermissionMismatch {
+		return ""
+	}
+	p, ok := requiredPermissions[operation]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Hint: with Azure AD, %s requires the %s%s permission, granted by the %s role(s); "+
+		"with a SAS, check the permissions it grants; a firewall rule can also cause this error",
+		operation, queueRBACPrefix, p.action, strings.Join(p.roles, " or "))
+}
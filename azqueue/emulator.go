@@ -0,0 +1,31 @@
+package azqueue
+
+import "net/url"
+
+const (
+	// DevelopmentStorageAccountName is the well-known account name used by Azurite and the Azure Storage Emulator.
+	DevelopmentStorageAccountName = "devstoreaccount1"
+
+	// DevelopmentStorageAccountKey is the well-known account key used by Azurite and the Azure Storage Emulator.
+	DevelopmentStorageAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+	// developmentStorageQueueEndpoint is the Queue service endpoint of both emulators; they use path-style URLs of
+	// the form http://127.0.0.1:10001/devstoreaccount1/<queue>.
+	developmentStorageQueueEndpoint = "http://127.0.0.1:10001/" + DevelopmentStorageAccountName
+)
+
+// NewAzureStorageEmulatorServiceURL creates a ServiceURL for the local Queue service of Azurite or the legacy Azure
+// Storage Emulator, authenticated with the well-known development storage account credentials. Both emulators listen
+// on port 10001 and use path-style URLs (http://127.0.0.1:10001/devstoreaccount1/<queue>), so the same ServiceURL
+// works with either.
+func NewAzureStorageEmulatorServiceURL(o PipelineOptions) (ServiceURL, error) {
+	credential, err := NewSharedKeyCredential(DevelopmentStorageAccountName, DevelopmentStorageAccountKey)
+	if err != nil {
+		return ServiceURL{}, err
+	}
+	u, err := url.Parse(developmentStorageQueueEndpoint)
+	if err != nil {
+		return ServiceURL{}, err
+	}
+	return NewServiceURL(*u, NewPipeline(credential, o)), nil
+}
@@ -0,0 +1,60 @@
+package azqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// HealthCheckError is returned by HealthCheck when the Queue service could not be reached or rejected the request.
+type HealthCheckError struct {
+	// Endpoint is the service URL that was checked, without its query parameters (so any SAS signature is omitted).
+	Endpoint string
+
+	// Err is the error returned by the ListQueuesSegment call.
+	Err error
+}
+
+// Error implements the error interface's Error method.
+func (e *HealthCheckError) Error() string {
+	return fmt.Sprintf("health check of %s failed (%s): %v", e.Endpoint, e.Type(), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *HealthCheckError) Unwrap() error {
+	return e.Err
+}
+
+// Type describes the kind of failure: "ServiceCode=<code>" (or "StatusCode=<status>" if the response had no error
+// code) if the service responded with an error, "timeout" or "network" if the service could not be reached, or the
+// Go type of the underlying error otherwise.
+func (e *HealthCheckError) Type() string {
+	if stgErr, ok := asStorageError(e.Err); ok {
+		if code := stgErr.ServiceCode(); code != "" {
+			return "ServiceCode=" + string(code)
+		}
+		return fmt.Sprintf("StatusCode=%d", stgErr.Response().StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return fmt.Sprintf("%T", e.Err)
+}
+
+// HealthCheck verifies that the Queue service at serviceURL can be reached with serviceURL's pipeline (including its
+// credential) by listing at most one queue. It returns nil for any successful response, even if the account has no
+// queues; otherwise, it returns a *HealthCheckError. HealthCheck is intended for startup checks and readiness probes.
+func HealthCheck(ctx context.Context, serviceURL ServiceURL) error {
+	_, err := serviceURL.ListQueuesSegment(ctx, Marker{}, ListQueuesSegmentOptions{MaxResults: 1})
+	if err == nil {
+		return nil
+	}
+	u := serviceURL.URL()
+	u.RawQuery = ""
+	return &HealthCheckError{Endpoint: u.String(), Err: err}
+}
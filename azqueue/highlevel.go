@@ -0,0 +1,1321 @@
+package azqueue
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CountSample holds the result of a single approximate message count poll.
+type CountSample struct {
+	// Count is the queue's approximate message count; it is 0 if Err is not nil.
+	Count int64
+
+	// Time is when the sample was taken.
+	Time time.Time
+
+	// Err is the error (if any) returned by the GetProperties call that produced this sample.
+	Err error
+}
+
+// PollMessageCountOptions configures PollMessageCount's behavior.
+type PollMessageCountOptions struct {
+	// MaxErrorBackoff limits how long polling is delayed after consecutive failures (0=default of 5 minutes).
+	MaxErrorBackoff time.Duration
+
+	// SkipUnchanged suppresses successful samples whose count equals the most recently emitted count.
+	SkipUnchanged bool
+}
+
+func (o PollMessageCountOptions) defaults() PollMessageCountOptions {
+	if o.MaxErrorBackoff == 0 {
+		o.MaxErrorBackoff = 5 * time.Minute
+	}
+	return o
+}
+
+// PollMessageCount periodically calls GetProperties and sends the queue's approximate message count on the returned channel.
+// Each delay between polls is jittered; after a failure, the delay doubles with each consecutive failure up to
+// MaxErrorBackoff. Failed polls are sent on the channel with their Err field set. Polling stops and the channel is
+// closed when ctx is cancelled; the caller must keep receiving from the channel until then.
+func (q QueueURL) PollMessageCount(ctx context.Context, interval time.Duration, o PollMessageCountOptions) (<-chan CountSample, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be greater than 0")
+	}
+	o = o.defaults()
+	samples := make(chan CountSample)
+	go func() {
+		defer close(samples)
+		failures, lastCount := uint(0), int64(-1)
+		for {
+			delay := interval
+			sample := CountSample{Time: time.Now()}
+			props, err := q.GetProperties(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return // The caller cancelled polling
+				}
+				sample.Err = err
+				failures++
+				delay = errorBackoff(interval, failures, o.MaxErrorBackoff)
+			} else {
+				failures = 0
+				sample.Count = props.ApproximateMessagesCountInt64()
+			}
+
+			if sample.Err != nil || !o.SkipUnchanged || sample.Count != lastCount {
+				select {
+				case samples <- sample:
+				case <-ctx.Done():
+					return
+				}
+				if sample.Err == nil {
+					lastCount = sample.Count
+				}
+			}
+
+			if !sleepWithContext(ctx, withJitter(delay)) {
+				return
+			}
+		}
+	}()
+	return samples, nil
+}
+
+// WaitUntilEmptyTimeoutError is returned by WaitUntilEmpty and WaitUntilEmptyStrict when ctx is done before the queue
+// is found to be empty.
+type WaitUntilEmptyTimeoutError struct {
+	// LastCount is the most recently observed approximate message count, or -1 if no count was observed.
+	LastCount int64
+
+	// Err is ctx's error.
+	Err error
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *WaitUntilEmptyTimeoutError) Error() string {
+	return fmt.Sprintf("queue was not empty when waiting ended (last approximate message count=%d): %v", e.LastCount, e.Err)
+}
+
+// Unwrap returns ctx's error so errors.Is matches context.Canceled or context.DeadlineExceeded.
+func (e *WaitUntilEmptyTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// WaitUntilEmpty polls the queue's approximate message count every pollInterval (with jitter) until it is 0, for
+// example to wait for a batch job's messages to be processed. Failed polls are retried with backoff like
+// PollMessageCount's, except that a StorageError with a 4xx status code other than 408 (Request Timeout) or 429 (Too
+// Many Requests), such as QueueNotFound, is returned immediately. If ctx is done first (set a deadline on ctx to
+// bound the wait), a *WaitUntilEmptyTimeoutError is returned.
+// NOTE: The approximate message count may lag behind the queue's contents; see WaitUntilEmptyStrict.
+func (q QueueURL) WaitUntilEmpty(ctx context.Context, pollInterval time.Duration) error {
+	return q.waitUntilEmpty(ctx, pollInterval, false)
+}
+
+// WaitUntilEmptyStrict is like WaitUntilEmpty but, because the count is approximate, it also requires a Peek to find
+// no messages on two consecutive polls whose approximate message count is 0. Peek does not see invisible messages,
+// which the approximate message count includes.
+func (q QueueURL) WaitUntilEmptyStrict(ctx context.Context, pollInterval time.Duration) error {
+	return q.waitUntilEmpty(ctx, pollInterval, true)
+}
+
+func (q QueueURL) waitUntilEmpty(ctx context.Context, pollInterval time.Duration, strict bool) error {
+	pollCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stops polling if we return early
+	samples, err := q.PollMessageCount(pollCtx, pollInterval, PollMessageCountOptions{})
+	if err != nil {
+		return err
+	}
+	lastCount, emptyPeeks := int64(-1), 0
+	for sample := range samples {
+		if sample.Err != nil {
+			if isPermanentError(sample.Err) {
+				return sample.Err
+			}
+			continue
+		}
+		lastCount = sample.Count
+		if sample.Count != 0 {
+			emptyPeeks = 0
+			continue
+		}
+		if !strict {
+			return nil
+		}
+		peek, err := q.NewMessagesURL().Peek(ctx, 1)
+		switch {
+		case err != nil && isPermanentError(err):
+			return err
+		case err != nil:
+			// Try again on the next poll
+		case peek.NumMessages() == 0:
+			if emptyPeeks++; emptyPeeks == 2 {
+				return nil
+			}
+		default:
+			emptyPeeks = 0
+		}
+	}
+	return &WaitUntilEmptyTimeoutError{LastCount: lastCount, Err: ctx.Err()}
+}
+
+// isPermanentError returns true if err is a StorageError whose 4xx status code indicates that retrying the operation
+// won't help.
+func isPermanentError(err error) bool {
+	stgErr, ok := asStorageError(err)
+	if !ok || stgErr.Response() == nil {
+		return false
+	}
+	sc := stgErr.Response().StatusCode
+	return sc >= 400 && sc <= 499 && sc != http.StatusRequestTimeout && sc != http.StatusTooManyRequests
+}
+
+// errorBackoff returns interval doubled for each consecutive failure, capped at max.
+func errorBackoff(interval time.Duration, failures uint, max time.Duration) time.Duration {
+	delay := interval
+	for n := uint(0); n < failures && delay < max; n++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// withJitter randomizes d to be within [0.8, 1.3) of its value so that many pollers don't synchronize.
+func withJitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (rand.Float64()/2 + 0.8)) // NOTE: We want math/rand; not crypto/rand
+}
+
+// sleepWithContext waits for d to elapse, returning false if ctx is done first.
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// EmptyQueueBackoff is a strategy controlling how long DequeueWithBackoff waits between Dequeue calls that find
+// the queue empty. The zero value uses the defaults. A single EmptyQueueBackoff should be reused across calls so
+// that its current delay is remembered; it is not goroutine-safe.
+type EmptyQueueBackoff struct {
+	// InitialDelay is the delay after the first empty Dequeue (0=default of 1 second).
+	InitialDelay time.Duration
+
+	// MaxDelay is the maximum delay between empty Dequeues (0=default of 30 seconds).
+	MaxDelay time.Duration
+
+	// Multiplier is the factor by which the delay grows after each empty Dequeue (0=default of 2).
+	Multiplier float64
+
+	// ResetOnMessage resets the delay to InitialDelay whenever messages are dequeued. If false,
+	// the next empty Dequeue continues from the most recent delay.
+	ResetOnMessage bool
+
+	current time.Duration // 0 means the next delay is InitialDelay
+}
+
+func (b *EmptyQueueBackoff) defaults() {
+	if b.InitialDelay == 0 {
+		b.InitialDelay = 1 * time.Second
+	}
+	if b.MaxDelay == 0 {
+		b.MaxDelay = 30 * time.Second
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = 2
+	}
+}
+
+// CurrentDelay returns how long the next empty Dequeue will be followed by a wait.
+func (b *EmptyQueueBackoff) CurrentDelay() time.Duration {
+	b.defaults()
+	if b.current == 0 {
+		return b.InitialDelay
+	}
+	return b.current
+}
+
+// Reset sets the delay back to InitialDelay.
+func (b *EmptyQueueBackoff) Reset() {
+	b.current = 0
+}
+
+// grow increases the current delay by Multiplier up to MaxDelay.
+func (b *EmptyQueueBackoff) grow() {
+	next := time.Duration(float64(b.CurrentDelay()) * b.Multiplier)
+	if next > b.MaxDelay || next <= 0 {
+		next = b.MaxDelay
+	}
+	b.current = next
+}
+
+// DequeueWithBackoff calls Dequeue until it returns at least one message, an error occurs, or ctx is done. Between
+// Dequeue calls that return no messages, it waits as dictated by b (and b's delay grows); a nil b uses the defaults
+// for this call only. If ctx is done while waiting, ctx's error is returned.
+func (m MessagesURL) DequeueWithBackoff(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration, b *EmptyQueueBackoff) (*DequeuedMessagesResponse, error) {
+	if b == nil {
+		b = &EmptyQueueBackoff{}
+	}
+	for {
+		dequeue, err := m.Dequeue(ctx, maxMessages, visibilityTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if dequeue.NumMessages() > 0 {
+			if b.ResetOnMessage {
+				b.Reset()
+			}
+			return dequeue, nil
+		}
+		if !sleepWithContext(ctx, b.CurrentDelay()) {
+			return nil, ctx.Err()
+		}
+		b.grow()
+	}
+}
+
+// ReceiveResponse holds the messages returned by Receive.
+type ReceiveResponse struct {
+	// Messages are the dequeued messages; it is empty if none became available within maxWait.
+	Messages []*DequeuedMessage
+
+	// Polls is the number of Dequeue requests sent. Each is billed as a storage transaction, so it can be used to
+	// monitor the cost of waiting for messages.
+	Polls int
+}
+
+// Receive emulates a long poll, which the service doesn't support: it calls Dequeue until it returns at least one
+// message or maxWait elapses, waiting between empty Dequeues with a delay that starts at 100 milliseconds and doubles
+// up to 5 seconds. If maxWait elapses first, Receive returns a response with no messages, not an error. To configure
+// the delays, or to keep the delay from growing across calls, use ReceiveWithBackoff.
+func (m MessagesURL) Receive(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration, maxWait time.Duration) (*ReceiveResponse, error) {
+	return m.ReceiveWithBackoff(ctx, maxMessages, visibilityTimeout, maxWait, nil)
+}
+
+// ReceiveWithBackoff is like Receive but waits between empty Dequeues as dictated by b (see DequeueWithBackoff); a nil
+// b uses Receive's delays. The last Dequeue is sent when maxWait elapses even if b's delay would end later. If a Dequeue fails or ctx is done first,
+// the error (ctx's error in the latter case) is returned along with a response whose Polls counts the Dequeues sent.
+func (m MessagesURL) ReceiveWithBackoff(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration, maxWait time.Duration, b *EmptyQueueBackoff) (*ReceiveResponse, error) {
+	if b == nil {
+		b = &EmptyQueueBackoff{InitialDelay: 100 * time.Millisecond, MaxDelay: 5 * time.Second, ResetOnMessage: true}
+	}
+	deadline := time.Now().Add(maxWait)
+	resp := &ReceiveResponse{Messages: []*DequeuedMessage{}}
+	for {
+		dequeue, err := m.Dequeue(ctx, maxMessages, visibilityTimeout)
+		resp.Polls++
+		if err != nil {
+			return resp, err
+		}
+		if n := dequeue.NumMessages(); n > 0 {
+			if b.ResetOnMessage {
+				b.Reset()
+			}
+			for i := int32(0); i < n; i++ {
+				resp.Messages = append(resp.Messages, dequeue.Message(i))
+			}
+			return resp, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return resp, nil
+		}
+		delay := b.CurrentDelay()
+		if delay > remaining {
+			delay = remaining
+		}
+		if !sleepWithContext(ctx, delay) {
+			return resp, ctx.Err()
+		}
+		b.grow()
+	}
+}
+
+// ErrMessageTakenByAnotherConsumer is matched (using errors.Is) by the error DeleteWithRetry returns when the service
+// reports that the message's pop receipt is stale and the caller has no newer one, meaning that the message's
+// visibility timeout expired and another consumer dequeued it.
+var ErrMessageTakenByAnotherConsumer = errors.New("the message was dequeued by another consumer")
+
+// messageTakenError is the error returned by DeleteWithRetry when the message was dequeued by another consumer. It
+// wraps the PopReceiptMismatch StorageError so that IsPopReceiptMismatch still reports it.
+type messageTakenError struct {
+	err error
+}
+
+// Error implements the error interface's Error method.
+func (e *messageTakenError) Error() string {
+	return ErrMessageTakenByAnotherConsumer.Error() + ": " + e.err.Error()
+}
+
+// Unwrap returns the PopReceiptMismatch StorageError.
+func (e *messageTakenError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true if target is ErrMessageTakenByAnotherConsumer.
+func (e *messageTakenError) Is(target error) bool {
+	return target == ErrMessageTakenByAnotherConsumer
+}
+
+// DeleteWithRetry deletes the message using the pop receipt returned by latestPopReceipt. If the service reports that
+// the pop receipt is stale (PopReceiptMismatch), it calls latestPopReceipt again and, if it returns a different pop
+// receipt, retries the delete with it, up to maxRetries times. This handles a consumer whose own Update or
+// ExtendVisibility (for example, a heartbeat renewing the message's visibility timeout) raced with the delete, so
+// latestPopReceipt should return the pop receipt from the consumer's most recent Update. If latestPopReceipt returns the
+// same pop receipt, another consumer has dequeued the message and the PopReceiptMismatch error is returned wrapped in
+// an error that errors.Is reports as ErrMessageTakenByAnotherConsumer.
+// NOTE: The service cannot dequeue a specific message, so DeleteWithRetry never dequeues the message to get its current
+// pop receipt; doing so would take it away from the consumer that holds it.
+func (m MessageIDURL) DeleteWithRetry(ctx context.Context, latestPopReceipt func() PopReceipt, maxRetries int) error {
+	popReceipt := latestPopReceipt()
+	for try := 0; ; try++ {
+		_, err := m.Delete(ctx, popReceipt)
+		if err == nil || !IsPopReceiptMismatch(err) || try >= maxRetries {
+			return err
+		}
+		latest := latestPopReceipt()
+		if latest == popReceipt {
+			return &messageTakenError{err: err}
+		}
+		popReceipt = latest
+	}
+}
+
+// FindMessages peeks at the messages at the front of the queue and returns those for which predicate returns true,
+// stopping once maxResults matches have been found (maxResults <= 0 means no limit). Messages are not dequeued so
+// their visibility is unchanged.
+// NOTE: The service's Peek operation only ever returns messages from the front of the queue and cannot page past them,
+// so FindMessages examines at most the first QueueMaxMessagesPeek (32) visible messages. Invisible messages and
+// messages further back in the queue are never examined. FindMessages is intended for operational inspection; its cost
+// and coverage make it unsuitable for locating messages in large production queues.
+func (m MessagesURL) FindMessages(ctx context.Context, predicate func(*PeekedMessage) bool, maxResults int) ([]*PeekedMessage, error) {
+	peek, err := m.Peek(ctx, QueueMaxMessagesPeek)
+	if err != nil {
+		return nil, err
+	}
+	matches := []*PeekedMessage{}
+	for i := int32(0); i < peek.NumMessages() && (maxResults <= 0 || len(matches) < maxResults); i++ {
+		if msg := peek.Message(i); predicate(msg) {
+			matches = append(matches, msg)
+		}
+	}
+	return matches, nil
+}
+
+// QueueSnapshot holds the results of a call to GetPropertiesAndPeek or Inspect.
+type QueueSnapshot struct {
+	// ApproximateCount is the queue's approximate message count.
+	ApproximateCount int32
+
+	// SampledMessages holds the messages peeked from the front of the queue.
+	SampledMessages []*PeekedMessage
+
+	// OldestInsertionTime is the earliest insertion time of the sampled messages (the zero value if there are none).
+	OldestInsertionTime time.Time
+
+	// Messages describes each of the sampled messages; it is only set by Inspect.
+	Messages []InspectedMessage
+}
+
+// InspectedMessage describes a message sampled by Inspect.
+type InspectedMessage struct {
+	ID            MessageID
+	InsertionTime time.Time
+	Age           time.Duration // How long ago the message was inserted, as of the Inspect call
+	DequeueCount  int64
+	Text          string // Decoded from base64 if InspectOptions.DecodeBase64 is true and the text is valid base64
+
+	// ExceedsDequeueThreshold is true if DequeueCount is greater than InspectOptions.DequeueCountThreshold.
+	ExceedsDequeueThreshold bool
+}
+
+// InspectOptions configures Inspect's behavior.
+type InspectOptions struct {
+	// DequeueCountThreshold is the dequeue count above which a message is flagged as likely poison (0=default of 5).
+	DequeueCountThreshold int64
+
+	// DecodeBase64 decodes message texts that are valid base64, for queues whose producers base64-encode messages.
+	DecodeBase64 bool
+}
+
+func (o InspectOptions) defaults() InspectOptions {
+	if o.DequeueCountThreshold == 0 {
+		o.DequeueCountThreshold = 5
+	}
+	return o
+}
+
+// GetPropertiesAndPeek gets the queue's approximate message count and peeks at up to peekCount messages from the front
+// of the queue. The GetProperties and Peek requests are sent concurrently; if either fails, the other is cancelled and
+// the first error is returned. Because the two requests are independent, the count and the sampled messages may
+// reflect slightly different points in time.
+func (q QueueURL) GetPropertiesAndPeek(ctx context.Context, peekCount int32) (*QueueSnapshot, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var props *QueueGetPropertiesResponse
+	var peek *PeekedMessagesResponse
+	errs := make(chan error, 2)
+	wg := sync.WaitGroup{}
+	do := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil {
+				errs <- err
+				cancel() // Stop the other request
+			}
+		}()
+	}
+	do(func() (err error) { props, err = q.GetProperties(ctx); return })
+	do(func() (err error) { peek, err = q.NewMessagesURL().Peek(ctx, peekCount); return })
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err // The 1st error (if any) is the one that caused the other request to be cancelled
+	}
+
+	snapshot := &QueueSnapshot{ApproximateCount: props.ApproximateMessagesCount(), SampledMessages: []*PeekedMessage{}}
+	for i := int32(0); i < peek.NumMessages(); i++ {
+		msg := peek.Message(i)
+		snapshot.SampledMessages = append(snapshot.SampledMessages, msg)
+		if snapshot.OldestInsertionTime.IsZero() || msg.InsertionTime.Before(snapshot.OldestInsertionTime) {
+			snapshot.OldestInsertionTime = msg.InsertionTime
+		}
+	}
+	return snapshot, nil
+}
+
+// Inspect returns a snapshot of the queue for operational tooling: its approximate message count and a description of
+// up to max messages (which must be > 0 and is capped at QueueMaxMessagesPeek) from the front of the queue, including
+// their ages and dequeue counts. Messages are peeked, not dequeued, so consumers are not disturbed. See
+// GetPropertiesAndPeek for how the count and messages are obtained.
+func (m MessagesURL) Inspect(ctx context.Context, max int32, o InspectOptions) (*QueueSnapshot, error) {
+	if max <= 0 {
+		return nil, errors.New("max must be > 0")
+	}
+	o = o.defaults()
+	if max > QueueMaxMessagesPeek {
+		max = QueueMaxMessagesPeek
+	}
+	snapshot, err := m.QueueURL().GetPropertiesAndPeek(ctx, max)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	snapshot.Messages = make([]InspectedMessage, len(snapshot.SampledMessages))
+	for i, msg := range snapshot.SampledMessages {
+		text := msg.Text
+		if o.DecodeBase64 {
+			if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+				text = string(decoded)
+			}
+		}
+		snapshot.Messages[i] = InspectedMessage{
+			ID:                      msg.ID,
+			InsertionTime:           msg.InsertionTime,
+			Age:                     nonNegative(now.Sub(msg.InsertionTime)),
+			DequeueCount:            msg.DequeueCount,
+			Text:                    text,
+			ExceedsDequeueThreshold: msg.DequeueCount > o.DequeueCountThreshold,
+		}
+	}
+	return snapshot, nil
+}
+
+// idempotentEnvelope is the JSON envelope that EnqueueIdempotent wraps around a message's text.
+type idempotentEnvelope struct {
+	Key  string `json:"idem"`
+	Body string `json:"body"`
+}
+
+// IdempotencyCache remembers the idempotency keys of messages successfully enqueued by EnqueueIdempotent so that
+// retries with the same key can be answered without sending another message. It holds at most the number of keys
+// given to NewIdempotencyCache, forgetting the least recently used key to make room for a new one, and forgets a key
+// once its message's time-to-live has passed. An IdempotencyCache is safe for concurrent use; share one among the
+// MessagesURLs whose keys should be deduplicated.
+type IdempotencyCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Values are *sentIdempotentMessage
+	lru     list.List                // Front is most recently used
+}
+
+// sentIdempotentMessage records a successful EnqueueIdempotent call.
+type sentIdempotentMessage struct {
+	key      string
+	response *EnqueueMessageResponse
+	expires  time.Time // The zero value means the entry never expires
+}
+
+// NewIdempotencyCache creates an IdempotencyCache that remembers up to maxEntries idempotency keys.
+func NewIdempotencyCache(maxEntries int) (*IdempotencyCache, error) {
+	if maxEntries <= 0 {
+		return nil, errors.New("maxEntries must be > 0")
+	}
+	return &IdempotencyCache{maxEntries: maxEntries, entries: map[string]*list.Element{}}, nil
+}
+
+// load returns the response recorded for key, if it has not expired; an expired entry is removed.
+func (c *IdempotencyCache) load(key string, now time.Time) (*EnqueueMessageResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	sent := e.Value.(*sentIdempotentMessage)
+	if !sent.expires.IsZero() && !now.Before(sent.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return sent.response, true
+}
+
+// store records sent, first dropping expired entries from the least recently used end of the list and then, if the
+// cache is still full, the least recently used entries.
+func (c *IdempotencyCache) store(sent *sentIdempotentMessage, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[sent.key]; ok {
+		c.remove(e)
+	}
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		expires := e.Value.(*sentIdempotentMessage).expires
+		if len(c.entries) < c.maxEntries && (expires.IsZero() || now.Before(expires)) {
+			break
+		}
+		c.remove(e)
+	}
+	c.entries[sent.key] = c.lru.PushFront(sent)
+}
+
+// remove deletes e from the cache; c.mu must be held.
+func (c *IdempotencyCache) remove(e *list.Element) {
+	delete(c.entries, e.Value.(*sentIdempotentMessage).key)
+	c.lru.Remove(e)
+}
+
+// EnqueueIdempotent enqueues text wrapped in the JSON envelope {"idem":"<idempotencyKey>","body":"<text>"}, unless cache
+// shows that a message with the same idempotencyKey was already successfully enqueued to this queue within the
+// message's time-to-live; in that case, EnqueueIdempotent returns the original response without sending a request.
+// The visibilityTimeout and timeToLive parameters have the same meaning as Enqueue's (0 means the 7-day default; -1s
+// means the key is remembered until cache needs room for others).
+// NOTE: Deduplication is done client-side and only by callers sharing cache; concurrent calls with the same key may
+// both enqueue a message, and a key forgotten to make room is enqueued again. Consumers must still detect duplicates
+// themselves; call ParseIdempotentMessage to extract a dequeued message's key and text.
+func (m MessagesURL) EnqueueIdempotent(ctx context.Context, cache *IdempotencyCache, idempotencyKey, text string, visibilityTimeout, timeToLive time.Duration) (*EnqueueMessageResponse, error) {
+	if cache == nil {
+		return nil, errors.New("cache must not be nil")
+	}
+	if idempotencyKey == "" {
+		return nil, errors.New("idempotencyKey must not be empty")
+	}
+	now := time.Now()
+
+	// The query (which may hold a SAS) is not part of the queue's identity
+	u := m.URL()
+	u.RawQuery, u.Fragment = "", ""
+	key := u.String() + "\n" + idempotencyKey
+	if resp, ok := cache.load(key, now); ok {
+		return resp, nil
+	}
+
+	b, err := json.Marshal(idempotentEnvelope{Key: idempotencyKey, Body: text})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.Enqueue(ctx, string(b), visibilityTimeout, timeToLive)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := &sentIdempotentMessage{key: key, response: resp}
+	switch {
+	case timeToLive == 0:
+		sent.expires = now.Add(7 * 24 * time.Hour) // The service's default time-to-live
+	case timeToLive > 0:
+		sent.expires = now.Add(timeToLive)
+	}
+	cache.store(sent, now)
+	return resp, nil
+}
+
+// ParseIdempotentMessage extracts the idempotency key and original text from a message enqueued by EnqueueIdempotent.
+// ok is false if messageText is not such a message.
+func ParseIdempotentMessage(messageText string) (idempotencyKey string, text string, ok bool) {
+	var e idempotentEnvelope
+	if err := json.Unmarshal([]byte(messageText), &e); err != nil || e.Key == "" {
+		return "", "", false
+	}
+	return e.Key, e.Body, true
+}
+
+// MessageEnvelope wraps a message's body with metadata that the Queue service does not support natively.
+// EnqueueWithEnvelope stores it as the message's text in JSON form; call ParseEnvelope to get it back.
+type MessageEnvelope struct {
+	Body        string            `json:"body"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"` // For example, routing keys or correlation IDs
+
+	// Provenance is set by DequeuedMessage.Requeue when RequeueOptions.PreserveProvenance is set.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// EnqueueWithEnvelope enqueues envelope serialized as JSON. The visibilityTimeout and timeToLive parameters have the
+// same meaning as Enqueue's. The JSON (including the body) counts toward the service's 64KB message size limit.
+func (m MessagesURL) EnqueueWithEnvelope(ctx context.Context, envelope MessageEnvelope, visibilityTimeout, timeToLive time.Duration) (*EnqueueMessageResponse, error) {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return m.Enqueue(ctx, string(b), visibilityTimeout, timeToLive)
+}
+
+// ParseEnvelope extracts the MessageEnvelope from a message enqueued by EnqueueWithEnvelope. The message's text may
+// also be the base64 encoding of the envelope's JSON, as written by SDKs that base64-encode message text. Text that is
+// JSON but lacks the envelope's body or has fields an envelope doesn't is not an envelope.
+func ParseEnvelope(msg *DequeuedMessage) (*MessageEnvelope, error) {
+	text := []byte(msg.Text)
+	if decoded, err := base64.StdEncoding.DecodeString(msg.Text); err == nil {
+		text = decoded
+	}
+	e, err := decodeMessageEnvelope(text)
+	if err != nil {
+		return nil, fmt.Errorf("message %s is not a message envelope: %v", msg.ID, err)
+	}
+	return e, nil
+}
+
+// decodeMessageEnvelope decodes text as a MessageEnvelope, requiring its body and rejecting unknown fields so that
+// ordinary JSON messages aren't mistaken for envelopes.
+func decodeMessageEnvelope(text []byte) (*MessageEnvelope, error) {
+	var e struct {
+		MessageEnvelope
+		Body *string `json:"body"` // Shadows MessageEnvelope.Body so that a missing body can be detected
+	}
+	d := json.NewDecoder(bytes.NewReader(text))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&e); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after the envelope")
+	}
+	if e.Body == nil {
+		return nil, errors.New(`missing "body"`)
+	}
+	e.MessageEnvelope.Body = *e.Body
+	return &e.MessageEnvelope, nil
+}
+
+// Provenance records where a message requeued by DequeuedMessage.Requeue came from.
+type Provenance struct {
+	OriginalID            MessageID `json:"original-id"`
+	OriginalInsertionTime time.Time `json:"original-insertion-time"`
+	DequeueCount          int64     `json:"dequeue-count"`          // The message's dequeue count when it was requeued
+	SourceQueue           string    `json:"source-queue,omitempty"` // Set from RequeueOptions.SourceQueue
+}
+
+// RequeueOptions configures DequeuedMessage.Requeue's behavior.
+type RequeueOptions struct {
+	// PreserveProvenance wraps the message's text in a MessageEnvelope recording its Provenance; call UnwrapProvenance
+	// to get them back. The envelope counts toward the service's 64KB message size limit.
+	PreserveProvenance bool
+
+	// SourceQueue is recorded as the Provenance's SourceQueue, typically the name of the queue the message was
+	// dequeued from (a DequeuedMessage does not know its queue).
+	SourceQueue string
+
+	// VisibilityTimeout indicates how long the requeued message is invisible (0=visible immediately).
+	VisibilityTimeout time.Duration
+
+	// TTL is the time-to-live of the requeued message (0=service default of 7 days).
+	TTL time.Duration
+}
+
+// Requeue enqueues a copy of the message to dst, for example to dead-letter or replay it. Requeue does not delete the
+// original message. If the message was itself requeued with its provenance preserved, its original provenance is kept
+// rather than wrapped again.
+func (msg *DequeuedMessage) Requeue(ctx context.Context, dst MessagesURL, o RequeueOptions) (*EnqueueMessageResponse, error) {
+	text := msg.Text
+	if o.PreserveProvenance {
+		provenance, body := UnwrapProvenance(msg.Text)
+		if provenance.OriginalID == "" {
+			provenance = Provenance{OriginalID: msg.ID, OriginalInsertionTime: msg.InsertionTime,
+				DequeueCount: msg.DequeueCount, SourceQueue: o.SourceQueue}
+		}
+		b, err := json.Marshal(MessageEnvelope{Body: body, Provenance: &provenance})
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	return dst.Enqueue(ctx, text, o.VisibilityTimeout, o.TTL)
+}
+
+// UnwrapProvenance extracts the Provenance and original text from the text of a message requeued by
+// DequeuedMessage.Requeue with RequeueOptions.PreserveProvenance set: a MessageEnvelope with only a body and a
+// Provenance that has an OriginalID. Any other text, including JSON that merely has a "provenance" field, is returned
+// untouched with a zero Provenance (whose OriginalID is empty).
+func UnwrapProvenance(text string) (Provenance, string) {
+	e, err := decodeMessageEnvelope([]byte(text))
+	if err != nil || e.Provenance == nil || e.Provenance.OriginalID == "" || e.ContentType != "" || e.Headers != nil {
+		return Provenance{}, text
+	}
+	return *e.Provenance, e.Body
+}
+
+// CopyOptions configures CopyQueue's behavior.
+type CopyOptions struct {
+	// DeleteAfterCopy deletes each message from the source queue after it has been enqueued to the destination queue.
+	DeleteAfterCopy bool
+
+	// Concurrency indicates the maximum number of batches to copy in parallel (0=default of 5).
+	Concurrency int
+
+	// TTL is the time-to-live of the messages enqueued to the destination queue (0=service default of 7 days).
+	TTL time.Duration
+
+	// VisibilityTimeout indicates how long dequeued source messages stay invisible while they are copied (0=default of 30 seconds).
+	// If a message becomes visible again before CopyQueue finishes, it is skipped rather than copied twice; dequeuing it
+	// again increments its dequeue count, so choose a timeout longer than the copy is expected to take.
+	VisibilityTimeout time.Duration
+}
+
+func (o CopyOptions) defaults() CopyOptions {
+	if o.Concurrency == 0 {
+		o.Concurrency = 5
+	}
+	if o.VisibilityTimeout == 0 {
+		o.VisibilityTimeout = 30 * time.Second
+	}
+	return o
+}
+
+// CopyStats reports the outcome of a CopyQueue operation.
+type CopyStats struct {
+	// Copied is the number of messages successfully enqueued to the destination queue.
+	Copied int64
+
+	// Failed is the number of messages that could not be enqueued to the destination queue; they remain in the source queue.
+	Failed int64
+
+	// Skipped is the number of messages dequeued more than once during the copy and therefore not copied again.
+	Skipped int64
+}
+
+// CopyQueue copies all messages from src to dst by dequeuing batches of messages from src and enqueuing them to dst.
+// Copying stops when src has no more visible messages, when (unless DeleteAfterCopy is set) as many distinct messages
+// have been dequeued as src's approximate message count when the copy started, or when every batch dequeued for a
+// whole VisibilityTimeout has only messages dequeued before (for example, when the only other messages are being
+// processed by another consumer). Messages are copied with their text only; message IDs,
+// pop receipts, and dequeue counts are assigned anew by the destination queue. If an error stops the copy, the stats
+// gathered so far are returned along with the error.
+func CopyQueue(ctx context.Context, src MessagesURL, dst MessagesURL, o CopyOptions) (*CopyStats, error) {
+	if o.Concurrency < 0 {
+		return nil, errors.New("concurrency must be >= 0")
+	}
+	o = o.defaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c := &queueCopier{src: src, dst: dst, o: o, seen: map[MessageID]struct{}{}, target: -1}
+	if !o.DeleteAfterCopy {
+		// Copied messages become visible again, so the queue may never look empty; stop once they've all been copied
+		props, err := src.QueueURL().GetProperties(ctx)
+		if err != nil {
+			return &CopyStats{}, err
+		}
+		c.target = props.ApproximateMessagesCountInt64()
+	}
+	errs := make(chan error, o.Concurrency)
+	wg := sync.WaitGroup{}
+	for n := 0; n < o.Concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.copy(ctx); err != nil {
+				errs <- err
+				cancel() // Stop the other goroutines
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	stats := &CopyStats{
+		Copied:  atomic.LoadInt64(&c.stats.Copied),
+		Failed:  atomic.LoadInt64(&c.stats.Failed),
+		Skipped: atomic.LoadInt64(&c.stats.Skipped),
+	}
+	return stats, <-errs // The 1st error (if any) is the one that caused the copy to stop
+}
+
+// queueCopier holds the state shared by CopyQueue's goroutines.
+type queueCopier struct {
+	src, dst MessagesURL
+	o        CopyOptions
+	stats    CopyStats
+
+	lock   sync.Mutex
+	seen   map[MessageID]struct{} // IDs of all messages dequeued so far
+	target int64                  // Stop once this many messages have been seen (-1 if DeleteAfterCopy is set)
+}
+
+// markSeen records that a message has been dequeued, returning false if it was already dequeued.
+func (c *queueCopier) markSeen(id MessageID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = struct{}{}
+	return true
+}
+
+// seenTarget returns true if the copy has seen as many messages as it is meant to copy.
+func (c *queueCopier) seenTarget() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.target >= 0 && int64(len(c.seen)) >= c.target
+}
+
+// copy copies batches of messages until the source queue has no more visible messages, the copy has seen its target
+// number of messages, or every batch dequeued for a whole visibility timeout had only messages seen before. A batch of
+// messages seen before doesn't end the copy by itself since they may have become visible again while messages behind
+// them haven't been dequeued yet; dequeuing them hides them, so later batches reach the messages behind them.
+func (c *queueCopier) copy(ctx context.Context) error {
+	staleSince := time.Time{} // When the current run of batches with only messages seen before started
+	for {
+		dequeue, err := c.src.Dequeue(ctx, QueueMaxMessagesDequeue, c.o.VisibilityTimeout)
+		if err != nil {
+			return err
+		}
+		if dequeue.NumMessages() == 0 {
+			return nil // The source queue has no visible messages
+		}
+		unseen := 0
+		for m := int32(0); m < dequeue.NumMessages(); m++ {
+			msg := dequeue.Message(m)
+			if !c.markSeen(msg.ID) {
+				atomic.AddInt64(&c.stats.Skipped, 1)
+				continue
+			}
+			unseen++
+			if _, err := c.dst.Enqueue(ctx, msg.Text, 0, c.o.TTL); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				atomic.AddInt64(&c.stats.Failed, 1)
+				continue
+			}
+			atomic.AddInt64(&c.stats.Copied, 1)
+			if c.o.DeleteAfterCopy {
+				if _, err := c.src.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt); err != nil {
+					return err
+				}
+			}
+		}
+		switch {
+		case c.seenTarget():
+			return nil
+		case unseen > 0:
+			staleSince = time.Time{}
+		case staleSince.IsZero():
+			staleSince = time.Now()
+		case time.Since(staleSince) >= c.o.VisibilityTimeout:
+			return nil // The messages left are ones we've seen or ones another consumer is holding
+		}
+	}
+}
+
+// CloneOptions configures CloneQueueConfiguration's behavior.
+type CloneOptions struct {
+	// IncludeMetadata replaces the destination queue's metadata with the source queue's metadata.
+	IncludeMetadata bool
+
+	// IncludeAccessPolicy replaces the destination queue's stored access policies with the source queue's policies.
+	IncludeAccessPolicy bool
+}
+
+// CloneResult reports what CloneQueueConfiguration applied to the destination queue.
+type CloneResult struct {
+	// Created is true if the destination queue did not exist and was created.
+	Created bool
+
+	// MetadataApplied is true if the destination queue now has the source queue's metadata.
+	MetadataApplied bool
+
+	// AccessPolicyApplied is true if the destination queue now has the source queue's stored access policies.
+	AccessPolicyApplied bool
+}
+
+// CloneQueueConfiguration copies the configuration selected by o from the src queue to the dst queue, creating dst if
+// it doesn't exist. Messages are not copied; use CopyQueue for that. The source's configuration is read before dst is
+// touched so a failure to read it leaves dst unchanged. If applying a piece of the configuration fails, the result
+// reports the pieces applied so far along with the error.
+func CloneQueueConfiguration(ctx context.Context, src, dst QueueURL, o CloneOptions) (*CloneResult, error) {
+	var metadata Metadata
+	if o.IncludeMetadata {
+		props, err := src.GetProperties(ctx)
+		if err != nil {
+			return nil, err
+		}
+		metadata = props.NewMetadata()
+	}
+	var identifiers []SignedIdentifier
+	if o.IncludeAccessPolicy {
+		policy, err := src.GetAccessPolicy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		identifiers = policy.Items
+	}
+
+	result := &CloneResult{}
+	// Create succeeds (with 204 No Content) if dst already exists with identical metadata and fails with
+	// QueueAlreadyExists if its metadata differs
+	resp, err := dst.Create(ctx, metadata)
+	if err == nil {
+		result.Created = resp.StatusCode() == http.StatusCreated
+		result.MetadataApplied = o.IncludeMetadata
+	} else if stgErr, ok := asStorageError(err); !ok || stgErr.ServiceCode() != ServiceCodeQueueAlreadyExists {
+		return result, err
+	} else if o.IncludeMetadata {
+		if _, err := dst.SetMetadata(ctx, metadata); err != nil {
+			return result, err
+		}
+		result.MetadataApplied = true
+	}
+
+	if o.IncludeAccessPolicy {
+		if _, err := dst.SetAccessPolicy(ctx, identifiers); err != nil {
+			return result, err
+		}
+		result.AccessPolicyApplied = true
+	}
+	return result, nil
+}
+
+// BulkEnqueueOptions configures EnqueueBulkFromReader's behavior.
+type BulkEnqueueOptions struct {
+	// Delimiter separates the messages in the reader (0=default of '\n'). If it is '\n', a "\r" ending a line is
+	// removed too so that files with Windows line endings work as expected.
+	Delimiter byte
+
+	// Concurrency indicates the maximum number of messages to enqueue in parallel (0=default of 5).
+	Concurrency int
+
+	// VisibilityTimeout and TTL have the same meaning as Enqueue's visibilityTimeout and timeToLive parameters.
+	VisibilityTimeout time.Duration
+	TTL               time.Duration
+
+	// MaxLineSize is the maximum size, in bytes, of a message (0=default of 64KB, the service's limit). Longer lines
+	// are not enqueued and are counted as failed.
+	MaxLineSize int
+}
+
+func (o BulkEnqueueOptions) defaults() BulkEnqueueOptions {
+	if o.Delimiter == 0 {
+		o.Delimiter = '\n'
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 5
+	}
+	if o.MaxLineSize == 0 {
+		o.MaxLineSize = 64 * 1024
+	}
+	return o
+}
+
+// BulkEnqueueStats reports the outcome of an EnqueueBulkFromReader operation.
+type BulkEnqueueStats struct {
+	// EnqueuedCount is the number of messages successfully enqueued.
+	EnqueuedCount int64
+
+	// FailedCount is the number of lines that were too long or whose Enqueue failed.
+	FailedCount int64
+
+	// BytesRead is the number of bytes read from the reader, including delimiters.
+	BytesRead int64
+}
+
+// EnqueueBulkFromReader reads r until EOF, enqueuing each delimited line as a message to mURL. Empty lines are
+// skipped. Lines are read as they are enqueued, so r can be arbitrarily large. If reading r fails or ctx is done,
+// enqueuing stops and the stats gathered so far are returned along with the error; a failed Enqueue only increments
+// FailedCount. Messages may be enqueued out of order when Concurrency is greater than 1.
+func EnqueueBulkFromReader(ctx context.Context, mURL MessagesURL, r io.Reader, o BulkEnqueueOptions) (*BulkEnqueueStats, error) {
+	if o.Concurrency < 0 || o.MaxLineSize < 0 {
+		return nil, errors.New("concurrency and maxLineSize must be >= 0")
+	}
+	o = o.defaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stats := &BulkEnqueueStats{}
+	lines := make(chan string, o.Concurrency)
+	wg := sync.WaitGroup{}
+	for n := 0; n < o.Concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				if _, err := mURL.Enqueue(ctx, line, o.VisibilityTimeout, o.TTL); err != nil {
+					if ctx.Err() == nil {
+						atomic.AddInt64(&stats.FailedCount, 1)
+					}
+					continue
+				}
+				atomic.AddInt64(&stats.EnqueuedCount, 1)
+			}
+		}()
+	}
+
+	err := func() error {
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			line, tooLong, err := readDelimited(br, o.Delimiter, o.MaxLineSize, &stats.BytesRead)
+			switch {
+			case tooLong:
+				atomic.AddInt64(&stats.FailedCount, 1)
+			case len(line) > 0:
+				select {
+				case lines <- string(line):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}()
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err() // Lines that weren't enqueued because ctx is done aren't counted as failed
+	}
+	return stats, err
+}
+
+// readDelimited reads the next line from br, without its delimiter, adding the number of bytes read to bytesRead.
+// If the line is longer than max, it is skipped and tooLong is true. err is io.EOF after the last line.
+func readDelimited(br *bufio.Reader, delim byte, max int, bytesRead *int64) (line []byte, tooLong bool, err error) {
+	for {
+		var chunk []byte
+		chunk, err = br.ReadSlice(delim)
+		*bytesRead += int64(len(chunk))
+		if !tooLong {
+			line = append(line, chunk...)
+			// Allow for the delimiter (and a '\r') until we know the line's end
+			if len(line) > max+2 {
+				line, tooLong = nil, true
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			break
+		}
+	}
+	if tooLong {
+		return nil, true, err
+	}
+	line = bytes.TrimSuffix(line, []byte{delim})
+	if delim == '\n' {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+	}
+	if len(line) > max {
+		return nil, true, err
+	}
+	return line, false, err
+}
+
+// MultiQueueMode tells a MultiQueueReceiver how to choose which queue to dequeue from. See the MultiQueueMode* constants.
+type MultiQueueMode int32
+
+const (
+	// MultiQueueModePriority tells a MultiQueueReceiver to always check queues in the order they were specified.
+	MultiQueueModePriority MultiQueueMode = 0
+
+	// MultiQueueModeRoundRobin tells a MultiQueueReceiver to rotate through the queues in proportion to their weights.
+	MultiQueueModeRoundRobin MultiQueueMode = 1
+)
+
+// MultiQueueReceiverOptions configures a MultiQueueReceiver's behavior.
+type MultiQueueReceiverOptions struct {
+	// Mode indicates how the receiver chooses the queue to dequeue from. See the MultiQueueMode* constants.
+	Mode MultiQueueMode
+
+	// Weights indicates, for MultiQueueModeRoundRobin, how many turns each queue gets per rotation.
+	// If nil, every queue gets 1 turn; otherwise, it must have one positive weight per queue.
+	Weights []int
+
+	// MaxSkips indicates, for MultiQueueModePriority, how many Receive calls may be satisfied by higher-priority
+	// queues before a lower-priority queue is checked first to avoid starving it (0=never).
+	MaxSkips int
+
+	// VisibilityTimeout is passed to each Dequeue call (0=default of 30 seconds).
+	VisibilityTimeout time.Duration
+
+	// Backoff is copied for each queue and controls how long a queue is skipped after it is found empty.
+	Backoff EmptyQueueBackoff
+}
+
+// ReceivedMessage is a message dequeued by a MultiQueueReceiver along with the queue it came from.
+type ReceivedMessage struct {
+	*DequeuedMessage
+
+	// Source is the queue's messages URL from which the message was dequeued.
+	Source MessagesURL
+}
+
+// NewMessageIDURL creates a MessageIDURL for the message using its source queue's URL and pipeline.
+// Use this to update or delete the message.
+func (m ReceivedMessage) NewMessageIDURL() MessageIDURL {
+	return m.Source.NewMessageIDURL(m.ID)
+}
+
+// A MultiQueueReceiver dequeues messages from several queues, checking the queues by priority or in weighted
+// round-robin order and skipping any queue recently found empty. A MultiQueueReceiver is not goroutine-safe.
+type MultiQueueReceiver struct {
+	queues   []multiQueue
+	o        MultiQueueReceiverOptions
+	schedule []int // For round-robin, the queue indices of one rotation
+	turn     int   // For round-robin, the position in schedule of the next Receive call
+}
+
+// multiQueue holds a MultiQueueReceiver's state for a single queue.
+type multiQueue struct {
+	messagesURL MessagesURL
+	backoff     EmptyQueueBackoff
+	notBefore   time.Time // The queue is skipped until this time
+	skips       int       // For priority mode, the number of consecutive Receive calls satisfied by a higher-priority queue
+}
+
+// NewMultiQueueReceiver creates a MultiQueueReceiver for the specified queues. For MultiQueueModePriority,
+// queues must be ordered from highest to lowest priority.
+func NewMultiQueueReceiver(queues []MessagesURL, o MultiQueueReceiverOptions) (*MultiQueueReceiver, error) {
+	if len(queues) == 0 {
+		return nil, errors.New("at least one queue must be specified")
+	}
+	if o.Weights != nil && len(o.Weights) != len(queues) {
+		return nil, errors.New("weights must have one entry per queue")
+	}
+	if o.VisibilityTimeout == 0 {
+		o.VisibilityTimeout = 30 * time.Second
+	}
+	r := &MultiQueueReceiver{queues: make([]multiQueue, len(queues)), o: o}
+	for i, q := range queues {
+		r.queues[i] = multiQueue{messagesURL: q, backoff: o.Backoff}
+		r.queues[i].backoff.Reset()
+		weight := 1
+		if o.Weights != nil {
+			weight = o.Weights[i]
+			if weight <= 0 {
+				return nil, errors.New("weights must be greater than 0")
+			}
+		}
+		for w := 0; w < weight; w++ {
+			r.schedule = append(r.schedule, i)
+		}
+	}
+	return r, nil
+}
+
+// order returns the queue indices in the order they should be checked by the next Receive call.
+func (r *MultiQueueReceiver) order() []int {
+	order := make([]int, 0, len(r.queues))
+	added := make([]bool, len(r.queues))
+	add := func(i int) {
+		if !added[i] {
+			added[i] = true
+			order = append(order, i)
+		}
+	}
+	switch r.o.Mode {
+	case MultiQueueModeRoundRobin:
+		for n := range r.schedule {
+			add(r.schedule[(r.turn+n)%len(r.schedule)])
+		}
+		r.turn = (r.turn + 1) % len(r.schedule)
+	default:
+		if r.o.MaxSkips > 0 { // Starved queues go first
+			for i := range r.queues {
+				if r.queues[i].skips >= r.o.MaxSkips {
+					add(i)
+				}
+			}
+		}
+		for i := range r.queues {
+			add(i)
+		}
+	}
+	return order
+}
+
+// Receive dequeues up to maxMessages messages from the first queue (in the receiver's order) that is not being
+// skipped and has messages. If every queue is empty, Receive waits until the earliest queue may be checked again
+// and retries; it returns when messages are dequeued, an error occurs, or ctx is done.
+func (r *MultiQueueReceiver) Receive(ctx context.Context, maxMessages int32) ([]ReceivedMessage, error) {
+	for {
+		now := time.Now()
+		wakeup := time.Time{}
+		for _, i := range r.order() {
+			q := &r.queues[i]
+			if now.Before(q.notBefore) {
+				if wakeup.IsZero() || q.notBefore.Before(wakeup) {
+					wakeup = q.notBefore
+				}
+				continue // This queue was recently found empty
+			}
+			dequeue, err := q.messagesURL.Dequeue(ctx, maxMessages, r.o.VisibilityTimeout)
+			if err != nil {
+				return nil, err
+			}
+			if dequeue.NumMessages() == 0 {
+				q.notBefore = time.Now().Add(q.backoff.CurrentDelay())
+				q.backoff.grow()
+				if wakeup.IsZero() || q.notBefore.Before(wakeup) {
+					wakeup = q.notBefore
+				}
+				continue
+			}
+
+			q.backoff.Reset()
+			q.notBefore = time.Time{}
+			q.skips = 0
+			for j := i + 1; j < len(r.queues); j++ {
+				r.queues[j].skips++ // Lower-priority queues were passed over
+			}
+			messages := make([]ReceivedMessage, dequeue.NumMessages())
+			for m := range messages {
+				messages[m] = ReceivedMessage{DequeuedMessage: dequeue.Message(int32(m)), Source: q.messagesURL}
+			}
+			return messages, nil
+		}
+		if !sleepWithContext(ctx, time.Until(wakeup)) {
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package azqueue
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+var (
+	internalLoggerLock sync.RWMutex
+	internalLogger     func(level pipeline.LogLevel, msg string)
+)
+
+// SetInternalLogger sets the function that receives non-fatal problems encountered by the goroutines this package
+// runs in the background, such as a QueueSemaphore's lease renewal, an AdaptiveWorkerPool's workers, and a
+// ThroughputMonitor's sampling. These problems are otherwise only visible through the affected type's API (if at all).
+// Unlike PipelineOptions.Log, it is not limited to the requests sent through a particular pipeline.
+// Pass nil (the default) to discard the messages. log may be called concurrently from multiple goroutines.
+func SetInternalLogger(log func(level pipeline.LogLevel, msg string)) {
+	internalLoggerLock.Lock()
+	defer internalLoggerLock.Unlock()
+	internalLogger = log
+}
+
+// logInternal formats a message and passes it to the logger set by SetInternalLogger, if any.
+func logInternal(level pipeline.LogLevel, format string, v ...interface{}) {
+	internalLoggerLock.RLock()
+	log := internalLogger
+	internalLoggerLock.RUnlock()
+	if log != nil {
+		log(level, "azqueue: "+fmt.Sprintf(format, v...))
+	}
+}
@@ -0,0 +1,191 @@
+package azqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageLogOptions configures the descriptions of messages produced by the LogString and LogJSON methods of
+// DequeuedMessage, PeekedMessage, and EnqueueMessageResponse. Their String and MarshalJSON methods use the zero value.
+type MessageLogOptions struct {
+	// TextMaxLength is the maximum number of characters of a message's text included, so that logging a message doesn't
+	// log its entire payload; longer text is elided (-1=omit the text; 0=default of 256).
+	TextMaxLength int
+
+	// IncludePopReceipt, if true, includes the message's pop receipt. By default the pop receipt is redacted since
+	// anyone who has it can delete or update the message.
+	IncludePopReceipt bool
+}
+
+func (o MessageLogOptions) defaults() MessageLogOptions {
+	if o.TextMaxLength == 0 {
+		o.TextMaxLength = 256
+	}
+	return o
+}
+
+// messageLogEntry is the form in which messages and enqueue responses are logged; fields that don't apply to the
+// logged type are omitted.
+type messageLogEntry struct {
+	ID              MessageID `json:"id"`
+	DequeueCount    *int64    `json:"dequeueCount,omitempty"`
+	InsertionTime   string    `json:"insertionTime,omitempty"`
+	ExpirationTime  string    `json:"expirationTime,omitempty"`
+	NextVisibleTime string    `json:"nextVisibleTime,omitempty"`
+	PopReceipt      string    `json:"popReceipt,omitempty"`
+	Text            *string   `json:"text,omitempty"`
+}
+
+func newMessageLogEntry(o MessageLogOptions, id MessageID, dequeueCount *int64, insertion, expiration, nextVisible time.Time, popReceipt PopReceipt, text *string) messageLogEntry {
+	o = o.defaults()
+	e := messageLogEntry{
+		ID:              id,
+		DequeueCount:    dequeueCount,
+		InsertionTime:   logTime(insertion),
+		ExpirationTime:  logTime(expiration),
+		NextVisibleTime: logTime(nextVisible),
+	}
+	if popReceipt != "" {
+		e.PopReceipt = redactedValue
+		if o.IncludePopReceipt {
+			e.PopReceipt = string(popReceipt)
+		}
+	}
+	if text != nil && o.TextMaxLength > 0 {
+		elided := elideText(*text, o.TextMaxLength)
+		e.Text = &elided
+	}
+	return e
+}
+
+// String returns e as "{id=... dequeueCount=... ...}", listing only the fields that are set.
+func (e messageLogEntry) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "{id=%s", e.ID)
+	if e.DequeueCount != nil {
+		fmt.Fprintf(b, " dequeueCount=%d", *e.DequeueCount)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"insertionTime", e.InsertionTime},
+		{"expirationTime", e.ExpirationTime},
+		{"nextVisibleTime", e.NextVisibleTime},
+		{"popReceipt", e.PopReceipt},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(b, " %s=%s", f.name, f.value)
+		}
+	}
+	if e.Text != nil {
+		fmt.Fprintf(b, " text=%q", *e.Text)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// logTime formats t in RFC3339, or returns "" if t is the zero time.
+func logTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// elideText returns text if it has at most max characters; otherwise it returns the first max
+// characters followed by the number of characters elided.
+func elideText(text string, max int) string {
+	n := utf8.RuneCountInString(text)
+	if n <= max {
+		return text
+	}
+	i, count := 0, 0
+	for i = range text {
+		if count == max {
+			break
+		}
+		count++
+	}
+	return fmt.Sprintf("%s...(%d more characters)", text[:i], n-max)
+}
+
+func (msg DequeuedMessage) logEntry(o MessageLogOptions) messageLogEntry {
+	return newMessageLogEntry(o, msg.ID, &msg.DequeueCount, msg.InsertionTime, msg.ExpirationTime, msg.NextVisibleTime, msg.PopReceipt, &msg.Text)
+}
+
+// String returns a description of the message suitable for logging, using the default MessageLogOptions.
+func (msg DequeuedMessage) String() string {
+	return msg.LogString(MessageLogOptions{})
+}
+
+// MarshalJSON implements the json.Marshaler interface, producing a description of the message suitable for structured
+// logging, using the default MessageLogOptions.
+func (msg DequeuedMessage) MarshalJSON() ([]byte, error) {
+	return msg.LogJSON(MessageLogOptions{})
+}
+
+// LogString returns a description of the message suitable for logging, configured using the specified options.
+func (msg DequeuedMessage) LogString(o MessageLogOptions) string {
+	return msg.logEntry(o).String()
+}
+
+// LogJSON returns a JSON description of the message suitable for structured logging, configured using the specified
+// options.
+func (msg DequeuedMessage) LogJSON(o MessageLogOptions) ([]byte, error) {
+	return json.Marshal(msg.logEntry(o))
+}
+
+func (msg PeekedMessage) logEntry(o MessageLogOptions) messageLogEntry {
+	return newMessageLogEntry(o, msg.ID, &msg.DequeueCount, msg.InsertionTime, msg.ExpirationTime, time.Time{}, "", &msg.Text)
+}
+
+// String returns a description of the message suitable for logging, using the default MessageLogOptions.
+func (msg PeekedMessage) String() string {
+	return msg.LogString(MessageLogOptions{})
+}
+
+// MarshalJSON implements the json.Marshaler interface, producing a description of the message suitable for structured
+// logging, using the default MessageLogOptions.
+func (msg PeekedMessage) MarshalJSON() ([]byte, error) {
+	return msg.LogJSON(MessageLogOptions{})
+}
+
+// LogString returns a description of the message suitable for logging, configured using the specified options;
+// peeked messages have no pop receipt.
+func (msg PeekedMessage) LogString(o MessageLogOptions) string {
+	return msg.logEntry(o).String()
+}
+
+// LogJSON returns a JSON description of the message suitable for structured logging, configured using the specified
+// options.
+func (msg PeekedMessage) LogJSON(o MessageLogOptions) ([]byte, error) {
+	return json.Marshal(msg.logEntry(o))
+}
+
+func (emr EnqueueMessageResponse) logEntry(o MessageLogOptions) messageLogEntry {
+	return newMessageLogEntry(o, emr.MessageID, nil, emr.InsertionTime, emr.ExpirationTime, emr.TimeNextVisible, emr.PopReceipt, nil)
+}
+
+// String returns a description of the enqueued message suitable for logging, using the default MessageLogOptions.
+func (emr EnqueueMessageResponse) String() string {
+	return emr.LogString(MessageLogOptions{})
+}
+
+// MarshalJSON implements the json.Marshaler interface, producing a description of the enqueued message suitable for
+// structured logging, using the default MessageLogOptions.
+func (emr EnqueueMessageResponse) MarshalJSON() ([]byte, error) {
+	return emr.LogJSON(MessageLogOptions{})
+}
+
+// LogString returns a description of the enqueued message suitable for logging, configured using the specified
+// options; the response has no message text.
+func (emr EnqueueMessageResponse) LogString(o MessageLogOptions) string {
+	return emr.logEntry(o).String()
+}
+
+// LogJSON returns a JSON description of the enqueued message suitable for structured logging, configured using the
+// specified options.
+func (emr EnqueueMessageResponse) LogJSON(o MessageLogOptions) ([]byte, error) {
+	return json.Marshal(emr.logEntry(o))
+}
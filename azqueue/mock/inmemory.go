@@ -0,0 +1,271 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+)
+
+// NewInMemoryQueue creates a MockMessagesURL backed by an in-memory queue. Enqueue, Dequeue, Peek, and Clear behave
+// like the Azure Storage Queue service: dequeued messages are invisible until their visibility timeout expires,
+// expired messages are removed, and each dequeue assigns a new pop receipt. The MessageIDURL returned by
+// NewMessageIDURL deletes and updates messages in the same in-memory queue, returning MessageNotFound and
+// PopReceiptMismatch errors like the service does. The QueueURL returned by its MessagesURL's QueueURL method can get
+// the queue's properties, reporting the number of messages in the in-memory queue as the approximate message count.
+// The in-memory queue is goroutine-safe.
+func NewInMemoryQueue() *MockMessagesURL {
+	q := &inMemoryQueue{}
+	p := pipeline.NewPipeline([]pipeline.Factory{pipeline.MethodFactoryMarker()}, pipeline.Options{HTTPSender: q})
+	u, _ := url.Parse("https://inmemory.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+	return &MockMessagesURL{
+		EnqueueFunc:         messagesURL.Enqueue,
+		DequeueFunc:         messagesURL.Dequeue,
+		PeekFunc:            messagesURL.Peek,
+		ClearFunc:           messagesURL.Clear,
+		NewMessageIDURLFunc: messagesURL.NewMessageIDURL,
+	}
+}
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	defaultTimeToLive        = 7 * 24 * time.Hour
+)
+
+// neverExpires is the expiration time the service reports for a message enqueued with a time-to-live of -1.
+var neverExpires = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+type inMemoryMessage struct {
+	id              string
+	text            string
+	insertionTime   time.Time
+	expirationTime  time.Time
+	timeNextVisible time.Time
+	popReceipt      string
+	dequeueCount    int64
+}
+
+// inMemoryQueue is a pipeline HTTP sender that serves the Queue service's message operations from memory.
+type inMemoryQueue struct {
+	mu       sync.Mutex
+	messages []*inMemoryMessage
+	counter  int
+}
+
+// New implements the pipeline.Factory interface.
+func (q *inMemoryQueue) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		var body []byte
+		if request.Body != nil {
+			b, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		}
+		q.mu.Lock()
+		status, header, respBody := q.serve(request.Method, request.URL, body, time.Now().UTC().Truncate(time.Second))
+		q.mu.Unlock()
+		return pipeline.NewHTTPResponse(&http.Response{
+			StatusCode: status,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader(respBody)),
+			Request:    request.Request,
+		}), nil
+	})
+}
+
+// serve handles a single request; q.mu must be held.
+func (q *inMemoryQueue) serve(method string, u *url.URL, body []byte, now time.Time) (int, http.Header, []byte) {
+	q.removeExpired(now)
+	query := u.Query()
+	path := strings.Split(strings.Trim(u.Path, "/"), "/") // queue[/messages[/messageID]]
+	if len(path) == 3 {
+		return q.serveMessageID(method, path[2], query, body, now)
+	}
+	if len(path) == 1 {
+		if method == http.MethodGet && query.Get("comp") == "metadata" { // Get queue properties
+			header := http.Header{}
+			header.Set("x-ms-approximate-messages-count", strconv.Itoa(len(q.messages)))
+			return http.StatusOK, header, nil
+		}
+		return errorResponse(http.StatusMethodNotAllowed, azqueue.ServiceCodeUnsupportedHTTPVerb)
+	}
+
+	switch {
+	case method == http.MethodPost:
+		var msg azqueue.QueueMessage
+		if err := xml.Unmarshal(body, &msg); err != nil {
+			return errorResponse(http.StatusBadRequest, azqueue.ServiceCodeInvalidXMLDocument)
+		}
+		m := &inMemoryMessage{
+			id:              q.nextValue("00000000-0000-0000-0000-%012d"),
+			text:            msg.MessageText,
+			insertionTime:   now,
+			timeNextVisible: now.Add(seconds(query, "visibilitytimeout", 0)),
+			popReceipt:      q.nextValue("popreceipt%d"),
+		}
+		if ttl := seconds(query, "messagettl", defaultTimeToLive); ttl < 0 {
+			m.expirationTime = neverExpires
+		} else {
+			m.expirationTime = now.Add(ttl)
+		}
+		q.messages = append(q.messages, m)
+		return xmlResponse(http.StatusCreated, enqueuedMessagesList{Items: []azqueue.EnqueuedMessage{{
+			MessageID: m.id, InsertionTime: m.insertionTime, ExpirationTime: m.expirationTime,
+			PopReceipt: m.popReceipt, TimeNextVisible: m.timeNextVisible}}})
+
+	case method == http.MethodGet && query.Get("peekonly") == "true":
+		list := peekedMessagesList{}
+		for _, m := range q.visible(now, query) {
+			list.Items = append(list.Items, azqueue.PeekedMessageItem{
+				MessageID: m.id, InsertionTime: m.insertionTime, ExpirationTime: m.expirationTime,
+				DequeueCount: m.dequeueCount, MessageText: m.text})
+		}
+		return xmlResponse(http.StatusOK, list)
+
+	case method == http.MethodGet:
+		list := dequeuedMessagesList{}
+		for _, m := range q.visible(now, query) {
+			m.timeNextVisible = now.Add(seconds(query, "visibilitytimeout", defaultVisibilityTimeout))
+			m.popReceipt = q.nextValue("popreceipt%d")
+			m.dequeueCount++
+			list.Items = append(list.Items, azqueue.DequeuedMessageItem{
+				MessageID: m.id, InsertionTime: m.insertionTime, ExpirationTime: m.expirationTime, PopReceipt: m.popReceipt,
+				TimeNextVisible: m.timeNextVisible, DequeueCount: m.dequeueCount, MessageText: m.text})
+		}
+		return xmlResponse(http.StatusOK, list)
+
+	case method == http.MethodDelete:
+		q.messages = nil
+		return http.StatusNoContent, http.Header{}, nil
+	}
+	return errorResponse(http.StatusMethodNotAllowed, azqueue.ServiceCodeUnsupportedHTTPVerb)
+}
+
+// serveMessageID handles a request to delete or update a single message; q.mu must be held.
+func (q *inMemoryQueue) serveMessageID(method string, id string, query url.Values, body []byte, now time.Time) (int, http.Header, []byte) {
+	index := -1
+	for i, m := range q.messages {
+		if m.id == id {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return errorResponse(http.StatusNotFound, azqueue.ServiceCodeMessageNotFound)
+	}
+	m := q.messages[index]
+	if query.Get("popreceipt") != m.popReceipt {
+		return errorResponse(http.StatusBadRequest, azqueue.ServiceCodePopReceiptMismatch)
+	}
+
+	switch method {
+	case http.MethodDelete:
+		q.messages = append(q.messages[:index], q.messages[index+1:]...)
+		return http.StatusNoContent, http.Header{}, nil
+
+	case http.MethodPut:
+		if len(body) > 0 {
+			var msg azqueue.QueueMessage
+			if err := xml.Unmarshal(body, &msg); err != nil {
+				return errorResponse(http.StatusBadRequest, azqueue.ServiceCodeInvalidXMLDocument)
+			}
+			m.text = msg.MessageText
+		}
+		m.timeNextVisible = now.Add(seconds(query, "visibilitytimeout", 0))
+		m.popReceipt = q.nextValue("popreceipt%d")
+		header := http.Header{}
+		header.Set("x-ms-popreceipt", m.popReceipt)
+		header.Set("x-ms-time-next-visible", m.timeNextVisible.Format(http.TimeFormat))
+		return http.StatusNoContent, header, nil
+	}
+	return errorResponse(http.StatusMethodNotAllowed, azqueue.ServiceCodeUnsupportedHTTPVerb)
+}
+
+// visible returns up to numofmessages (default 1) messages that are currently visible, in enqueue order.
+func (q *inMemoryQueue) visible(now time.Time, query url.Values) []*inMemoryMessage {
+	max := 1
+	if n, err := strconv.Atoi(query.Get("numofmessages")); err == nil {
+		max = n
+	}
+	messages := []*inMemoryMessage{}
+	for _, m := range q.messages {
+		if len(messages) == max {
+			break
+		}
+		if !m.timeNextVisible.After(now) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// removeExpired deletes every message whose time-to-live has elapsed.
+func (q *inMemoryQueue) removeExpired(now time.Time) {
+	messages := q.messages[:0]
+	for _, m := range q.messages {
+		if m.expirationTime.After(now) {
+			messages = append(messages, m)
+		}
+	}
+	q.messages = messages
+}
+
+// nextValue returns a unique value by formatting an increasing counter using format.
+func (q *inMemoryQueue) nextValue(format string) string {
+	q.counter++
+	return fmt.Sprintf(format, q.counter)
+}
+
+// seconds returns the named query parameter as a duration in seconds, or def if the parameter is absent.
+func seconds(query url.Values, name string, def time.Duration) time.Duration {
+	if s, err := strconv.Atoi(query.Get(name)); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	return def
+}
+
+type enqueuedMessagesList struct {
+	XMLName xml.Name                  `xml:"QueueMessagesList"`
+	Items   []azqueue.EnqueuedMessage `xml:"QueueMessage"`
+}
+
+type dequeuedMessagesList struct {
+	XMLName xml.Name                      `xml:"QueueMessagesList"`
+	Items   []azqueue.DequeuedMessageItem `xml:"QueueMessage"`
+}
+
+type peekedMessagesList struct {
+	XMLName xml.Name                    `xml:"QueueMessagesList"`
+	Items   []azqueue.PeekedMessageItem `xml:"QueueMessage"`
+}
+
+func xmlResponse(status int, v interface{}) (int, http.Header, []byte) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		panic(err) // The list types always marshal successfully
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	return status, header, b
+}
+
+func errorResponse(status int, code azqueue.ServiceCodeType) (int, http.Header, []byte) {
+	header := http.Header{}
+	header.Set("x-ms-error-code", string(code))
+	header.Set("Content-Type", "application/xml")
+	return status, header, []byte(fmt.Sprintf("<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code))
+}
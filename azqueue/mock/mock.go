@@ -0,0 +1,174 @@
+// Package mock provides test doubles for the azqueue URL types so that code which uses Azure Storage queues can be
+// unit tested without a storage account or emulator.
+//
+// Each MockXxxURL type implements the same interface (azqueue.QueueService, azqueue.Queue, or azqueue.Messages) as the
+// corresponding azqueue.XxxURL type. Each method calls the matching XxxFunc field so a test can inject any response or
+// error; calling a method whose field is nil returns an error. NewInMemoryQueue returns a MockMessagesURL whose fields are backed by an in-memory queue.
+package mock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+)
+
+var (
+	_ azqueue.QueueService = (*MockServiceURL)(nil)
+	_ azqueue.Queue        = (*MockQueueURL)(nil)
+	_ azqueue.Messages     = (*MockMessagesURL)(nil)
+)
+
+// notImplemented returns the error returned by a mock method whose function field is nil.
+func notImplemented(method string) error {
+	return errors.New("mock: " + method + " called but " + method + "Func is nil")
+}
+
+// MockServiceURL is a test double for azqueue.ServiceURL.
+type MockServiceURL struct {
+	ListQueuesSegmentFunc func(ctx context.Context, marker azqueue.Marker, o azqueue.ListQueuesSegmentOptions) (*azqueue.ListQueuesSegmentResponse, error)
+	GetPropertiesFunc     func(ctx context.Context) (*azqueue.StorageServiceProperties, error)
+	SetPropertiesFunc     func(ctx context.Context, properties azqueue.StorageServiceProperties) (*azqueue.ServiceSetPropertiesResponse, error)
+	GetStatisticsFunc     func(ctx context.Context) (*azqueue.StorageServiceStats, error)
+}
+
+// ListQueuesSegment calls ListQueuesSegmentFunc.
+func (s *MockServiceURL) ListQueuesSegment(ctx context.Context, marker azqueue.Marker, o azqueue.ListQueuesSegmentOptions) (*azqueue.ListQueuesSegmentResponse, error) {
+	if s.ListQueuesSegmentFunc == nil {
+		return nil, notImplemented("ListQueuesSegment")
+	}
+	return s.ListQueuesSegmentFunc(ctx, marker, o)
+}
+
+// GetProperties calls GetPropertiesFunc.
+func (s *MockServiceURL) GetProperties(ctx context.Context) (*azqueue.StorageServiceProperties, error) {
+	if s.GetPropertiesFunc == nil {
+		return nil, notImplemented("GetProperties")
+	}
+	return s.GetPropertiesFunc(ctx)
+}
+
+// SetProperties calls SetPropertiesFunc.
+func (s *MockServiceURL) SetProperties(ctx context.Context, properties azqueue.StorageServiceProperties) (*azqueue.ServiceSetPropertiesResponse, error) {
+	if s.SetPropertiesFunc == nil {
+		return nil, notImplemented("SetProperties")
+	}
+	return s.SetPropertiesFunc(ctx, properties)
+}
+
+// GetStatistics calls GetStatisticsFunc.
+func (s *MockServiceURL) GetStatistics(ctx context.Context) (*azqueue.StorageServiceStats, error) {
+	if s.GetStatisticsFunc == nil {
+		return nil, notImplemented("GetStatistics")
+	}
+	return s.GetStatisticsFunc(ctx)
+}
+
+// MockQueueURL is a test double for azqueue.QueueURL.
+type MockQueueURL struct {
+	CreateFunc          func(ctx context.Context, metadata azqueue.Metadata) (*azqueue.QueueCreateResponse, error)
+	DeleteFunc          func(ctx context.Context) (*azqueue.QueueDeleteResponse, error)
+	GetPropertiesFunc   func(ctx context.Context) (*azqueue.QueueGetPropertiesResponse, error)
+	SetMetadataFunc     func(ctx context.Context, metadata azqueue.Metadata) (*azqueue.QueueSetMetadataResponse, error)
+	GetAccessPolicyFunc func(ctx context.Context) (*azqueue.SignedIdentifiers, error)
+	SetAccessPolicyFunc func(ctx context.Context, permissions []azqueue.SignedIdentifier) (*azqueue.QueueSetAccessPolicyResponse, error)
+}
+
+// Create calls CreateFunc.
+func (q *MockQueueURL) Create(ctx context.Context, metadata azqueue.Metadata) (*azqueue.QueueCreateResponse, error) {
+	if q.CreateFunc == nil {
+		return nil, notImplemented("Create")
+	}
+	return q.CreateFunc(ctx, metadata)
+}
+
+// Delete calls DeleteFunc.
+func (q *MockQueueURL) Delete(ctx context.Context) (*azqueue.QueueDeleteResponse, error) {
+	if q.DeleteFunc == nil {
+		return nil, notImplemented("Delete")
+	}
+	return q.DeleteFunc(ctx)
+}
+
+// GetProperties calls GetPropertiesFunc.
+func (q *MockQueueURL) GetProperties(ctx context.Context) (*azqueue.QueueGetPropertiesResponse, error) {
+	if q.GetPropertiesFunc == nil {
+		return nil, notImplemented("GetProperties")
+	}
+	return q.GetPropertiesFunc(ctx)
+}
+
+// SetMetadata calls SetMetadataFunc.
+func (q *MockQueueURL) SetMetadata(ctx context.Context, metadata azqueue.Metadata) (*azqueue.QueueSetMetadataResponse, error) {
+	if q.SetMetadataFunc == nil {
+		return nil, notImplemented("SetMetadata")
+	}
+	return q.SetMetadataFunc(ctx, metadata)
+}
+
+// GetAccessPolicy calls GetAccessPolicyFunc.
+func (q *MockQueueURL) GetAccessPolicy(ctx context.Context) (*azqueue.SignedIdentifiers, error) {
+	if q.GetAccessPolicyFunc == nil {
+		return nil, notImplemented("GetAccessPolicy")
+	}
+	return q.GetAccessPolicyFunc(ctx)
+}
+
+// SetAccessPolicy calls SetAccessPolicyFunc.
+func (q *MockQueueURL) SetAccessPolicy(ctx context.Context, permissions []azqueue.SignedIdentifier) (*azqueue.QueueSetAccessPolicyResponse, error) {
+	if q.SetAccessPolicyFunc == nil {
+		return nil, notImplemented("SetAccessPolicy")
+	}
+	return q.SetAccessPolicyFunc(ctx, permissions)
+}
+
+// MockMessagesURL is a test double for azqueue.MessagesURL.
+type MockMessagesURL struct {
+	EnqueueFunc         func(ctx context.Context, messageText string, visibilityTimeout time.Duration, timeToLive time.Duration) (*azqueue.EnqueueMessageResponse, error)
+	DequeueFunc         func(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration) (*azqueue.DequeuedMessagesResponse, error)
+	PeekFunc            func(ctx context.Context, maxMessages int32) (*azqueue.PeekedMessagesResponse, error)
+	ClearFunc           func(ctx context.Context) (*azqueue.MessagesClearResponse, error)
+	NewMessageIDURLFunc func(messageID azqueue.MessageID) azqueue.MessageIDURL
+}
+
+// Enqueue calls EnqueueFunc.
+func (m *MockMessagesURL) Enqueue(ctx context.Context, messageText string, visibilityTimeout time.Duration, timeToLive time.Duration) (*azqueue.EnqueueMessageResponse, error) {
+	if m.EnqueueFunc == nil {
+		return nil, notImplemented("Enqueue")
+	}
+	return m.EnqueueFunc(ctx, messageText, visibilityTimeout, timeToLive)
+}
+
+// Dequeue calls DequeueFunc.
+func (m *MockMessagesURL) Dequeue(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration) (*azqueue.DequeuedMessagesResponse, error) {
+	if m.DequeueFunc == nil {
+		return nil, notImplemented("Dequeue")
+	}
+	return m.DequeueFunc(ctx, maxMessages, visibilityTimeout)
+}
+
+// Peek calls PeekFunc.
+func (m *MockMessagesURL) Peek(ctx context.Context, maxMessages int32) (*azqueue.PeekedMessagesResponse, error) {
+	if m.PeekFunc == nil {
+		return nil, notImplemented("Peek")
+	}
+	return m.PeekFunc(ctx, maxMessages)
+}
+
+// Clear calls ClearFunc.
+func (m *MockMessagesURL) Clear(ctx context.Context) (*azqueue.MessagesClearResponse, error) {
+	if m.ClearFunc == nil {
+		return nil, notImplemented("Clear")
+	}
+	return m.ClearFunc(ctx)
+}
+
+// NewMessageIDURL calls NewMessageIDURLFunc. It panics if NewMessageIDURLFunc is nil since, like
+// azqueue.MessagesURL's NewMessageIDURL, it cannot return an error.
+func (m *MockMessagesURL) NewMessageIDURL(messageID azqueue.MessageID) azqueue.MessageIDURL {
+	if m.NewMessageIDURLFunc == nil {
+		panic(notImplemented("NewMessageIDURL"))
+	}
+	return m.NewMessageIDURLFunc(messageID)
+}
@@ -0,0 +1,83 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { chk.TestingT(t) }
+
+type mockSuite struct{}
+
+var _ = chk.Suite(&mockSuite{})
+
+var ctx = context.Background()
+
+func (s *mockSuite) TestFuncFields(c *chk.C) {
+	m := &mock.MockMessagesURL{}
+	_, err := m.Enqueue(ctx, "text", 0, 0)
+	c.Assert(err, chk.NotNil) // EnqueueFunc is nil
+
+	injected := errors.New("injected")
+	m.EnqueueFunc = func(ctx context.Context, messageText string, visibilityTimeout time.Duration, timeToLive time.Duration) (*azqueue.EnqueueMessageResponse, error) {
+		return nil, injected
+	}
+	_, err = m.Enqueue(ctx, "text", 0, 0)
+	c.Assert(err, chk.Equals, injected)
+}
+
+func (s *mockSuite) TestInMemoryQueue(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	for _, text := range []string{"one", "two", "three"} {
+		_, err := q.Enqueue(ctx, text, 0, 0)
+		c.Assert(err, chk.IsNil)
+	}
+
+	peek, err := q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(3))
+	c.Assert(peek.Message(0).Text, chk.Equals, "one")
+
+	// Dequeued messages are invisible until their visibility timeout expires
+	dequeue, err := q.Dequeue(ctx, 2, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(dequeue.NumMessages(), chk.Equals, int32(2))
+	c.Assert(dequeue.Message(1).Text, chk.Equals, "two")
+	c.Assert(dequeue.Message(1).DequeueCount, chk.Equals, int64(1))
+	peek, err = q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(1))
+	c.Assert(peek.Message(0).Text, chk.Equals, "three")
+
+	msg := dequeue.Message(0)
+	messageIDURL := q.NewMessageIDURL(msg.ID)
+	_, err = messageIDURL.Delete(ctx, azqueue.PopReceipt("stale"))
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, true)
+	_, err = messageIDURL.Delete(ctx, msg.PopReceipt)
+	c.Assert(err, chk.IsNil)
+	_, err = messageIDURL.Delete(ctx, msg.PopReceipt)
+	c.Assert(azqueue.IsMessageNotFound(err), chk.Equals, true)
+
+	// Updating a message with a 0 visibility timeout makes it visible again
+	msg = dequeue.Message(1)
+	update, err := q.NewMessageIDURL(msg.ID).Update(ctx, msg.PopReceipt, 0, "two (updated)")
+	c.Assert(err, chk.IsNil)
+	c.Assert(update.PopReceipt, chk.Not(chk.Equals), msg.PopReceipt)
+	peek, err = q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(2))
+	c.Assert(peek.Message(0).Text, chk.Equals, "two (updated)")
+
+	_, err = q.Clear(ctx)
+	c.Assert(err, chk.IsNil)
+	dequeue, err = q.Dequeue(ctx, 32, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(dequeue.NumMessages(), chk.Equals, int32(0))
+}
@@ -2,10 +2,25 @@ package azqueue
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"net/url"
 	"strings"
 )
 
+// Endpoint suffixes for the Azure clouds. The host of a storage account's queue endpoint is
+// "<account>.queue.<suffix>"; the host of its read-only secondary endpoint is "<account>-secondary.queue.<suffix>".
+const (
+	EndpointSuffixAzurePublic       = "core.windows.net"
+	EndpointSuffixAzureChina        = "core.chinacloudapi.cn"
+	EndpointSuffixAzureUSGovernment = "core.usgovcloudapi.net"
+)
+
+const (
+	queueHostLabel  = ".queue."
+	secondarySuffix = "-secondary"
+)
+
 // A QueueURLParts object represents the components that make up an Azure Storage Queue URL. You parse an
 // existing URL into its parts by calling NewQueueURLParts(). You construct a URL from parts by calling URL().
 // NOTE: Changing any SAS-related field requires computing a new SAS signature.
@@ -49,15 +64,75 @@ func NewQueueURLParts(u url.URL) QueueURLParts {
 
 	// Convert the query parameters to a case-sensitive map & trim whitsapce
 	paramsMap := u.Query()
-	up.SAS = newSASQueryParameters(paramsMap, true)
+	up.SAS = NewSASQueryParameters(paramsMap, true)
 	up.UnparsedParams = paramsMap.Encode()
 	return up
 }
 
+// NewServiceURLParts returns the QueueURLParts for an account's queue service endpoint in the cloud identified by
+// endpointSuffix (for example, EndpointSuffixAzureChina or an Azure Stack suffix). An empty endpointSuffix
+// means EndpointSuffixAzurePublic. The Scheme is "https".
+func NewServiceURLParts(accountName string, endpointSuffix string) QueueURLParts {
+	if endpointSuffix == "" {
+		endpointSuffix = EndpointSuffixAzurePublic
+	}
+	return QueueURLParts{Scheme: "https", Host: accountName + queueHostLabel + strings.TrimPrefix(endpointSuffix, ".")}
+}
+
+// splitHost splits a host of the form "<account>[-secondary].queue.<suffix>[:port]" into the account label and the
+// endpoint suffix. ok is false if the host does not have this form (for example, an IP address).
+func (up QueueURLParts) splitHost() (account string, suffix string, ok bool) {
+	host := up.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	i := strings.Index(host, queueHostLabel)
+	if i <= 0 || net.ParseIP(host) != nil {
+		return "", "", false
+	}
+	return host[:i], host[i+len(queueHostLabel):], true
+}
+
+// AccountName returns the storage account name from the Host field, whatever the cloud's endpoint suffix.
+// For a secondary endpoint host ("<account>-secondary.queue.<suffix>"), the "-secondary" suffix is removed.
+// AccountName returns "" if the host is not of the form "<account>.queue.<suffix>".
+func (up QueueURLParts) AccountName() string {
+	account, _, _ := up.splitHost()
+	return strings.TrimSuffix(account, secondarySuffix)
+}
+
+// EndpointSuffix returns the portion of the Host field following "<account>.queue.", for example "core.windows.net"
+// or "core.chinacloudapi.cn". EndpointSuffix returns "" if the host is not of the form "<account>.queue.<suffix>".
+func (up QueueURLParts) EndpointSuffix() string {
+	_, suffix, _ := up.splitHost()
+	return suffix
+}
+
+// IsSecondary returns true if the Host field is an account's read-only secondary endpoint ("<account>-secondary.queue.<suffix>").
+func (up QueueURLParts) IsSecondary() bool {
+	account, _, _ := up.splitHost()
+	return strings.HasSuffix(account, secondarySuffix)
+}
+
+// Secondary returns a copy of the QueueURLParts whose Host is the account's read-only secondary endpoint, for use with
+// read-access geo-redundant (RA-GRS) accounts; all other fields, including the SAS, are unchanged. If the Host is
+// already a secondary endpoint, the parts are returned unchanged. Secondary returns an error if the host is not of the
+// form "<account>.queue.<suffix>" (for example, an IP-style or Azurite URL) since such endpoints have no secondary.
+func (up QueueURLParts) Secondary() (QueueURLParts, error) {
+	account, _, ok := up.splitHost()
+	if !ok {
+		return QueueURLParts{}, fmt.Errorf("host %q has no secondary endpoint; expected a host of the form <account>.queue.<suffix>", up.Host)
+	}
+	if !strings.HasSuffix(account, secondarySuffix) {
+		up.Host = account + secondarySuffix + up.Host[len(account):]
+	}
+	return up, nil
+}
+
 // URL returns a URL object whose fields are initialized from the QueueURLParts fields. The URL's RawQuery
 // field contains the SAS and unparsed query parameters.
 func (up QueueURLParts) URL() (url.URL, error) {
-	if up.MessageID != "" && !up.Messages || up.QueueName == "" {
+	if up.MessageID != "" && (!up.Messages || up.QueueName == "") {
 		return url.URL{}, errors.New("can't produce a URL with a messageID but without a queue name or Messages")
 	}
 	if up.MessageID == "" && up.Messages && up.QueueName == "" {
@@ -1,6 +1,7 @@
 package azqueue
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -26,18 +27,7 @@ func (v QueueSASSignatureValues) NewSASQueryParameters(sharedKeyCredential *Shar
 	}
 	startTime, expiryTime := FormatTimesForSASSigning(v.StartTime, v.ExpiryTime)
 
-	// String to sign: http://msdn.microsoft.com/en-us/library/azure/dn140255.aspx
-	stringToSign := strings.Join([]string{
-		v.Permissions,
-		startTime,
-		expiryTime,
-		getCanonicalName(sharedKeyCredential.AccountName(), v.QueueName),
-		v.Identifier,
-		v.IPRange.String(),
-		string(v.Protocol),
-		v.Version},
-		"\n")
-	signature := sharedKeyCredential.ComputeHMACSHA256(stringToSign)
+	signature := sharedKeyCredential.ComputeHMACSHA256(v.stringToSign(sharedKeyCredential.AccountName(), startTime, expiryTime))
 
 	p := SASQueryParameters{
 		// Common SAS parameters
@@ -58,6 +48,22 @@ func (v QueueSASSignatureValues) NewSASQueryParameters(sharedKeyCredential *Shar
 	return p
 }
 
+// stringToSign returns the string that is signed to produce a queue SAS. The start and expiry times are passed
+// already formatted since they must be signed exactly as they appear in the SAS.
+func (v QueueSASSignatureValues) stringToSign(accountName string, startTime string, expiryTime string) string {
+	// String to sign: http://msdn.microsoft.com/en-us/library/azure/dn140255.aspx
+	return strings.Join([]string{
+		v.Permissions,
+		startTime,
+		expiryTime,
+		getCanonicalName(accountName, v.QueueName),
+		v.Identifier,
+		v.IPRange.String(),
+		string(v.Protocol),
+		v.Version},
+		"\n")
+}
+
 // getCanonicalName computes the canonical name for a queue resource for SAS signing.
 func getCanonicalName(account string, queueName string) string {
 	elements := []string{"/queue/", account, "/", queueName}
@@ -89,6 +95,14 @@ func (p QueueSASPermissions) String() string {
 	return b.String()
 }
 
+// Validate returns an error if the QueueSASPermissions grants no permissions.
+func (p QueueSASPermissions) Validate() error {
+	if p == (QueueSASPermissions{}) {
+		return errors.New("queue SAS permissions must grant at least one of Read, Add, Update, or Process")
+	}
+	return nil
+}
+
 // Parse initializes the QueueSASPermissions's fields from a string.
 func (p *QueueSASPermissions) Parse(s string) error {
 	*p = QueueSASPermissions{} // Clear the flags
@@ -0,0 +1,111 @@
+package azqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// semaphorePermitText is the text of the messages used as permits by a QueueSemaphore.
+const semaphorePermitText = "permit"
+
+// QueueSemaphore is a distributed counting semaphore whose permits are the messages in a queue dedicated to it.
+// Acquiring a permit dequeues a message, making it invisible to other processes, and releasing it makes the message
+// visible again. While a permit is held, its message's visibility timeout is renewed in the background; if the holder
+// crashes, renewal stops and the permit becomes available again when leaseTimeout expires.
+type QueueSemaphore struct {
+	messagesURL  MessagesURL
+	permits      int
+	leaseTimeout time.Duration
+}
+
+// NewQueueSemaphore creates a QueueSemaphore whose permits are the messages in the queue of mURL. Call Initialize once,
+// when the semaphore is first set up, to enqueue the permits. leaseTimeout is how long a crashed holder keeps its
+// permit; it must be at least 2 seconds since the lease is renewed every leaseTimeout/2.
+func NewQueueSemaphore(mURL MessagesURL, permits int, leaseTimeout time.Duration) (*QueueSemaphore, error) {
+	if permits <= 0 {
+		return nil, errors.New("permits must be > 0")
+	}
+	if leaseTimeout < 2*time.Second {
+		return nil, errors.New("leaseTimeout must be >= 2 seconds")
+	}
+	return &QueueSemaphore{messagesURL: mURL, permits: permits, leaseTimeout: leaseTimeout}, nil
+}
+
+// Initialize enqueues the semaphore's permits to its queue. It must be called only once for a queue, by a single
+// process; calling it again adds more permits. The queue should be empty and used only by the semaphore.
+func (s *QueueSemaphore) Initialize(ctx context.Context) error {
+	for i := 0; i < s.permits; i++ {
+		if _, err := s.messagesURL.Enqueue(ctx, semaphorePermitText, 0, MessageTTLInfinite); err != nil { // Permits never expire
+			return err
+		}
+	}
+	return nil
+}
+
+// Acquire waits until a permit is available and returns it, or returns ctx's error if ctx is done first.
+// The caller must call the permit's Release method when it leaves its critical section.
+func (s *QueueSemaphore) Acquire(ctx context.Context) (*SemaphorePermit, error) {
+	dequeue, err := s.messagesURL.DequeueWithBackoff(ctx, 1, s.leaseTimeout, &EmptyQueueBackoff{InitialDelay: 100 * time.Millisecond, MaxDelay: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	msg := dequeue.Message(0)
+	renewCtx, cancel := context.WithCancel(context.Background())
+	p := &SemaphorePermit{
+		messageIDURL: s.messagesURL.NewMessageIDURL(msg.ID),
+		popReceipt:   msg.PopReceipt,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	go p.renew(renewCtx, s.leaseTimeout)
+	return p, nil
+}
+
+// SemaphorePermit is a permit acquired from a QueueSemaphore.
+type SemaphorePermit struct {
+	messageIDURL MessageIDURL
+	cancel       context.CancelFunc
+	done         chan struct{} // Closed when the renewal goroutine exits
+
+	lock       sync.Mutex
+	popReceipt PopReceipt
+	renewErr   error // The error that stopped renewal, if any
+}
+
+// renew extends the permit's visibility timeout every leaseTimeout/2 until ctx is canceled or an extension fails.
+func (p *SemaphorePermit) renew(ctx context.Context, leaseTimeout time.Duration) {
+	defer close(p.done)
+	for sleepWithContext(ctx, leaseTimeout/2) {
+		p.lock.Lock()
+		resp, err := p.messageIDURL.ExtendVisibility(ctx, p.popReceipt, leaseTimeout)
+		if err == nil {
+			p.popReceipt = resp.PopReceipt
+		} else if ctx.Err() == nil {
+			p.renewErr = err
+			logInternal(pipeline.LogWarning, "renewing the lease on semaphore permit %s failed, so another process may acquire it: %v", p.messageIDURL, err)
+		}
+		p.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Release stops renewing the permit and returns it to the semaphore by making its message visible again (which, unlike
+// deleting and re-enqueuing it, cannot lose the permit if the process crashes in between). If renewing the permit
+// failed earlier, the permit may have been acquired by another process and that error is returned.
+func (p *SemaphorePermit) Release(ctx context.Context) error {
+	p.cancel()
+	<-p.done
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.renewErr != nil {
+		return p.renewErr
+	}
+	_, err := p.messageIDURL.ExtendVisibility(ctx, p.popReceipt, 0)
+	return err
+}
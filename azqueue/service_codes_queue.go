@@ -31,3 +31,25 @@ const (
 	// The specified queue does not exist (404).
 	ServiceCodeQueueNotFound		ServiceCodeType = "QueueNotFound"
 )
+
+// IsMessageNotFound returns true if err, or any error it wraps, is a StorageError whose ServiceCode is
+// ServiceCodeMessageNotFound. This happens when updating or deleting a message that has already been deleted or has expired.
+func IsMessageNotFound(err error) bool {
+	return serviceCodeOf(err) == ServiceCodeMessageNotFound
+}
+
+// IsPopReceiptMismatch returns true if err, or any error it wraps, is a StorageError whose ServiceCode is
+// ServiceCodePopReceiptMismatch. This happens when updating or deleting a message using a pop receipt that is stale
+// because the message's visibility timeout expired and the message was dequeued again.
+func IsPopReceiptMismatch(err error) bool {
+	return serviceCodeOf(err) == ServiceCodePopReceiptMismatch
+}
+
+// serviceCodeOf returns the ServiceCode of the first StorageError in err's chain of wrapped errors (see
+// asStorageError); it returns "" if there is no StorageError in the chain.
+func serviceCodeOf(err error) ServiceCodeType {
+	if stgErr, ok := asStorageError(err); ok {
+		return stgErr.ServiceCode()
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package azqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// SingleFlightQueueURL is a QueueURL whose GetProperties and GetAccessPolicy methods collapse concurrent calls into a
+// single request: a call made while an identical call is in flight waits for it and returns its result instead of
+// sending another request. This avoids a thundering herd of requests when many goroutines monitor the same queue.
+// All other operations, including the mutating ones (Create, Delete, SetMetadata, SetAccessPolicy), are passed to the
+// embedded QueueURL unchanged. A SingleFlightQueueURL is goroutine-safe.
+//
+// Callers that share a call receive the same response object, which they must not modify.
+type SingleFlightQueueURL struct {
+	QueueURL
+
+	getProperties   singleFlight
+	getAccessPolicy singleFlight
+}
+
+var _ Queue = (*SingleFlightQueueURL)(nil)
+
+// NewSingleFlightQueueURL creates a SingleFlightQueueURL that sends its requests using queueURL.
+func NewSingleFlightQueueURL(queueURL QueueURL) *SingleFlightQueueURL {
+	return &SingleFlightQueueURL{QueueURL: queueURL}
+}
+
+// GetProperties is like QueueURL.GetProperties but shares the result of a call already in flight.
+func (q *SingleFlightQueueURL) GetProperties(ctx context.Context) (*QueueGetPropertiesResponse, error) {
+	v, err := q.getProperties.do(ctx, func(ctx context.Context) (interface{}, error) { return q.QueueURL.GetProperties(ctx) })
+	resp, _ := v.(*QueueGetPropertiesResponse)
+	return resp, err
+}
+
+// GetAccessPolicy is like QueueURL.GetAccessPolicy but shares the result of a call already in flight.
+func (q *SingleFlightQueueURL) GetAccessPolicy(ctx context.Context) (*SignedIdentifiers, error) {
+	v, err := q.getAccessPolicy.do(ctx, func(ctx context.Context) (interface{}, error) { return q.QueueURL.GetAccessPolicy(ctx) })
+	resp, _ := v.(*SignedIdentifiers)
+	return resp, err
+}
+
+// singleFlight runs a function at most once at a time, sharing its result with the callers that arrive while it runs.
+// It is a minimal version of golang.org/x/sync/singleflight's Group for a single key.
+type singleFlight struct {
+	lock sync.Mutex
+	call *singleFlightCall // The call in flight, if any
+}
+
+type singleFlightCall struct {
+	ctx   context.Context // The context of the caller that started the call
+	done  chan struct{}   // Closed when value and err are set
+	value interface{}
+	err   error
+}
+
+// errSingleFlightPanicked is the error returned to the callers waiting for a shared call whose function panicked.
+var errSingleFlightPanicked = errors.New("the shared call panicked")
+
+// do calls f with ctx unless a call is already in flight, in which case it waits for that call's result. A waiting
+// caller returns early with ctx's error if ctx is done first, and makes its own call if the shared call failed only
+// because the context of the caller that started it was done. If f panics, the panic propagates to the caller that
+// started the call and the waiting callers return an error.
+func (g *singleFlight) do(ctx context.Context, f func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		g.lock.Lock()
+		c := g.call
+		if c == nil {
+			c = &singleFlightCall{ctx: ctx, done: make(chan struct{}), err: errSingleFlightPanicked}
+			g.call = c
+			g.lock.Unlock()
+			g.run(c, f)
+			return c.value, c.err
+		}
+		g.lock.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if c.err == nil || c.ctx.Err() == nil {
+			return c.value, c.err
+		}
+		// The call failed because its starter gave up; that shouldn't fail this caller, so try again
+	}
+}
+
+// run calls f for c and then, even if f panics, ends c so that the callers waiting for it return.
+func (g *singleFlight) run(c *singleFlightCall, f func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		g.lock.Lock()
+		g.call = nil
+		g.lock.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = f(c.ctx)
+}
@@ -0,0 +1,134 @@
+package azqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// ThroughputStats is a ThroughputMonitor's most recent estimate of a queue's throughput.
+type ThroughputStats struct {
+	// EnqueueRate is the rate, in messages per second, at which the approximate message count grew between the two
+	// most recent successful samples, or 0 if it didn't grow.
+	EnqueueRate float64
+
+	// DequeueRate is the rate, in messages per second, at which the approximate message count shrank between the two
+	// most recent successful samples, or 0 if it didn't shrink.
+	DequeueRate float64
+
+	// ApproximateMessagesCount is the count from the most recent successful sample.
+	ApproximateMessagesCount int64
+
+	// SampledAt is when the most recent successful sample was taken (the zero time if there has been none).
+	SampledAt time.Time
+
+	// Err is the error from the most recent sample, or nil if it succeeded.
+	Err error
+}
+
+// ThroughputMonitor estimates a queue's enqueue and dequeue rates for capacity planning by periodically sampling its
+// approximate message count and differencing successive samples.
+//
+// The estimates are inherently imprecise. The count is approximate, and differencing it measures only the net change:
+// while messages are enqueued and dequeued at the same rate, both EnqueueRate and DequeueRate are 0. The rates are
+// therefore lower bounds on the actual rates, and only one of them is non-zero at a time. Longer sample intervals
+// smooth out the noise at the cost of responding more slowly to changes.
+type ThroughputMonitor struct {
+	queueURL       QueueURL
+	sampleInterval time.Duration
+
+	lock     sync.Mutex
+	stats    ThroughputStats
+	cancel   context.CancelFunc // nil if the monitor is not running
+	done     chan struct{}      // Closed when the polling goroutine exits
+	hasCount bool               // Whether stats holds a successful sample to difference the next one against
+}
+
+// NewThroughputMonitor creates a ThroughputMonitor that samples the approximate message count of mURL's queue every
+// sampleInterval (0=default of 30 seconds). Call Start to begin sampling.
+func NewThroughputMonitor(mURL MessagesURL, sampleInterval time.Duration) *ThroughputMonitor {
+	if sampleInterval <= 0 {
+		sampleInterval = 30 * time.Second
+	}
+	return &ThroughputMonitor{queueURL: mURL.QueueURL(), sampleInterval: sampleInterval}
+}
+
+// Start begins sampling in a background goroutine, which runs until ctx is done or Stop is called. Calling Start while
+// the monitor is running does nothing.
+func (m *ThroughputMonitor) Start(ctx context.Context) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cancel != nil {
+		select {
+		case <-m.done: // ctx was done, so the monitor can be started again
+		default:
+			return
+		}
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	m.hasCount = false // Don't difference the first sample against a stale one from an earlier run
+
+	samples, _ := m.queueURL.PollMessageCount(ctx, m.sampleInterval, PollMessageCountOptions{}) // sampleInterval > 0
+	go func(done chan struct{}) {
+		defer close(done)
+		for sample := range samples {
+			m.record(sample)
+		}
+	}(m.done)
+}
+
+// Stop stops sampling and waits for the background goroutine to exit. The most recent stats remain available from
+// CurrentStats, and the monitor can be started again.
+func (m *ThroughputMonitor) Stop() {
+	m.lock.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel = nil
+	m.lock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// record updates the stats from a sample.
+func (m *ThroughputMonitor) record(sample CountSample) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stats.Err = sample.Err
+	if sample.Err != nil {
+		logInternal(pipeline.LogWarning, "throughput monitor for %s could not sample the message count: %v", m.queueURL, sample.Err)
+		return
+	}
+	if m.hasCount {
+		if elapsed := sample.Time.Sub(m.stats.SampledAt).Seconds(); elapsed > 0 {
+			rate := float64(sample.Count-m.stats.ApproximateMessagesCount) / elapsed
+			m.stats.EnqueueRate, m.stats.DequeueRate = 0, 0
+			if rate > 0 {
+				m.stats.EnqueueRate = rate
+			} else if rate < 0 {
+				m.stats.DequeueRate = -rate
+			}
+		}
+	}
+	m.stats.ApproximateMessagesCount, m.stats.SampledAt, m.hasCount = sample.Count, sample.Time, true
+}
+
+// CurrentStats returns the monitor's most recent estimates.
+func (m *ThroughputMonitor) CurrentStats() ThroughputStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats
+}
+
+// EnqueueRate returns CurrentStats().EnqueueRate.
+func (m *ThroughputMonitor) EnqueueRate() float64 {
+	return m.CurrentStats().EnqueueRate
+}
+
+// DequeueRate returns CurrentStats().DequeueRate.
+func (m *ThroughputMonitor) DequeueRate() float64 {
+	return m.CurrentStats().DequeueRate
+}
@@ -9,25 +9,33 @@ import (
 )
 
 // A MessageIDURL represents a URL to a specific Azure Storage Queue message allowing you to manipulate the message.
+// It is immutable and safe for concurrent use by multiple goroutines.
 type MessageIDURL struct {
-	client messageIDClient
+	client MessageIDClient
 }
 
 // NewMessageIDURL creates a MessageIDURL object using the specified URL and request policy pipeline.
 func NewMessageIDURL(url url.URL, p pipeline.Pipeline) MessageIDURL {
-	client := newMessageIDClient(url, p)
+	client := visibilityMessageIDClient{newMessageIDClient(url, newResponsePipeline(p))}
 	return MessageIDURL{client: client}
 }
 
-// URL returns the URL endpoint used by the MessageIDURL object.
+// NewMessageIDURLFromClient creates a MessageIDURL object that sends its requests using the specified client instead of the
+// generated one. See MessageIDClient.
+func NewMessageIDURLFromClient(client MessageIDClient) MessageIDURL {
+	return MessageIDURL{client: client}
+}
+
+// URL returns a copy of the URL endpoint used by the MessageIDURL object; pass it to NewQueueURLParts to examine or
+// change its parts.
 func (m MessageIDURL) URL() url.URL {
 	return m.client.URL()
 }
 
-// String returns the URL as a string.
+// String returns the URL as a string with the value of any SAS signature ('sig' query parameter) replaced by
+// "[REDACTED]" so that it can be safely logged. Call URL to get the URL including the signature.
 func (m MessageIDURL) String() string {
-	u := m.URL()
-	return u.String()
+	return redactedURLString(m.URL())
 }
 
 // WithPipeline creates a new MessageIDURL object identical to the source but with the specified request policy pipeline.
@@ -35,16 +43,72 @@ func (m MessageIDURL) WithPipeline(p pipeline.Pipeline) MessageIDURL {
 	return NewMessageIDURL(m.URL(), p)
 }
 
+// WithRetryOptions creates a new MessageIDURL object identical to the source but whose pipeline uses the specified
+// retry options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't created by
+// NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (m MessageIDURL) WithRetryOptions(o RetryOptions) (MessageIDURL, error) {
+	p, err := withPipelineOptions(m.client.Pipeline(), func(po *PipelineOptions) { po.Retry = o })
+	if err != nil {
+		return m, err
+	}
+	return NewMessageIDURL(m.URL(), p), nil
+}
+
+// WithRequestLogOptions creates a new MessageIDURL object identical to the source but whose pipeline uses the specified
+// request log options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't
+// created by NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (m MessageIDURL) WithRequestLogOptions(o RequestLogOptions) (MessageIDURL, error) {
+	p, err := withPipelineOptions(m.client.Pipeline(), func(po *PipelineOptions) { po.RequestLog = o })
+	if err != nil {
+		return m, err
+	}
+	return NewMessageIDURL(m.URL(), p), nil
+}
+
+// MessagesURL creates a new MessagesURL object for the messages of the queue containing this message by removing the
+// message ID from the end of MessageIDURL's URL. The new MessagesURL uses the same request policy pipeline and URL query
+// (including any SAS) as the MessageIDURL.
+func (m MessageIDURL) MessagesURL() MessagesURL {
+	u, _ := splitURLPath(m.URL())
+	return NewMessagesURL(u, m.client.Pipeline())
+}
+
+// MessageID returns the message's ID, which is the last segment of MessageIDURL's URL path.
+func (m MessageIDURL) MessageID() MessageID {
+	_, id := splitURLPath(m.URL())
+	return MessageID(id)
+}
+
 // Delete permanently removes the specified message from its queue.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/delete-message2.
 func (m MessageIDURL) Delete(ctx context.Context, popReceipt PopReceipt) (*MessageIDDeleteResponse, error) {
-	return m.client.Delete(ctx, string(popReceipt), nil, nil)
+	return m.client.Delete(withOperationName(ctx, "DeleteMessage"), string(popReceipt), nil, nil)
+}
+
+// DeleteIfExists removes the specified message from its queue like Delete but treats a message that no longer exists
+// as success. It returns false (with a nil error) if the service reports MessageNotFound, which happens when another
+// consumer already deleted the message or it expired. PopReceiptMismatch is still returned as an error because it means
+// the message was dequeued again and is now owned by another consumer.
+func (m MessageIDURL) DeleteIfExists(ctx context.Context, popReceipt PopReceipt) (bool, error) {
+	_, err := m.Delete(ctx, popReceipt)
+	if err == nil {
+		return true, nil
+	}
+	if IsMessageNotFound(err) {
+		return false, nil
+	}
+	return false, err
 }
 
 // Update changes a message's visibility timeout and contents. The message content must be a UTF-8 encoded string that is up to 64KB in size.
+// If message contains a character that XML cannot represent, a *MessageTextValidationError is returned without
+// sending a request.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/update-message.
 func (m MessageIDURL) Update(ctx context.Context, popReceipt PopReceipt, visibilityTimeout time.Duration, message string) (*UpdatedMessageResponse, error) {
-	r, err := m.client.Update(ctx, QueueMessage{MessageText: message}, string(popReceipt),
+	if err := validateMessageText(message); err != nil {
+		return nil, err
+	}
+	r, err := m.client.Update(withOperationName(ctx, "UpdateMessage"), QueueMessage{MessageText: message}, string(popReceipt),
 		int32(visibilityTimeout.Seconds()), nil, nil)
 
 	if err != nil {
@@ -54,10 +118,27 @@ func (m MessageIDURL) Update(ctx context.Context, popReceipt PopReceipt, visibil
 	return &UpdatedMessageResponse{
 		inner:           r,
 		PopReceipt:      PopReceipt(r.PopReceipt()),
-		TimeNextVisible: r.TimeNextVisible(),
+		TimeNextVisible: headerTime(r.Response(), "x-ms-time-next-visible"),
 	}, err
 }
 
+// ExtendVisibility changes a message's visibility timeout without changing its contents. This is useful for a consumer
+// that needs more time to process a message but no longer has the message's text. The request is sent without a body,
+// which the service treats as "do not change the message's content". Use the returned PopReceipt for subsequent
+// operations on the message.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/update-message.
+func (m MessageIDURL) ExtendVisibility(ctx context.Context, popReceipt PopReceipt, visibilityTimeout time.Duration) (*UpdatedMessageResponse, error) {
+	r, err := m.client.ExtendVisibility(withOperationName(ctx, "UpdateMessage"), string(popReceipt), int32(visibilityTimeout.Seconds()), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdatedMessageResponse{
+		inner:           r,
+		PopReceipt:      PopReceipt(r.PopReceipt()),
+		TimeNextVisible: headerTime(r.Response(), "x-ms-time-next-visible"),
+	}, nil
+}
+
 type UpdatedMessageResponse struct {
 	inner *MessageIDUpdateResponse
 
@@ -85,7 +166,7 @@ func (miur UpdatedMessageResponse) Status() string {
 
 // Date returns the value for header Date.
 func (miur UpdatedMessageResponse) Date() time.Time {
-	return miur.inner.Date()
+	return headerTime(miur.Response(), "Date")
 }
 
 // RequestID returns the value for header x-ms-request-id.
@@ -2,8 +2,14 @@ package azqueue
 
 import (
 	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
 	"net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"net/http"
@@ -25,46 +31,168 @@ func (pr PopReceipt) String() string { return string(pr) }
 
 ///////////////////////////////////////////////////////////////////////////////
 
+// Messages is the set of message operations implemented by MessagesURL. Application code can depend on this
+// interface instead of MessagesURL so that unit tests can substitute a fake (see the azqueue/mock package).
+type Messages interface {
+	Enqueue(ctx context.Context, messageText string, visibilityTimeout time.Duration, timeToLive time.Duration) (*EnqueueMessageResponse, error)
+	Dequeue(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration) (*DequeuedMessagesResponse, error)
+	Peek(ctx context.Context, maxMessages int32) (*PeekedMessagesResponse, error)
+	Clear(ctx context.Context) (*MessagesClearResponse, error)
+}
+
+var _ Messages = MessagesURL{}
+
 // A MessagesURL represents a URL to an Azure Storage Queue's messages allowing you to manipulate its messages.
+// A MessagesURL, including its EnqueueDefaults, is immutable and safe for concurrent use by multiple goroutines; share
+// one rather than creating one per goroutine. The responses its methods return may be read, but not modified,
+// concurrently.
 type MessagesURL struct {
-	client messagesClient
+	client          MessagesClient
+	enqueueDefaults EnqueueDefaults
 }
 
 // NewMessageURL creates a MessagesURL object using the specified URL and request policy pipeline.
 func NewMessagesURL(url url.URL, p pipeline.Pipeline) MessagesURL {
-	client := newMessagesClient(url, p)
+	client := serviceTimeMessagesClient{newMessagesClient(url, newResponsePipeline(p))}
+	return MessagesURL{client: client}
+}
+
+// NewMessagesURLFromClient creates a MessagesURL object that sends its requests using the specified client instead of the
+// generated one. See MessagesClient.
+func NewMessagesURLFromClient(client MessagesClient) MessagesURL {
 	return MessagesURL{client: client}
 }
 
-// URL returns the URL endpoint used by the MessagesURL object.
+// URL returns a copy of the URL endpoint used by the MessagesURL object; pass it to NewQueueURLParts to examine or
+// change its parts.
 func (m MessagesURL) URL() url.URL {
 	return m.client.URL()
 }
 
-// String returns the URL as a string.
+// String returns the URL as a string with the value of any SAS signature ('sig' query parameter) replaced by
+// "[REDACTED]" so that it can be safely logged. Call URL to get the URL including the signature.
 func (m MessagesURL) String() string {
-	u := m.URL()
-	return u.String()
+	return redactedURLString(m.URL())
 }
 
 // WithPipeline creates a new MessagesURL object identical to the source but with the specified request policy pipeline.
 func (m MessagesURL) WithPipeline(p pipeline.Pipeline) MessagesURL {
-	return NewMessagesURL(m.URL(), p)
+	return m.withClient(serviceTimeMessagesClient{newMessagesClient(m.URL(), newResponsePipeline(p))})
+}
+
+// WithRetryOptions creates a new MessagesURL object identical to the source but whose pipeline uses the specified retry
+// options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't created by
+// NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (m MessagesURL) WithRetryOptions(o RetryOptions) (MessagesURL, error) {
+	p, err := withPipelineOptions(m.client.Pipeline(), func(po *PipelineOptions) { po.Retry = o })
+	if err != nil {
+		return m, err
+	}
+	return m.WithPipeline(p), nil
+}
+
+// WithRequestLogOptions creates a new MessagesURL object identical to the source but whose pipeline uses the specified
+// request log options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't
+// created by NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (m MessagesURL) WithRequestLogOptions(o RequestLogOptions) (MessagesURL, error) {
+	p, err := withPipelineOptions(m.client.Pipeline(), func(po *PipelineOptions) { po.RequestLog = o })
+	if err != nil {
+		return m, err
+	}
+	return m.WithPipeline(p), nil
+}
+
+// withClient returns a copy of the MessagesURL (including its Enqueue defaults) that uses the specified client.
+func (m MessagesURL) withClient(client MessagesClient) MessagesURL {
+	m.client = client
+	return m
+}
+
+// EnqueueDefaults holds the visibility timeout and time-to-live used by EnqueueText; see MessagesURL.WithDefaults.
+type EnqueueDefaults struct {
+	// VisibilityTimeout is how long enqueued messages are invisible, from 0 (visible immediately) to 7 days.
+	VisibilityTimeout time.Duration
+
+	// TimeToLive is how long enqueued messages live: 0 for the service default of 7 days, MessageTTLInfinite for messages
+	// that never expire, or at least 1 second and more than VisibilityTimeout.
+	TimeToLive time.Duration
+}
+
+// validate returns an error if the defaults are outside the range accepted by the service, for example because the
+// visibility timeout and time-to-live were swapped.
+func (d EnqueueDefaults) validate() error {
+	if d.VisibilityTimeout < 0 || d.VisibilityTimeout > maxVisibilityTimeout {
+		return errors.New("VisibilityTimeout must be from 0 to 7 days")
+	}
+	if d.TimeToLive == 0 || isInfiniteTTL(d.TimeToLive) {
+		return nil
+	}
+	if err := validateTimeToLive(d.TimeToLive); err != nil {
+		return err
+	}
+	if d.VisibilityTimeout >= d.TimeToLive {
+		return fmt.Errorf("VisibilityTimeout (%v) must be less than TimeToLive (%v); were they swapped?", d.VisibilityTimeout, d.TimeToLive)
+	}
+	return nil
+}
+
+// WithDefaults creates a new MessagesURL object identical to the source but whose EnqueueText method uses the specified
+// defaults. An error is returned if the defaults are invalid so that mistakes such as swapping the visibility timeout
+// and time-to-live are caught when the MessagesURL is configured rather than when messages expire early.
+func (m MessagesURL) WithDefaults(d EnqueueDefaults) (MessagesURL, error) {
+	if err := d.validate(); err != nil {
+		return MessagesURL{}, err
+	}
+	m.enqueueDefaults = d
+	return m, nil
+}
+
+// EnqueueText adds a new message to the back of a queue using the visibility timeout and time-to-live configured by
+// WithDefaults (by default, the message is visible immediately and lives for the service default of 7 days). See
+// Enqueue for details.
+func (m MessagesURL) EnqueueText(ctx context.Context, text string) (*EnqueueMessageResponse, error) {
+	return m.Enqueue(ctx, text, m.enqueueDefaults.VisibilityTimeout, m.enqueueDefaults.TimeToLive)
+}
+
+// QueueURL creates a new QueueURL object for the queue whose messages this MessagesURL represents by removing
+// "/messages" from the end of MessagesURL's URL. The new QueueURL uses the same request policy pipeline and URL query
+// (including any SAS) as the MessagesURL.
+func (m MessagesURL) QueueURL() QueueURL {
+	u, _ := splitURLPath(m.URL())
+	return NewQueueURL(u, m.client.Pipeline())
 }
 
 // NewMessageIDURL creates a new MessageIDURL object by concatenating messageID to the end of
 // MessagesURL's URL. The new MessageIDURL uses the same request policy pipeline as the MessagesURL.
 // To change the pipeline, create the MessageIDURL and then call its WithPipeline method passing in the
 // desired pipeline object. Or, call this package's NewMessageIDURL instead of calling this object's
-// NewMessageIDURL method.
+// NewMessageIDURL method. If the MessagesURL's client implements MessageIDClientFactory, the new MessageIDURL uses the
+// client it returns.
 func (m MessagesURL) NewMessageIDURL(messageID MessageID) MessageIDURL {
+	if factory, ok := m.client.(MessageIDClientFactory); ok {
+		return NewMessageIDURLFromClient(factory.NewMessageIDClient(messageID))
+	}
 	messageIDURL := appendToURLPath(m.URL(), messageID.String())
 	return NewMessageIDURL(messageIDURL, m.client.Pipeline())
 }
 
 // Clear deletes all messages from a queue. For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/clear-messages.
 func (m MessagesURL) Clear(ctx context.Context) (*MessagesClearResponse, error) {
-	return m.client.Clear(ctx, nil, nil)
+	return m.client.Clear(withOperationName(ctx, "ClearMessages"), nil, nil)
+}
+
+// ClearAll deletes all messages from a queue by calling Clear until it succeeds. Clearing a queue with a very large
+// number of messages may fail with ServiceCodeOperationTimedOut after deleting only some of them; ClearAll calls Clear
+// again whenever this happens until the queue is cleared or maxWait elapses. It returns the number of times Clear was
+// called. Any other error, or ServiceCodeOperationTimedOut once maxWait has elapsed, is returned immediately.
+func (m MessagesURL) ClearAll(ctx context.Context, maxWait time.Duration) (int, error) {
+	deadline := time.Now().Add(maxWait)
+	for calls := 1; ; calls++ {
+		_, err := m.Clear(ctx)
+		if stgErr, ok := asStorageError(err); !ok || stgErr.ServiceCode() != ServiceCodeOperationTimedOut || !time.Now().Before(deadline) {
+			return calls, err
+		}
+	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -72,19 +200,39 @@ func (m MessagesURL) Clear(ctx context.Context) (*MessagesClearResponse, error)
 // Enqueue adds a new message to the back of a queue. The visibility timeout specifies how long the message should be invisible
 // to Dequeue and Peek operations. The message content must be a UTF-8 encoded string that is up to 64KB in size.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/put-message.
-// The timeToLive interval for the message is defined in seconds. The maximum timeToLive can be any positive number, as well as -time.Second indicating that the message does not expire.
-// If 0 is passed for timeToLive, the default value is 7 days.
+// The timeToLive interval for the message is defined in seconds. The maximum timeToLive can be any positive number, as well as MessageTTLInfinite indicating that the message does not expire.
+// If 0 is passed for timeToLive, the default value is 7 days. Any other timeToLive under 1 second is rejected without
+// sending a request.
+// If messageText contains a character that XML cannot represent, a *MessageTextValidationError is returned without
+// sending a request. If its encoded size exceeds QueueMessageMaxBytes, a *MessageTooLargeError is returned without
+// sending a request.
 func (m MessagesURL) Enqueue(ctx context.Context, messageText string, visibilityTimeout time.Duration, timeToLive time.Duration) (*EnqueueMessageResponse, error) {
+	if err := validateMessageText(messageText); err != nil {
+		return nil, err
+	}
+	encodedSize, err := encodedMessageSize(messageText)
+	if err != nil {
+		return nil, err
+	}
+	if encodedSize > QueueMessageMaxBytes {
+		return nil, &MessageTooLargeError{Size: encodedSize, Limit: QueueMessageMaxBytes}
+	}
+	if err := validateTimeToLive(timeToLive); err != nil {
+		return nil, err
+	}
 	vt := int32(visibilityTimeout.Seconds())
 
 	// timeToLive should only be sent if it's not 0
 	var ttl *int32 = nil
 	if timeToLive != 0 {
-		ttlValue := int32(timeToLive.Seconds())
+		ttlValue := int32(-1) // The service's value for messages that never expire
+		if !isInfiniteTTL(timeToLive) {
+			ttlValue = int32(timeToLive.Seconds())
+		}
 		ttl = &ttlValue
 	}
 
-	resp, err := m.client.Enqueue(ctx, QueueMessage{MessageText: messageText}, &vt, ttl, nil, nil)
+	resp, err := m.client.Enqueue(withOperationName(ctx, "Enqueue"), QueueMessage{MessageText: messageText}, &vt, ttl, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -97,9 +245,131 @@ func (m MessagesURL) Enqueue(ctx context.Context, messageText string, visibility
 		TimeNextVisible: item.TimeNextVisible,
 		InsertionTime:   item.InsertionTime,
 		ExpirationTime:  item.ExpirationTime,
+		EncodedSize:     encodedSize,
 	}, nil
 }
 
+// MessageTTLInfinite is the time-to-live to pass to Enqueue for a message that never expires; Enqueue sends it as
+// messagettl=-1. For compatibility, -time.Second is also accepted with the same meaning.
+const MessageTTLInfinite time.Duration = -1
+
+// isInfiniteTTL returns true if timeToLive means that a message never expires.
+func isInfiniteTTL(timeToLive time.Duration) bool {
+	return timeToLive == MessageTTLInfinite || timeToLive == -time.Second
+}
+
+// validateTimeToLive returns an error if timeToLive is not 0 (the service default), MessageTTLInfinite, or from
+// 1 second to the largest number of seconds the messagettl parameter can hold.
+func validateTimeToLive(timeToLive time.Duration) error {
+	if timeToLive == 0 || isInfiniteTTL(timeToLive) {
+		return nil
+	}
+	if timeToLive < time.Second || timeToLive > math.MaxInt32*time.Second {
+		return fmt.Errorf("timeToLive (%v) must be 0, MessageTTLInfinite, or from 1 second to %v", timeToLive, math.MaxInt32*time.Second)
+	}
+	return nil
+}
+
+// MessageTooLargeError is returned by Enqueue when a message's encoded size exceeds the service's limit; without this
+// check, the service would fail the request with ServiceCodeRequestBodyTooLarge.
+type MessageTooLargeError struct {
+	// Size is the encoded size of the message, in bytes (see EnqueueMessageResponse.EncodedSize).
+	Size int
+
+	// Limit is the maximum encoded size of a message, in bytes (QueueMessageMaxBytes).
+	Limit int
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("encoded message size of %d bytes exceeds the limit of %d bytes by %d bytes", e.Size, e.Limit, e.Size-e.Limit)
+}
+
+// emptyMessageBodySize is the size of the XML request body sent by Enqueue for an empty message.
+var emptyMessageBodySize = func() int {
+	b, _ := xml.Marshal(QueueMessage{})
+	return len(b)
+}()
+
+// encodedMessageSize returns the size of messageText once XML-escaped in an Enqueue request body, which is what the
+// service's message size limit applies to.
+func encodedMessageSize(messageText string) (int, error) {
+	b, err := xml.Marshal(QueueMessage{MessageText: messageText})
+	if err != nil {
+		return 0, err
+	}
+	return len(b) - emptyMessageBodySize, nil
+}
+
+// MessageTextValidationError is returned by Enqueue and Update when the message text contains a character that cannot be
+// sent in the request's XML body: invalid UTF-8, or a control character other than tab, CR, and LF (XML 1.0 allows
+// no others, not even as character references).
+type MessageTextValidationError struct {
+	// Offset is the byte offset of the first invalid character in the message text.
+	Offset int
+
+	// Char is the invalid character, or utf8.RuneError if the text is not valid UTF-8 at Offset.
+	Char rune
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *MessageTextValidationError) Error() string {
+	return fmt.Sprintf("message text has a character that XML cannot represent (%U) at byte offset %d; "+
+		"base64-encode binary or arbitrary text before enqueuing it, or call StripInvalidXMLChars to remove such characters",
+		e.Char, e.Offset)
+}
+
+// Is returns true if target is ErrInvalidMessageContent so that errors.Is(err, ErrInvalidMessageContent) matches
+// every *MessageTextValidationError.
+func (e *MessageTextValidationError) Is(target error) bool {
+	return target == ErrInvalidMessageContent
+}
+
+// ErrInvalidMessageContent is matched (with errors.Is) by the *MessageTextValidationError returned by
+// ValidateMessageEncoding, Enqueue, and Update; use errors.As to get the offset of the invalid character.
+var ErrInvalidMessageContent = errors.New("message text has a character that XML cannot represent")
+
+// isValidXMLChar returns true if r is allowed in an XML 1.0 document.
+func isValidXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) || (r >= 0x10000 && r <= 0x10FFFF)
+}
+
+// validateMessageText returns a *MessageTextValidationError if text contains a character that XML cannot represent.
+// Without this check, encoding/xml silently replaces such characters with U+FFFD.
+func validateMessageText(text string) error {
+	for i, r := range text {
+		if !isValidXMLChar(r) || (r == utf8.RuneError && !strings.HasPrefix(text[i:], string(utf8.RuneError))) {
+			return &MessageTextValidationError{Offset: i, Char: r}
+		}
+	}
+	return nil
+}
+
+// ValidateMessageEncoding returns a *MessageTextValidationError if text is not valid UTF-8 or contains a character
+// that XML cannot represent (U+0000 to U+0008, U+000B, U+000C, U+000E to U+001F, U+FFFE, or U+FFFF).
+// Enqueue and Update perform this check before sending a request; call it to check text earlier, for example, when
+// it is received from another system.
+func ValidateMessageEncoding(text string) error {
+	return validateMessageText(text)
+}
+
+// StripInvalidXMLChars returns text without the characters that XML cannot represent (see
+// MessageTextValidationError); invalid UTF-8 bytes are removed too. Call it to enqueue text from untrusted sources
+// when losing such characters is acceptable; otherwise, base64-encode the text.
+func StripInvalidXMLChars(text string) string {
+	if validateMessageText(text) == nil {
+		return text
+	}
+	b := strings.Builder{}
+	for i, r := range text {
+		if isValidXMLChar(r) && (r != utf8.RuneError || strings.HasPrefix(text[i:], string(utf8.RuneError))) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
 // EnqueueMessageResponse holds the results of a successfully-enqueued message.
 type EnqueueMessageResponse struct {
 	inner      *EnqueueResponse
@@ -112,6 +382,7 @@ type EnqueueMessageResponse struct {
 	PopReceipt PopReceipt
 
 	// TimeNextVisible returns the time when the message next becomes visible.
+	// Like the other times, it is in UTC with a precision of 1 second.
 	TimeNextVisible time.Time
 
 	// InsertionTime returns the time when the message was enqueued.
@@ -119,6 +390,10 @@ type EnqueueMessageResponse struct {
 
 	// ExpirationTime returns the time when the message will automatically be deleted from the queue.
 	ExpirationTime time.Time
+
+	// EncodedSize returns the size, in bytes, of the message text as sent in the request body (that is, XML-escaped),
+	// which is what counts toward QueueMessageMaxBytes.
+	EncodedSize int
 }
 
 // Response returns the raw HTTP response object.
@@ -138,7 +413,7 @@ func (emr EnqueueMessageResponse) Status() string {
 
 // Date returns the value for header Date.
 func (emr EnqueueMessageResponse) Date() time.Time {
-	return emr.inner.Date()
+	return headerTime(emr.Response(), "Date")
 }
 
 // RequestID returns the value for header x-ms-request-id.
@@ -155,15 +430,110 @@ func (emr EnqueueMessageResponse) Version() string {
 
 // Dequeue retrieves one or more messages from the front of the queue.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-messages.
+// If ctx is canceled or exceeds its deadline, Dequeue returns an *OperationCanceledError that reports whether the
+// request reached the network (in which case messages may have been dequeued and are invisible until visibilityTimeout
+// expires). See WithShutdownVisibilityTimeout to bound that time during shutdown.
 func (m MessagesURL) Dequeue(ctx context.Context, maxMessages int32, visibilityTimeout time.Duration) (*DequeuedMessagesResponse, error) {
-	vt := int32(visibilityTimeout.Seconds())
-	qml, err := m.client.Dequeue(ctx, &maxMessages, &vt, nil, nil)
-	return &DequeuedMessagesResponse{inner: qml}, err
+	return m.dequeue(ctx, &maxMessages, &visibilityTimeout)
+}
+
+// DequeueOptions configures DequeueWithOptions's behavior. A nil field omits the corresponding query parameter so the
+// service's default applies.
+type DequeueOptions struct {
+	// MaxMessages is the number of messages to retrieve, from 1 to QueueMaxMessagesDequeue (nil=service default of 1).
+	MaxMessages *int32
+
+	// VisibilityTimeout is how long the retrieved messages are invisible to other consumers, from 1 second to 7 days
+	// (nil=service default of 30 seconds). It is rounded down to whole seconds.
+	VisibilityTimeout *time.Duration
+}
+
+// validate returns an error if any option is outside the range accepted by the service.
+func (o DequeueOptions) validate() error {
+	if o.MaxMessages != nil && (*o.MaxMessages < 1 || *o.MaxMessages > QueueMaxMessagesDequeue) {
+		return fmt.Errorf("MaxMessages must be from 1 to %d", QueueMaxMessagesDequeue)
+	}
+	if o.VisibilityTimeout != nil && (*o.VisibilityTimeout < time.Second || *o.VisibilityTimeout > maxVisibilityTimeout) {
+		return errors.New("VisibilityTimeout must be from 1 second to 7 days")
+	}
+	return nil
+}
+
+const (
+	// defaultVisibilityTimeout is the visibility timeout the service uses when Dequeue's request doesn't specify one.
+	defaultVisibilityTimeout = 30 * time.Second
+
+	// maxVisibilityTimeout is the longest visibility timeout the service accepts.
+	maxVisibilityTimeout = 7 * 24 * time.Hour
+)
+
+// DequeueWithOptions is like Dequeue but lets the service choose the number of messages and the visibility timeout
+// when the corresponding option is nil. An error is returned, without sending a request, if an option is out of range.
+// NOTE: The Queue service does not long-poll; a dequeue returns immediately, with no messages if none are visible.
+// The "timeout" query parameter sent with each request is the server-side operation timeout derived from
+// RetryOptions.TryTimeout, not a wait for messages to arrive. To wait for messages, call DequeueWithBackoff.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-messages.
+func (m MessagesURL) DequeueWithOptions(ctx context.Context, o DequeueOptions) (*DequeuedMessagesResponse, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return m.dequeue(ctx, o.MaxMessages, o.VisibilityTimeout)
+}
+
+// dequeue implements Dequeue and DequeueWithOptions; nil arguments are omitted from the request.
+func (m MessagesURL) dequeue(ctx context.Context, maxMessages *int32, visibilityTimeout *time.Duration) (*DequeuedMessagesResponse, error) {
+	if s, ok := ctx.Value(shutdownVisibilityTimeoutContextKey{}).(shutdownVisibilityTimeout); ok && s.shuttingDown() {
+		current := defaultVisibilityTimeout
+		if visibilityTimeout != nil {
+			current = *visibilityTimeout
+		}
+		if s.timeout < current {
+			visibilityTimeout = &s.timeout
+		}
+	}
+	var vt *int32
+	if visibilityTimeout != nil {
+		seconds := int32(visibilityTimeout.Seconds())
+		vt = &seconds
+	}
+	trackedCtx, tracker := trackOperation(ctx, "Dequeue")
+	qml, err := m.client.Dequeue(withOperationName(trackedCtx, "Dequeue"), maxMessages, vt, nil, nil)
+	return &DequeuedMessagesResponse{inner: qml, receivedAt: time.Now()}, tracker.wrap(ctx, err)
+}
+
+// shutdownVisibilityTimeoutContextKey is the context key used by WithShutdownVisibilityTimeout.
+type shutdownVisibilityTimeoutContextKey struct{}
+
+// shutdownVisibilityTimeout is the value stored by WithShutdownVisibilityTimeout.
+type shutdownVisibilityTimeout struct {
+	shutdown <-chan struct{}
+	timeout  time.Duration
+}
+
+// shuttingDown returns true once the shutdown channel is closed.
+func (s shutdownVisibilityTimeout) shuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithShutdownVisibilityTimeout returns a context that makes Dequeue calls made with it use at most visibilityTimeout
+// once shutdown is closed. A consumer that stops after one final Dequeue can then return any messages it does not get to
+// process to the queue quickly, instead of leaving them invisible for the usual visibility timeout. For example:
+//
+//	ctx = azqueue.WithShutdownVisibilityTimeout(ctx, stopping, 5*time.Second)
+//	dequeue, err := messagesURL.Dequeue(ctx, 32, 10*time.Minute) // Uses 5 seconds once stopping is closed
+func WithShutdownVisibilityTimeout(ctx context.Context, shutdown <-chan struct{}, visibilityTimeout time.Duration) context.Context {
+	return context.WithValue(ctx, shutdownVisibilityTimeoutContextKey{}, shutdownVisibilityTimeout{shutdown: shutdown, timeout: visibilityTimeout})
 }
 
 // DequeueMessagesResponse holds the results of a successful call to Dequeue.
 type DequeuedMessagesResponse struct {
-	inner *QueueMessagesList
+	inner      *QueueMessagesList
+	receivedAt time.Time // Local time when the response was received
 }
 
 // Response returns the raw HTTP response object.
@@ -183,7 +553,7 @@ func (dmr DequeuedMessagesResponse) Status() string {
 
 // Date returns the value for header Date.
 func (dmr DequeuedMessagesResponse) Date() time.Time {
-	return dmr.inner.Date()
+	return headerTime(dmr.Response(), "Date")
 }
 
 // RequestID returns the value for header x-ms-request-id.
@@ -204,6 +574,10 @@ func (dmr DequeuedMessagesResponse) NumMessages() int32 {
 // Message returns the information for dequeued message.
 func (dmr DequeuedMessagesResponse) Message(index int32) *DequeuedMessage {
 	v := dmr.inner.Items[index]
+	var dequeueTime time.Time
+	if dmr.inner.Response() != nil { // Clients other than the generated one might not return the HTTP response
+		dequeueTime = dmr.Date()
+	}
 	return &DequeuedMessage{
 		ID:              MessageID(v.MessageID),
 		InsertionTime:   v.InsertionTime,
@@ -212,10 +586,14 @@ func (dmr DequeuedMessagesResponse) Message(index int32) *DequeuedMessage {
 		NextVisibleTime: v.TimeNextVisible,
 		Text:            v.MessageText,
 		DequeueCount:    v.DequeueCount,
+		dequeueTime:     dequeueTime,
+		receivedAt:      dmr.receivedAt,
 	}
 }
 
-// DequeuedMessage holds the properties of a single dequeued message.
+// DequeuedMessage holds the properties of a single dequeued message. Its times come from the service, which reports
+// them in UTC with a precision of 1 second; compare them to the local clock with VisibleIn or QueueLatency rather
+// than directly, since the local time has finer precision and may drift.
 type DequeuedMessage struct {
 	ID              MessageID
 	InsertionTime   time.Time
@@ -224,6 +602,71 @@ type DequeuedMessage struct {
 	NextVisibleTime time.Time
 	DequeueCount    int64
 	Text            string // UTF-8 string
+
+	dequeueTime time.Time // The service's time (its Date header) when the message was dequeued
+	receivedAt  time.Time // Local time when the Dequeue response was received
+}
+
+// QueueLatency returns how long the message was in the queue before it was dequeued: the service's time when it was
+// dequeued (from the Dequeue response's Date header) minus its InsertionTime. Since both times come from the service,
+// the result is not affected by the local clock's drift. If the time the message was dequeued is unknown (for example,
+// if msg was not returned by Dequeue), the local clock's current time is used.
+func (msg *DequeuedMessage) QueueLatency() time.Duration {
+	dequeueTime := msg.dequeueTime
+	if dequeueTime.IsZero() {
+		dequeueTime = time.Now()
+	}
+	return nonNegative(dequeueTime.Sub(msg.InsertionTime))
+}
+
+// Age returns how long ago the message was inserted into the queue, estimating the service's current time as the time
+// the message was dequeued (see QueueLatency) plus the time elapsed locally since the Dequeue response was received.
+func (msg *DequeuedMessage) Age() time.Duration {
+	if msg.dequeueTime.IsZero() {
+		return nonNegative(time.Since(msg.InsertionTime))
+	}
+	return nonNegative(msg.dequeueTime.Add(time.Since(msg.receivedAt)).Sub(msg.InsertionTime))
+}
+
+// VisibleIn returns how long after now the message becomes visible to other consumers again, or 0 if it already is
+// (or becomes visible within the same second, given the service's 1-second time precision).
+func (msg *DequeuedMessage) VisibleIn(now time.Time) time.Duration {
+	return nonNegative(msg.NextVisibleTime.Sub(now))
+}
+
+// nonNegative returns d, or 0 if d is negative (the service's times have a resolution of 1 second).
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// parseRFC1123 parses a time sent by the service, such as a message's InsertionTime or a response's Date header. The
+// service sends RFC1123 times in GMT but some emulators send a numeric zone (RFC1123Z), so both are accepted. The
+// result is in UTC and truncated to the 1-second precision of the format.
+func parseRFC1123(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC1123, s)
+	if err != nil {
+		var errZ error
+		if t, errZ = time.Parse(time.RFC1123Z, s); errZ != nil {
+			return time.Time{}, err
+		}
+	}
+	return t.UTC().Truncate(time.Second), nil
+}
+
+// headerTime returns the time in r's header key parsed by parseRFC1123, or the zero time if the header is missing or
+// invalid (or r is nil).
+func headerTime(r *http.Response, key string) time.Time {
+	if r == nil {
+		return time.Time{}
+	}
+	t, err := parseRFC1123(r.Header.Get(key))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -231,7 +674,7 @@ type DequeuedMessage struct {
 // Peek retrieves one or more messages from the front of the queue but does not alter the visibility of the message.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/peek-messages.
 func (m MessagesURL) Peek(ctx context.Context, maxMessages int32) (*PeekedMessagesResponse, error) {
-	pr, err := m.client.Peek(ctx, &maxMessages, nil, nil)
+	pr, err := m.client.Peek(withOperationName(ctx, "Peek"), &maxMessages, nil, nil)
 	return &PeekedMessagesResponse{inner: pr}, err
 }
 
@@ -257,7 +700,7 @@ func (pmr PeekedMessagesResponse) Status() string {
 
 // Date returns the value for header Date.
 func (pmr PeekedMessagesResponse) Date() time.Time {
-	return pmr.inner.Date()
+	return headerTime(pmr.Response(), "Date")
 }
 
 // RequestID returns the value for header x-ms-request-id.
@@ -2,8 +2,15 @@ package azqueue
 
 import (
 	"context"
+	"errors"
+	"math"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"fmt"
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -22,26 +29,48 @@ const (
 	QueueMessageMaxBytes = 64 * 1024 // 64KB
 )
 
-// A QueueURL represents a URL to the Azure Storage queue.
+// Queue is the set of queue operations implemented by QueueURL. Application code can depend on this interface
+// instead of QueueURL so that unit tests can substitute a fake (see the azqueue/mock package).
+// Methods that construct other URL objects or that are built on top of these operations are not part of the interface.
+type Queue interface {
+	Create(ctx context.Context, metadata Metadata) (*QueueCreateResponse, error)
+	Delete(ctx context.Context) (*QueueDeleteResponse, error)
+	GetProperties(ctx context.Context) (*QueueGetPropertiesResponse, error)
+	SetMetadata(ctx context.Context, metadata Metadata) (*QueueSetMetadataResponse, error)
+	GetAccessPolicy(ctx context.Context) (*SignedIdentifiers, error)
+	SetAccessPolicy(ctx context.Context, permissions []SignedIdentifier) (*QueueSetAccessPolicyResponse, error)
+}
+
+var _ Queue = QueueURL{}
+
+// A QueueURL represents a URL to the Azure Storage queue. Like ServiceURL, it is immutable and safe for concurrent use
+// by multiple goroutines. The URL returned by its URL method is a copy that callers may modify.
 type QueueURL struct {
-	client queueClient
+	client QueueClient
 }
 
 // NewQueueURL creates a QueueURL object using the specified URL and request policy pipeline.
 func NewQueueURL(url url.URL, p pipeline.Pipeline) QueueURL {
-	client := newQueueClient(url, p)
+	client := accessPolicyQueueClient{newQueueClient(url, newResponsePipeline(p))}
 	return QueueURL{client: client}
 }
 
-// URL returns the URL endpoint used by the QueueURL object.
+// NewQueueURLFromClient creates a QueueURL object that sends its requests using the specified client instead of the
+// generated one. See QueueClient.
+func NewQueueURLFromClient(client QueueClient) QueueURL {
+	return QueueURL{client: client}
+}
+
+// URL returns a copy of the URL endpoint used by the QueueURL object; pass it to NewQueueURLParts to examine or
+// change its parts.
 func (q QueueURL) URL() url.URL {
 	return q.client.URL()
 }
 
-// String returns the URL as a string.
+// String returns the URL as a string with the value of any SAS signature ('sig' query parameter) replaced by
+// "[REDACTED]" so that it can be safely logged. Call URL to get the URL including the signature.
 func (q QueueURL) String() string {
-	u := q.URL()
-	return u.String()
+	return redactedURLString(q.URL())
 }
 
 // WithPipeline creates a new QueueURL object identical to the source but with the specified request policy pipeline.
@@ -49,6 +78,42 @@ func (q QueueURL) WithPipeline(p pipeline.Pipeline) QueueURL {
 	return NewQueueURL(q.URL(), p)
 }
 
+// WithRetryOptions creates a new QueueURL object identical to the source but whose pipeline uses the specified retry
+// options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't created by
+// NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (q QueueURL) WithRetryOptions(o RetryOptions) (QueueURL, error) {
+	p, err := withPipelineOptions(q.client.Pipeline(), func(po *PipelineOptions) { po.Retry = o })
+	if err != nil {
+		return q, err
+	}
+	return NewQueueURL(q.URL(), p), nil
+}
+
+// WithRequestLogOptions creates a new QueueURL object identical to the source but whose pipeline uses the specified
+// request log options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't
+// created by NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (q QueueURL) WithRequestLogOptions(o RequestLogOptions) (QueueURL, error) {
+	p, err := withPipelineOptions(q.client.Pipeline(), func(po *PipelineOptions) { po.RequestLog = o })
+	if err != nil {
+		return q, err
+	}
+	return NewQueueURL(q.URL(), p), nil
+}
+
+// ServiceURL creates a new ServiceURL object for the storage account containing this queue by removing the queue name
+// from the end of QueueURL's URL. The new ServiceURL uses the same request policy pipeline and URL query (including
+// any SAS) as the QueueURL. This works for path-style URLs (like Azurite's) too since only the queue name is removed.
+func (q QueueURL) ServiceURL() ServiceURL {
+	u, _ := splitURLPath(q.URL())
+	return NewServiceURL(u, q.client.Pipeline())
+}
+
+// QueueName returns the name of the queue, which is the last segment of QueueURL's URL path.
+func (q QueueURL) QueueName() string {
+	_, name := splitURLPath(q.URL())
+	return name
+}
+
 // NewMessagesURL creates a new MessagesURL object by concatenating "messages" to the end of
 // QueueURL's URL. The new MessagesURL uses the same request policy pipeline as the QueueURL.
 // To change the pipeline, create the MessagesURL and then call its WithPipeline method passing in the
@@ -62,45 +127,350 @@ func (q QueueURL) NewMessagesURL() MessagesURL {
 // Create creates a queue within a storage account.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/create-queue4.
 func (q QueueURL) Create(ctx context.Context, metadata Metadata) (*QueueCreateResponse, error) {
-	return q.client.Create(ctx, nil, metadata, nil)
+	return q.client.Create(withOperationName(ctx, "CreateQueue"), nil, metadata, nil)
+}
+
+// CreateWithRetryOnBeingDeleted creates a queue like Create but, if the service reports that a queue with the same name
+// is still being deleted (ServiceCodeQueueBeingDeleted), retries with an increasing delay until the queue is created or
+// maxWait elapses. If maxWait elapses, the most recent service error is returned. Deleting a queue can take 40 seconds
+// or more so this is useful when recreating a queue immediately after deleting it.
+func (q QueueURL) CreateWithRetryOnBeingDeleted(ctx context.Context, metadata Metadata, maxWait time.Duration) (*QueueCreateResponse, error) {
+	deadline := time.Now().Add(maxWait)
+	delay := time.Second
+	for {
+		resp, err := q.Create(ctx, metadata)
+		if stgErr, ok := asStorageError(err); !ok || stgErr.ServiceCode() != ServiceCodeQueueBeingDeleted {
+			return resp, err // Success or an error that retrying won't fix
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+		if !sleepWithContext(ctx, delay) {
+			return nil, ctx.Err()
+		}
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+}
+
+// QueueCreateOrGetPropertiesResponse is returned by CreateOrGetProperties. It embeds the queue's properties.
+type QueueCreateOrGetPropertiesResponse struct {
+	*QueueGetPropertiesResponse
+
+	// Created is true if this call created the queue and false if the queue already existed.
+	Created bool
+}
+
+// CreateOrGetProperties creates the queue with the specified metadata if it doesn't exist and then returns its
+// properties, reporting whether it was created. If the queue already exists, its metadata is left unchanged (even if it
+// differs from metadata) and its existing properties are returned. This sends two requests: Create, then GetProperties.
+func (q QueueURL) CreateOrGetProperties(ctx context.Context, metadata Metadata) (*QueueCreateOrGetPropertiesResponse, error) {
+	created := false
+	resp, err := q.Create(ctx, metadata)
+	if err == nil {
+		// The service returns 204 No Content instead of 201 Created if the queue exists with the same metadata
+		created = resp.StatusCode() == http.StatusCreated
+	} else if stgErr, ok := asStorageError(err); !ok || stgErr.ServiceCode() != ServiceCodeQueueAlreadyExists {
+		return nil, err
+	}
+	props, err := q.GetProperties(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueCreateOrGetPropertiesResponse{QueueGetPropertiesResponse: props, Created: created}, nil
 }
 
 // Delete permanently deletes a queue.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/delete-queue3.
 func (q QueueURL) Delete(ctx context.Context) (*QueueDeleteResponse, error) {
-	return q.client.Delete(ctx, nil, nil)
+	return q.client.Delete(withOperationName(ctx, "DeleteQueue"), nil, nil)
 }
 
 // GetProperties retrieves queue properties and user-defined metadata and properties on the specified queue.
-// Metadata is associated with the queue as name-values pairs.
+// Metadata is associated with the queue as name-values pairs. Each call to the response's NewMetadata method returns a
+// new map built from the response's headers, so callers may modify it without affecting the response or other callers.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-queue-metadata.
 func (q QueueURL) GetProperties(ctx context.Context) (*QueueGetPropertiesResponse, error) {
-	return q.client.GetProperties(ctx, nil, nil)
+	return q.client.GetProperties(withOperationName(ctx, "GetQueueProperties"), nil, nil)
+}
+
+// ApproximateMessagesCount returns the value for header x-ms-approximate-messages-count.
+// Counts larger than math.MaxInt32 are saturated to math.MaxInt32; use ApproximateMessagesCountInt64 instead.
+func (qgpr QueueGetPropertiesResponse) ApproximateMessagesCount() int32 {
+	i := qgpr.ApproximateMessagesCountInt64()
+	if i > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(i)
+}
+
+// ApproximateMessagesCountInt64 returns the value for header x-ms-approximate-messages-count as a 64-bit integer.
+func (qgpr QueueGetPropertiesResponse) ApproximateMessagesCountInt64() int64 {
+	s := qgpr.rawResponse.Header.Get("x-ms-approximate-messages-count")
+	if s == "" {
+		return -1
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		i = 0
+	}
+	return i
+}
+
+// Exists returns true if the queue exists. It returns false (with a nil error) only if the service reports that the
+// queue was not found; any other failure, including an authorization failure, is returned as an error.
+func (q QueueURL) Exists(ctx context.Context) (bool, error) {
+	_, err := q.GetProperties(ctx)
+	if err == nil {
+		return true, nil
+	}
+	if stgErr, ok := asStorageError(err); ok && stgErr.ServiceCode() == ServiceCodeQueueNotFound {
+		return false, nil
+	}
+	return false, err
 }
 
 // SetMetadata sets user-defined metadata on the specified queue. Metadata is associated with the queue as name-value pairs.
+// SetMetadata reads metadata while building the request and does not retain it; metadata must not be modified
+// concurrently with the call (use Clone to give SetMetadata a private copy).
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/set-queue-metadata.
 func (q QueueURL) SetMetadata(ctx context.Context, metadata Metadata) (*QueueSetMetadataResponse, error) {
-	return q.client.SetMetadata(ctx, nil, metadata, nil)
+	return q.client.SetMetadata(withOperationName(ctx, "SetQueueMetadata"), nil, metadata, nil)
+}
+
+// UpdateMetadata performs a read-modify-write of the queue's metadata: it gets the queue's current metadata, passes it
+// to mutate, and sets the queue's metadata to the map that mutate returns. Use this instead of SetMetadata to add or
+// remove individual keys without replacing keys set by others.
+// WARNING: Queues have no ETag or If-Match support, so this operation is not atomic and cannot detect a concurrent
+// modification. If another client sets the queue's metadata between the get and the set, that client's changes are
+// lost. Coordinate writers externally if lost updates are unacceptable.
+func (q QueueURL) UpdateMetadata(ctx context.Context, mutate func(Metadata) Metadata) (*QueueSetMetadataResponse, error) {
+	return q.readModifyWriteMetadata(ctx, func(current Metadata) (Metadata, bool) { return mutate(current), true })
+}
+
+// CompareAndSetMetadata gets the queue's metadata and, if it is exactly expected (the same keys with the same values;
+// keys are compared case-insensitively since the service returns them in lowercase), sets the queue's metadata to
+// updated. It returns (true, nil) if the metadata was set, (false, nil) if the current metadata differs from expected,
+// and (false, err) if a request failed.
+// WARNING: Queues have no ETag or If-Match support, so the check and the set are separate requests and this operation
+// is not atomic: a change made by another client between them is not detected and is lost. Like UpdateMetadata, it
+// only narrows the window for lost updates; coordinate writers externally if they are unacceptable.
+func (q QueueURL) CompareAndSetMetadata(ctx context.Context, expected, updated Metadata) (bool, error) {
+	matched := false
+	_, err := q.readModifyWriteMetadata(ctx, func(current Metadata) (Metadata, bool) {
+		if len(current) != len(expected) {
+			return nil, false
+		}
+		for k, v := range expected {
+			if cv, ok := current[strings.ToLower(k)]; !ok || cv != v {
+				return nil, false
+			}
+		}
+		matched = true
+		return updated, true
+	})
+	return matched && err == nil, err
+}
+
+// readModifyWriteMetadata gets the queue's metadata and passes it to mutate; if mutate returns true, the queue's
+// metadata is set to the map it returns. It returns a nil response if mutate returns false or a request failed.
+func (q QueueURL) readModifyWriteMetadata(ctx context.Context, mutate func(Metadata) (Metadata, bool)) (*QueueSetMetadataResponse, error) {
+	props, err := q.GetProperties(ctx)
+	if err != nil {
+		return nil, err
+	}
+	updated, ok := mutate(props.NewMetadata())
+	if !ok {
+		return nil, nil
+	}
+	return q.SetMetadata(ctx, updated)
+}
+
+// Clone returns a copy of the metadata that can be modified without affecting the original; Clone returns nil if
+// md is nil.
+func (md Metadata) Clone() Metadata {
+	if md == nil {
+		return nil
+	}
+	clone := make(Metadata, len(md))
+	for k, v := range md {
+		clone[k] = v
+	}
+	return clone
 }
 
 // GetAccessPolicy returns details about any stored access policies specified on the queue that may be used with
-// Shared Access Signatures.
+// Shared Access Signatures. Call AccessPolicyPermission's Parse method to examine a policy's Permission field.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-queue-acl.
 func (q QueueURL) GetAccessPolicy(ctx context.Context) (*SignedIdentifiers, error) {
-	return q.client.GetAccessPolicy(ctx, nil, nil)
+	return q.client.GetAccessPolicy(withOperationName(ctx, "GetQueueAccessPolicy"), nil, nil)
 }
 
 // SetAccessPolicy sets sets stored access policies for the queue that may be used with Shared Access Signatures.
+// The signed identifiers are validated before the request is sent; if validation fails, a
+// *SignedIdentifierValidationError is returned.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/set-queue-acl.
 func (q QueueURL) SetAccessPolicy(ctx context.Context, permissions []SignedIdentifier) (*QueueSetAccessPolicyResponse, error) {
-	return q.client.SetAccessPolicy(ctx, permissions, nil, nil)
+	if err := validateSignedIdentifiers(permissions); err != nil {
+		return nil, err
+	}
+	return q.client.SetAccessPolicy(withOperationName(ctx, "SetQueueAccessPolicy"), permissions, nil, nil)
+}
+
+// NewSignedIdentifier creates a SignedIdentifier with the specified ID and an empty access policy. Use the With, Starting,
+// and Expiring methods to set the access policy's fields; any field left unset is omitted from the request so that a
+// SAS referencing this identifier must supply it. For example:
+//     si := azqueue.NewSignedIdentifier("readers").WithPermissions(azqueue.AccessPolicyPermission{Read: true}).StartingNow().ExpiringIn(24 * time.Hour)
+func NewSignedIdentifier(id string) SignedIdentifier {
+	return SignedIdentifier{ID: id}
+}
+
+// WithPermissions returns a copy of the SignedIdentifier whose access policy grants the specified permissions.
+func (si SignedIdentifier) WithPermissions(p AccessPolicyPermission) SignedIdentifier {
+	si.AccessPolicy.Permission = p.String()
+	return si
+}
+
+// StartingAt returns a copy of the SignedIdentifier whose access policy becomes active at the specified time.
+func (si SignedIdentifier) StartingAt(t time.Time) SignedIdentifier {
+	si.AccessPolicy.Start = t.UTC()
+	return si
+}
+
+// StartingNow returns a copy of the SignedIdentifier whose access policy becomes active at the current time.
+func (si SignedIdentifier) StartingNow() SignedIdentifier {
+	return si.StartingAt(time.Now())
+}
+
+// ExpiringAt returns a copy of the SignedIdentifier whose access policy expires at the specified time.
+func (si SignedIdentifier) ExpiringAt(t time.Time) SignedIdentifier {
+	si.AccessPolicy.Expiry = t.UTC()
+	return si
+}
+
+// ExpiringIn returns a copy of the SignedIdentifier whose access policy expires d after its start time or,
+// if no start time has been set, d after the current time.
+func (si SignedIdentifier) ExpiringIn(d time.Duration) SignedIdentifier {
+	start := si.AccessPolicy.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return si.ExpiringAt(start.Add(d))
+}
+
+// StartTime returns the time the access policy becomes active, or nil if it has no start time (it is open-ended, so a
+// SAS referencing it must supply its start time, or it is active immediately).
+func (ap AccessPolicy) StartTime() *time.Time {
+	return timeOrNil(ap.Start)
+}
+
+// ExpiryTime returns the time the access policy expires, or nil if it has no expiry time (it is open-ended, so a SAS
+// referencing it must supply its expiry time).
+func (ap AccessPolicy) ExpiryTime() *time.Time {
+	return timeOrNil(ap.Expiry)
+}
+
+// timeOrNil returns a pointer to a copy of t, or nil if t is the zero time.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+const (
+	// QueueMaxSignedIdentifiers indicates the maximum number of stored access policies a queue may have (5).
+	QueueMaxSignedIdentifiers = 5
+
+	// SignedIdentifierMaxIDLength indicates the maximum number of characters in a SignedIdentifier's ID (64).
+	SignedIdentifierMaxIDLength = 64
+)
+
+// SignedIdentifierViolation identifies the reason a SignedIdentifier failed validation. See the SignedIdentifierViolation* constants.
+type SignedIdentifierViolation int32
+
+const (
+	// SignedIdentifierViolationTooMany indicates that more than QueueMaxSignedIdentifiers identifiers were specified.
+	SignedIdentifierViolationTooMany SignedIdentifierViolation = 1
+
+	// SignedIdentifierViolationDuplicateID indicates that the identifier's ID is used by an earlier identifier.
+	SignedIdentifierViolationDuplicateID SignedIdentifierViolation = 2
+
+	// SignedIdentifierViolationIDTooLong indicates that the identifier's ID exceeds SignedIdentifierMaxIDLength characters.
+	SignedIdentifierViolationIDTooLong SignedIdentifierViolation = 3
+
+	// SignedIdentifierViolationStartNotBeforeExpiry indicates that the access policy's Start is not before its Expiry.
+	SignedIdentifierViolationStartNotBeforeExpiry SignedIdentifierViolation = 4
+
+	// SignedIdentifierViolationInvalidPermission indicates that the access policy's Permission could not be parsed.
+	// Letters other than 'r', 'a', 'u', and 'p' are not a violation; they are passed through to the service.
+	SignedIdentifierViolationInvalidPermission SignedIdentifierViolation = 5
+)
+
+// SignedIdentifierValidationError is returned when a SignedIdentifier fails client-side validation.
+type SignedIdentifierValidationError struct {
+	// Index is the position of the invalid SignedIdentifier (-1 if the violation applies to all of them).
+	Index int
+
+	// ID is the invalid SignedIdentifier's ID ("" if the violation applies to all of them).
+	ID string
+
+	// Violation indicates which rule the SignedIdentifier violated.
+	Violation SignedIdentifierViolation
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *SignedIdentifierValidationError) Error() string {
+	switch e.Violation {
+	case SignedIdentifierViolationTooMany:
+		return fmt.Sprintf("a queue may have at most %d signed identifiers", QueueMaxSignedIdentifiers)
+	case SignedIdentifierViolationDuplicateID:
+		return fmt.Sprintf("signed identifier %d: ID '%s' is not unique", e.Index, e.ID)
+	case SignedIdentifierViolationIDTooLong:
+		return fmt.Sprintf("signed identifier %d: ID '%s' is longer than %d characters", e.Index, e.ID, SignedIdentifierMaxIDLength)
+	case SignedIdentifierViolationStartNotBeforeExpiry:
+		return fmt.Sprintf("signed identifier %d: ID '%s' has a Start time that is not before its Expiry time", e.Index, e.ID)
+	case SignedIdentifierViolationInvalidPermission:
+		return fmt.Sprintf("signed identifier %d: ID '%s' has an invalid permission string", e.Index, e.ID)
+	}
+	return fmt.Sprintf("signed identifier %d: ID '%s' is invalid", e.Index, e.ID)
+}
+
+// validateSignedIdentifiers checks the signed identifiers against the service's rules so that
+// callers get a descriptive error instead of an HTTP 400 response.
+func validateSignedIdentifiers(identifiers []SignedIdentifier) error {
+	if len(identifiers) > QueueMaxSignedIdentifiers {
+		return &SignedIdentifierValidationError{Index: -1, Violation: SignedIdentifierViolationTooMany}
+	}
+	ids := map[string]struct{}{}
+	for i, si := range identifiers {
+		violation := SignedIdentifierViolation(0)
+		if _, ok := ids[si.ID]; ok {
+			violation = SignedIdentifierViolationDuplicateID
+		} else if utf8.RuneCountInString(si.ID) > SignedIdentifierMaxIDLength {
+			violation = SignedIdentifierViolationIDTooLong
+		} else if ap := si.AccessPolicy; !ap.Start.IsZero() && !ap.Expiry.IsZero() && !ap.Start.Before(ap.Expiry) {
+			violation = SignedIdentifierViolationStartNotBeforeExpiry
+		} else if p := (AccessPolicyPermission{}); p.Parse(si.AccessPolicy.Permission) != nil {
+			violation = SignedIdentifierViolationInvalidPermission
+		}
+		if violation != 0 {
+			return &SignedIdentifierValidationError{Index: i, ID: si.ID, Violation: violation}
+		}
+		ids[si.ID] = struct{}{}
+	}
+	return nil
 }
 
 // The AccessPolicyPermission type simplifies creating the permissions string for a queue's access policy.
 // Initialize an instance of this type and then call its String method to set AccessPolicy's Permission field.
 type AccessPolicyPermission struct {
 	Read, Add, Update, ProcessMessages bool
+
+	// Extra holds any permission letters that Parse did not recognize, such as permissions added to the service after
+	// this version of the package, so that String can reproduce them and SetAccessPolicy passes them to the service.
+	Extra string
 }
 
 // String produces the access policy permission string for an Azure Storage queue.
@@ -119,10 +489,35 @@ func (p AccessPolicyPermission) String() string {
 	if p.ProcessMessages {
 		b.WriteRune('p')
 	}
+	b.WriteString(p.Extra)
 	return b.String()
 }
 
-// Parse initializes the AccessPolicyPermission's fields from a string.
+// Equal returns true if p and other grant the same permissions, including the same Extra letters in any order.
+func (p AccessPolicyPermission) Equal(other AccessPolicyPermission) bool {
+	pExtra, otherExtra := p.Extra, other.Extra
+	p.Extra, other.Extra = "", ""
+	return p == other && sortedRunes(pExtra) == sortedRunes(otherExtra)
+}
+
+// sortedRunes returns s with its characters sorted.
+func sortedRunes(s string) string {
+	r := []rune(s)
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return string(r)
+}
+
+// Validate returns an error if the AccessPolicyPermission grants no permissions.
+func (p AccessPolicyPermission) Validate() error {
+	if p == (AccessPolicyPermission{}) {
+		return errors.New("access policy permission must grant at least one of Read, Add, Update, or ProcessMessages")
+	}
+	return nil
+}
+
+// Parse initializes the AccessPolicyPermission's fields from a string, such as an AccessPolicy's Permission field
+// returned by GetAccessPolicy. Unrecognized letters are kept in Extra rather than causing an error so that permissions
+// added to the service later survive a round trip through Parse and String. Parse always returns nil.
 func (p *AccessPolicyPermission) Parse(s string) error {
 	*p = AccessPolicyPermission{} // Clear the flags
 	for _, r := range s {
@@ -136,7 +531,9 @@ func (p *AccessPolicyPermission) Parse(s string) error {
 		case 'p':
 			p.ProcessMessages = true
 		default:
-			return fmt.Errorf("invalid permission: '%v'", r)
+			if !strings.ContainsRune(p.Extra, r) {
+				p.Extra += string(r)
+			}
 		}
 	}
 	return nil
@@ -2,30 +2,55 @@ package azqueue
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"net/url"
+	"strings"
+	"sync"
 )
 
+// QueueService is the set of Queue service operations implemented by ServiceURL. Application code can depend on
+// this interface instead of ServiceURL so that unit tests can substitute a fake (see the azqueue/mock package).
+// Methods that construct other URL objects or that are built on top of these operations are not part of the interface.
+type QueueService interface {
+	ListQueuesSegment(ctx context.Context, marker Marker, o ListQueuesSegmentOptions) (*ListQueuesSegmentResponse, error)
+	GetProperties(ctx context.Context) (*StorageServiceProperties, error)
+	SetProperties(ctx context.Context, properties StorageServiceProperties) (*ServiceSetPropertiesResponse, error)
+	GetStatistics(ctx context.Context) (*StorageServiceStats, error)
+}
+
+var _ QueueService = ServiceURL{}
+
 // A ServiceURL represents a URL to the Azure Storage Queue service allowing you to manipulate queues.
+// A ServiceURL is immutable, so a single one may be shared by any number of goroutines: its methods never modify it,
+// and its With and New methods return new values.
 type ServiceURL struct {
-	client serviceClient
+	client ServiceClient
 }
 
 // NewServiceURL creates a ServiceURL object using the specified URL and request policy pipeline.
 func NewServiceURL(primaryURL url.URL, p pipeline.Pipeline) ServiceURL {
-	client := newServiceClient(primaryURL, p)
+	client := servicePropertiesServiceClient{newServiceClient(primaryURL, newResponsePipeline(p))}
 	return ServiceURL{client: client}
 }
 
-// URL returns the URL endpoint used by the ServiceURL object.
+// NewServiceURLFromClient creates a ServiceURL object that sends its requests using the specified client instead of the
+// generated one. See ServiceClient.
+func NewServiceURLFromClient(client ServiceClient) ServiceURL {
+	return ServiceURL{client: client}
+}
+
+// URL returns a copy of the URL endpoint used by the ServiceURL object; pass it to NewQueueURLParts to examine or
+// change its parts.
 func (s ServiceURL) URL() url.URL {
 	return s.client.URL()
 }
 
-// String returns the URL as a string.
+// String returns the URL as a string with the value of any SAS signature ('sig' query parameter) replaced by
+// "[REDACTED]" so that it can be safely logged. Call URL to get the URL including the signature.
 func (s ServiceURL) String() string {
-	u := s.URL()
-	return u.String()
+	return redactedURLString(s.URL())
 }
 
 // WithPipeline creates a new ServiceURL object identical to the source but with the specified request policy pipeline.
@@ -33,6 +58,43 @@ func (s ServiceURL) WithPipeline(p pipeline.Pipeline) ServiceURL {
 	return NewServiceURL(s.URL(), p)
 }
 
+// WithRetryOptions creates a new ServiceURL object identical to the source but whose pipeline uses the specified retry
+// options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't created by
+// NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (s ServiceURL) WithRetryOptions(o RetryOptions) (ServiceURL, error) {
+	p, err := withPipelineOptions(s.client.Pipeline(), func(po *PipelineOptions) { po.Retry = o })
+	if err != nil {
+		return s, err
+	}
+	return NewServiceURL(s.URL(), p), nil
+}
+
+// WithRequestLogOptions creates a new ServiceURL object identical to the source but whose pipeline uses the specified
+// request log options; the pipeline's credential and other options are unchanged. If the source's pipeline wasn't
+// created by NewPipeline or NewPipelineWithOptions, the source is returned along with ErrPipelineOptionsUnknown.
+func (s ServiceURL) WithRequestLogOptions(o RequestLogOptions) (ServiceURL, error) {
+	p, err := withPipelineOptions(s.client.Pipeline(), func(po *PipelineOptions) { po.RequestLog = o })
+	if err != nil {
+		return s, err
+	}
+	return NewServiceURL(s.URL(), p), nil
+}
+
+// SecondaryURL creates a new ServiceURL object for the account's read-only secondary endpoint (the "-secondary" host),
+// for example to call GetStatistics on a read-access geo-redundant (RA-GRS) account. The new ServiceURL uses the same
+// request policy pipeline and keeps any SAS query parameters. See QueueURLParts' Secondary method for when an error is returned.
+func (s ServiceURL) SecondaryURL() (ServiceURL, error) {
+	parts, err := NewQueueURLParts(s.URL()).Secondary()
+	if err != nil {
+		return ServiceURL{}, err
+	}
+	u, err := parts.URL()
+	if err != nil {
+		return ServiceURL{}, err
+	}
+	return NewServiceURL(u, s.client.Pipeline()), nil
+}
+
 // NewQueueURL creates a new QueueURL object by concatenating queueName to the end of
 // ServiceURL's URL. The new QueueURL uses the same request policy pipeline as the ServiceURL.
 // To change the pipeline, create the QueueURL and then call its WithPipeline method passing in the
@@ -43,6 +105,11 @@ func (s ServiceURL) NewQueueURL(queueName string) QueueURL {
 	return NewQueueURL(queueURL, s.client.Pipeline())
 }
 
+// QueueExists returns true if the named queue exists. See QueueURL's Exists method for more information.
+func (s ServiceURL) QueueExists(ctx context.Context, queueName string) (bool, error) {
+	return s.NewQueueURL(queueName).Exists(ctx)
+}
+
 // appendToURLPath appends a string to the end of a URL's path (prefixing the string with a '/' if required)
 func appendToURLPath(u url.URL, name string) url.URL {
 	// e.g. "https://ms.com/a/b/?k1=v1&k2=v2#f"
@@ -63,35 +130,233 @@ func appendToURLPath(u url.URL, name string) url.URL {
 	return u
 }
 
+// splitURLPath removes the last segment from a URL's path (ignoring any trailing '/') returning the resulting URL
+// and the removed segment. The URL's query (including any SAS) is unchanged.
+func splitURLPath(u url.URL) (url.URL, string) {
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i == -1 {
+		u.Path, u.RawPath = "", ""
+		return u, path
+	}
+	u.Path, u.RawPath = path[:i], ""
+	return u, path[i+1:]
+}
+
 // ListQueuesSegment returns a single segment of queues starting from the specified Marker. Use an empty
 // Marker to start enumeration from the beginning. Queue names are returned in lexicographic order.
 // After getting a segment, process it, and then call ListQueuesSegment again (passing the the previously-returned
 // Marker) to get the next segment. For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/list-queues1.
 func (s ServiceURL) ListQueuesSegment(ctx context.Context, marker Marker, o ListQueuesSegmentOptions) (*ListQueuesSegmentResponse, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
 	prefix, include, maxResults := o.pointers()
-	return s.client.ListQueuesSegment(ctx, prefix, marker.Val, maxResults,
+	return s.client.ListQueuesSegment(withOperationName(ctx, "ListQueues"), prefix, marker.Val, maxResults,
 		include, nil, nil)
 }
 
+// GetAllQueueMetadata enumerates all queues whose names begin with prefix (use "" for all queues) and returns
+// a map from each queue's name to its metadata. This method calls ListQueuesSegment repeatedly until the
+// enumeration is complete, holding every result in memory. For accounts with a very large number of queues,
+// call ListQueuesSegment directly and process each segment as it arrives.
+func (s ServiceURL) GetAllQueueMetadata(ctx context.Context, prefix string) (map[string]Metadata, error) {
+	o := ListQueuesSegmentOptions{Prefix: prefix, Detail: ListQueuesSegmentDetails{Metadata: true}}
+	queues := map[string]Metadata{}
+	for marker := (Marker{}); marker.NotDone(); {
+		segment, err := s.ListQueuesSegment(ctx, marker, o)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range segment.QueueItems {
+			queues[item.Name] = item.Metadata
+		}
+		marker = segment.NextMarker
+	}
+	return queues, nil
+}
+
+// CountQueues returns the number of queues whose names begin with prefix (use "" for all queues). This method calls
+// ListQueuesSegment repeatedly until the enumeration is complete, requesting the maximum number of queues per segment
+// and no metadata, so counting a large account requires many requests. The count is not atomic: queues created or
+// deleted during the enumeration may or may not be counted.
+func (s ServiceURL) CountQueues(ctx context.Context, prefix string) (int64, error) {
+	o := ListQueuesSegmentOptions{Prefix: prefix, MaxResults: ListQueuesMaxResults}
+	count := int64(0)
+	for marker := (Marker{}); marker.NotDone(); {
+		segment, err := s.ListQueuesSegment(ctx, marker, o)
+		if err != nil {
+			return 0, err
+		}
+		count += int64(len(segment.QueueItems))
+		marker = segment.NextMarker
+	}
+	return count, nil
+}
+
+// QueueItemWithCount is a QueueItem returned by ListQueuesWithCounts along with the queue's approximate message count.
+type QueueItemWithCount struct {
+	QueueItem
+
+	// ApproximateMessagesCount is the queue's approximate message count, or -1 if Err is not nil.
+	ApproximateMessagesCount int64
+
+	// Err is the error returned by the queue's GetProperties call, if any; for example, a QueueNotFound StorageError if
+	// the queue was deleted after it was listed.
+	Err error
+}
+
+// ListQueuesWithCounts enumerates the queues matching o, like NewListQueuesIterator, and gets each queue's approximate
+// message count (which the list operation doesn't return) by calling GetProperties for up to parallelism queues at a
+// time (0=default of 5), starting as soon as each segment arrives. A queue whose GetProperties call fails is returned
+// with its error rather than failing the whole listing; an error listing the queues is returned instead of the
+// results. If ctx is done, the remaining GetProperties calls are not made and ctx's error is returned.
+// The results are in the order the queues were listed and are all held in memory.
+func (s ServiceURL) ListQueuesWithCounts(ctx context.Context, o ListQueuesSegmentOptions, parallelism int) ([]QueueItemWithCount, error) {
+	if parallelism <= 0 {
+		parallelism = 5
+	}
+	fanOutCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	segments := [][]QueueItemWithCount{} // Each segment's slice is written to by the goroutines getting its counts
+	it := s.NewListQueuesIterator(fanOutCtx, o)
+list:
+	for it.Next() {
+		segment := make([]QueueItemWithCount, len(it.Value().QueueItems))
+		segments = append(segments, segment)
+		for i, item := range it.Value().QueueItems {
+			segment[i].QueueItem = item
+			select {
+			case sem <- struct{}{}:
+			case <-fanOutCtx.Done():
+				break list
+			}
+			wg.Add(1)
+			go func(result *QueueItemWithCount) {
+				defer func() { <-sem; wg.Done() }()
+				props, err := s.NewQueueURL(result.Name).GetProperties(fanOutCtx)
+				if err != nil {
+					result.ApproximateMessagesCount, result.Err = -1, err
+					return
+				}
+				result.ApproximateMessagesCount = props.ApproximateMessagesCountInt64()
+			}(&segment[i])
+		}
+	}
+	if it.Err() != nil {
+		cancel() // Don't wait for the counts that won't be returned
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	results := []QueueItemWithCount{}
+	for _, segment := range segments {
+		results = append(results, segment...)
+	}
+	return results, nil
+}
+
+// NewListQueuesIterator creates a ListQueuesIterator that enumerates the queues matching o one segment at a time.
+// ctx is used for every segment request and is checked before each one, so cancelling it stops the enumeration.
+func (s ServiceURL) NewListQueuesIterator(ctx context.Context, o ListQueuesSegmentOptions) *ListQueuesIterator {
+	return &ListQueuesIterator{ctx: ctx, s: s, o: o}
+}
+
+// ListQueuesIterator enumerates queues by calling ListQueuesSegment. Call Next to fetch each segment and Value to get it.
+// When Next returns false, call Err to find out whether the enumeration completed or failed:
+//
+//	for it := serviceURL.NewListQueuesIterator(ctx, azqueue.ListQueuesSegmentOptions{}); it.Next(); {
+//	    for _, item := range it.Value().QueueItems { ... }
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// A ListQueuesIterator is not goroutine-safe.
+type ListQueuesIterator struct {
+	ctx    context.Context
+	s      ServiceURL
+	o      ListQueuesSegmentOptions
+	marker Marker
+	value  *ListQueuesSegmentResponse
+	err    error
+}
+
+// Next fetches the next segment of queues, returning false when there are no more segments or an error occurs.
+// Next checks ctx before each request and, if it is done, stores ctx's error in Err and returns false.
+func (it *ListQueuesIterator) Next() bool {
+	if it.err != nil || !it.marker.NotDone() {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err, it.value = err, nil
+		return false
+	}
+	segment, err := it.s.ListQueuesSegment(it.ctx, it.marker, it.o)
+	if err != nil {
+		it.err, it.value = err, nil
+		return false
+	}
+	it.value, it.marker = segment, segment.NextMarker
+	return true
+}
+
+// Value returns the segment fetched by the most recent call to Next that returned true.
+func (it *ListQueuesIterator) Value() *ListQueuesSegmentResponse {
+	return it.value
+}
+
+// Err returns the error, if any, that ended the enumeration. It returns nil if all segments were fetched successfully.
+func (it *ListQueuesIterator) Err() error {
+	return it.err
+}
+
+const (
+	// ListQueuesMaxResults is the maximum number of queues the service returns in a single segment (5000).
+	ListQueuesMaxResults = 5000
+
+	// QueueNameMaxLength is the maximum length of a queue name (63), and so of a useful ListQueuesSegmentOptions Prefix.
+	QueueNameMaxLength = 63
+)
+
+// ErrMaxResultsExceeded is returned by ListQueuesSegment when ListQueuesSegmentOptions.MaxResults is greater than
+// ListQueuesMaxResults; the service would reject the request.
+var ErrMaxResultsExceeded = errors.New("MaxResults must be at most 5000")
+
 // ListQueuesSegmentOptions defines options available when calling ListQueuesSegment.
 type ListQueuesSegmentOptions struct {
-	Detail ListQueuesSegmentDetails // No IncludeType header is produced if ""
-	Prefix string                   // No Prefix header is produced if ""
+	Detail ListQueuesSegmentDetails // No IncludeType header is produced if Detail.None()
+	Prefix string                   // No Prefix header is produced if ""; at most QueueNameMaxLength characters
 
-	// SetMaxResults sets the maximum desired results you want the service to return.
+	// MaxResults sets the maximum desired results you want the service to return, from 1 to ListQueuesMaxResults.
 	// Note, the service may return fewer results than requested.
-	// MaxResults=0 means no 'MaxResults' header specified.
+	// MaxResults<=0 means no 'MaxResults' header specified, so the service uses its default (also 5000).
 	MaxResults int32
 }
 
+// validate checks the options against the service's limits so that invalid options fail before a request is sent.
+func (o *ListQueuesSegmentOptions) validate() error {
+	if o.MaxResults > ListQueuesMaxResults {
+		return ErrMaxResultsExceeded
+	}
+	if len(o.Prefix) > QueueNameMaxLength {
+		return errors.New("Prefix must be at most 63 characters, the maximum length of a queue name")
+	}
+	return nil
+}
+
 func (o *ListQueuesSegmentOptions) pointers() (prefix *string, include ListQueuesIncludeType, maxResults *int32) {
 	if o.Prefix != "" {
 		prefix = &o.Prefix // else nil
 	}
-	if o.MaxResults != 0 {
+	if o.MaxResults > 0 {
 		maxResults = &o.MaxResults
 	}
-	if o.Detail.Metadata {
+	if !o.Detail.None() {
 		include = ListQueuesIncludeMetadata
 	}
 	return
@@ -103,6 +368,11 @@ type ListQueuesSegmentDetails struct {
 	Metadata bool
 }
 
+// None returns true if d requests no additional information, so the service returns only each queue's name.
+func (d ListQueuesSegmentDetails) None() bool {
+	return !d.Metadata
+}
+
 // slice produces the Include query parameter's value.
 func (d *ListQueuesSegmentDetails) slice() []ListQueuesIncludeType {
 	items := []ListQueuesIncludeType{}
@@ -117,19 +387,49 @@ func (d *ListQueuesSegmentDetails) slice() []ListQueuesIncludeType {
 // and CORS (Cross-Origin Resource Sharing) rules.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-queue-service-properties.
 func (s ServiceURL) GetProperties(ctx context.Context) (*StorageServiceProperties, error) {
-	return s.client.GetProperties(ctx, nil, nil)
+	return s.client.GetProperties(withOperationName(ctx, "GetServiceProperties"), nil, nil)
 }
 
 // SetProperties sets properties for a storage account’s Queue service endpoint, including properties for Storage Analytics
 // and CORS (Cross-Origin Resource Sharing) rules.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/set-queue-service-properties.
 func (s ServiceURL) SetProperties(ctx context.Context, properties StorageServiceProperties) (*ServiceSetPropertiesResponse, error) {
-	return s.client.SetProperties(ctx, properties, nil, nil)
+	return s.client.SetProperties(withOperationName(ctx, "SetServiceProperties"), properties, nil, nil)
+}
+
+// ServiceMaxCORSRules is the maximum number of CORS rules a Queue service may have (5).
+const ServiceMaxCORSRules = 5
+
+// GetCORSRules gets the CORS (Cross-Origin Resource Sharing) rules of the storage account's Queue service by calling
+// GetProperties.
+func (s ServiceURL) GetCORSRules(ctx context.Context) ([]CorsRule, error) {
+	props, err := s.GetProperties(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return props.Cors, nil
+}
+
+// SetCORSRules replaces the CORS (Cross-Origin Resource Sharing) rules of the storage account's Queue service; pass
+// nil or an empty slice to delete them all. It gets the service's properties, replaces their CORS rules, and sets
+// them, so the other properties are preserved. The read-modify-write is not atomic: a concurrent change to the other
+// properties made between the two requests is overwritten.
+func (s ServiceURL) SetCORSRules(ctx context.Context, rules []CorsRule) error {
+	if len(rules) > ServiceMaxCORSRules {
+		return fmt.Errorf("a Queue service may have at most %d CORS rules", ServiceMaxCORSRules)
+	}
+	props, err := s.GetProperties(ctx)
+	if err != nil {
+		return err
+	}
+	props.Cors = append([]CorsRule{}, rules...) // Non-nil, so that an empty slice deletes the rules
+	_, err = s.SetProperties(ctx, *props)
+	return err
 }
 
 // GetStatistics retrieves statistics related to replication for the Queue service. It is only available on the
 // secondary location endpoint when read-access geo-redundant replication is enabled for the storage account.
 // For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/get-queue-service-stats.
 func (s ServiceURL) GetStatistics(ctx context.Context) (*StorageServiceStats, error) {
-	return s.client.GetStatistics(ctx, nil, nil)
+	return s.client.GetStatistics(withOperationName(ctx, "GetServiceStatistics"), nil, nil)
 }
@@ -1,4 +1,5 @@
 package azqueue
 
-const serviceLibVersion = "0.3"
+// SDKVersion is the version of this package. It is sent in each request's User-Agent header (see TelemetryOptions).
+const SDKVersion = "0.3"
 
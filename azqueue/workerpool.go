@@ -0,0 +1,212 @@
+package azqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// AdaptivePoolOptions configures an AdaptiveWorkerPool's behavior.
+type AdaptivePoolOptions struct {
+	// MinWorkers is the number of workers the pool starts with and never goes below (0=default of 1).
+	MinWorkers int
+
+	// MaxWorkers is the maximum number of workers (values below MinWorkers mean MinWorkers).
+	MaxWorkers int
+
+	// ScaleUpThreshold is the approximate message count above which the pool adds a worker.
+	ScaleUpThreshold int32
+
+	// ScaleDownThreshold is the approximate message count below which the pool removes a worker.
+	ScaleDownThreshold int32
+
+	// ScaleInterval is how often the pool gets the queue's approximate message count (0=default of 30 seconds).
+	ScaleInterval time.Duration
+
+	// VisibilityTimeout is how long each dequeued message stays invisible while Handler processes it
+	// (0=default of 30 seconds).
+	VisibilityTimeout time.Duration
+
+	// Handler processes a message. If it returns nil, the message is deleted; otherwise, the message becomes visible
+	// again when its visibility timeout expires and will be dequeued again.
+	Handler func(ctx context.Context, msg *DequeuedMessage) error
+
+	// OnResult, if not nil, is called after each message is handled, for example to track SLOs. It is called from the
+	// worker goroutines, so it must be goroutine-safe, and it delays the worker's next message while it runs.
+	OnResult func(MessageResult)
+}
+
+// MessageResult describes how an AdaptiveWorkerPool handled a message.
+type MessageResult struct {
+	// Message is the handled message.
+	Message *DequeuedMessage
+
+	// QueueLatency is how long the message waited in the queue before it was dequeued (see
+	// DequeuedMessage.QueueLatency).
+	QueueLatency time.Duration
+
+	// DequeueCount is the number of times the message has been dequeued, including this time.
+	DequeueCount int64
+
+	// ProcessingTime is how long the Handler took.
+	ProcessingTime time.Duration
+
+	// Err is the Handler's error, or nil if it succeeded.
+	Err error
+
+	// Deleted is true if the message was deleted after the Handler succeeded.
+	Deleted bool
+}
+
+func (o AdaptivePoolOptions) defaults() AdaptivePoolOptions {
+	if o.MinWorkers <= 0 {
+		o.MinWorkers = 1
+	}
+	if o.MaxWorkers < o.MinWorkers {
+		o.MaxWorkers = o.MinWorkers
+	}
+	if o.ScaleInterval == 0 {
+		o.ScaleInterval = 30 * time.Second
+	}
+	if o.VisibilityTimeout == 0 {
+		o.VisibilityTimeout = 30 * time.Second
+	}
+	return o
+}
+
+// AdaptiveWorkerPool processes a queue's messages with a number of worker goroutines that grows and shrinks with
+// the queue's approximate message count. Each worker dequeues one message at a time and passes it to the Handler.
+type AdaptiveWorkerPool struct {
+	messagesURL MessagesURL
+	o           AdaptivePoolOptions
+	wg          sync.WaitGroup
+
+	lock    sync.Mutex
+	workers []chan struct{} // Each worker's stop channel
+}
+
+// NewAdaptiveWorkerPool creates an AdaptiveWorkerPool that processes the messages of mURL's queue; call its Run method
+// to start it.
+func NewAdaptiveWorkerPool(mURL MessagesURL, o AdaptivePoolOptions) *AdaptiveWorkerPool {
+	return &AdaptiveWorkerPool{messagesURL: mURL, o: o.defaults()}
+}
+
+// Workers returns the current number of workers.
+func (p *AdaptiveWorkerPool) Workers() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.workers)
+}
+
+// Run starts MinWorkers workers and, every ScaleInterval, adds a worker if the queue's approximate message count is
+// above ScaleUpThreshold (up to MaxWorkers) or removes one if it is below ScaleDownThreshold (down to MinWorkers).
+// If getting the queue's properties fails, the number of workers is left unchanged. A removed worker finishes the
+// message it is processing before it exits. Run returns ctx's error once ctx is done and every worker has exited;
+// the Handler calls in progress at that time get the canceled ctx, and messages they fail to process are not lost
+// since they become visible again.
+func (p *AdaptiveWorkerPool) Run(ctx context.Context) error {
+	if p.o.Handler == nil {
+		return errors.New("AdaptivePoolOptions.Handler must not be nil")
+	}
+	for i := 0; i < p.o.MinWorkers; i++ {
+		p.addWorker(ctx)
+	}
+	queueURL := p.messagesURL.QueueURL()
+	for sleepWithContext(ctx, p.o.ScaleInterval) {
+		props, err := queueURL.GetProperties(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logInternal(pipeline.LogWarning, "worker pool for %s could not get the queue's properties to scale: %v", queueURL, err)
+			}
+			continue
+		}
+		switch count, workers := props.ApproximateMessagesCount(), p.Workers(); {
+		case count > p.o.ScaleUpThreshold && workers < p.o.MaxWorkers:
+			p.addWorker(ctx)
+		case count < p.o.ScaleDownThreshold && workers > p.o.MinWorkers:
+			p.removeWorker()
+		}
+	}
+	p.lock.Lock()
+	for _, stop := range p.workers {
+		close(stop)
+	}
+	p.workers = nil
+	p.lock.Unlock()
+	p.wg.Wait()
+	return ctx.Err()
+}
+
+// addWorker starts a worker goroutine.
+func (p *AdaptiveWorkerPool) addWorker(ctx context.Context) {
+	stop := make(chan struct{})
+	p.lock.Lock()
+	p.workers = append(p.workers, stop)
+	p.lock.Unlock()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.work(ctx, stop)
+	}()
+}
+
+// removeWorker tells the most recently added worker to exit.
+func (p *AdaptiveWorkerPool) removeWorker() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	last := len(p.workers) - 1
+	close(p.workers[last])
+	p.workers = p.workers[:last]
+}
+
+// work dequeues and handles messages until stop is closed or ctx is done. Stopping only interrupts waiting for a
+// message; a dequeued message is always handled. (If stopping interrupts a Dequeue request, a message it dequeued is
+// handled by another worker once its visibility timeout expires.)
+func (p *AdaptiveWorkerPool) work(ctx context.Context, stop <-chan struct{}) {
+	dequeueCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-dequeueCtx.Done():
+		}
+	}()
+
+	backoff := &EmptyQueueBackoff{ResetOnMessage: true}
+	failures := uint(0)
+	for {
+		dequeue, err := p.messagesURL.DequeueWithBackoff(dequeueCtx, 1, p.o.VisibilityTimeout, backoff)
+		if dequeueCtx.Err() != nil && (err != nil || dequeue == nil) {
+			return
+		}
+		if err != nil {
+			logInternal(pipeline.LogWarning, "worker pool for %s could not dequeue a message: %v", p.messagesURL, err)
+			if !sleepWithContext(dequeueCtx, withJitter(errorBackoff(time.Second, failures, 30*time.Second))) {
+				return
+			}
+			failures++
+			continue
+		}
+		failures = 0
+		msg := dequeue.Message(0)
+		start := time.Now()
+		err = p.o.Handler(ctx, msg)
+		result := MessageResult{Message: msg, QueueLatency: msg.QueueLatency(), DequeueCount: msg.DequeueCount,
+			ProcessingTime: time.Since(start), Err: err}
+		if err == nil {
+			// If this fails, the message will be handled again
+			_, deleteErr := p.messagesURL.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt)
+			result.Deleted = deleteErr == nil
+			if deleteErr != nil && ctx.Err() == nil {
+				logInternal(pipeline.LogWarning, "worker pool for %s could not delete handled message %s, so it will be handled again: %v", p.messagesURL, msg.ID, deleteErr)
+			}
+		}
+		if p.o.OnResult != nil {
+			p.o.OnResult(result)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package azqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// accessPolicyQueueClient is the QueueClient used by NewQueueURL. It replaces the generated client's access policy
+// operations so that an unset Start, Expiry, or Permission is omitted from SetAccessPolicy's request (a SAS referencing
+// the identifier must then supply it) and the empty Start and Expiry elements that GetAccessPolicy's response contains
+// for unset times are read as zero times.
+type accessPolicyQueueClient struct {
+	queueClient
+}
+
+// GetAccessPolicy returns details about any stored access policies specified on the queue.
+func (client accessPolicyQueueClient) GetAccessPolicy(ctx context.Context, timeout *int32, requestID *string) (*SignedIdentifiers, error) {
+	if err := validate([]validation{
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.getAccessPolicyPreparer(timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.getAccessPolicyResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*SignedIdentifiers), err
+}
+
+// getAccessPolicyResponder handles the response to the GetAccessPolicy request.
+func (client accessPolicyQueueClient) getAccessPolicyResponder(resp pipeline.Response) (pipeline.Response, error) {
+	err := validateResponse(resp, http.StatusOK)
+	if resp == nil {
+		return nil, err
+	}
+	result := &SignedIdentifiers{rawResponse: resp.Response()}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Response().Body.Close()
+	b, err := ioutil.ReadAll(resp.Response().Body)
+	if err != nil {
+		return result, err
+	}
+	if len(b) > 0 {
+		sis := signedIdentifiersXML{}
+		if err = xml.Unmarshal(removeBOM(b), &sis); err != nil {
+			return result, NewResponseError(err, resp.Response(), "failed to unmarshal response body")
+		}
+		result.Items = sis.signedIdentifiers()
+	}
+	return result, nil
+}
+
+// SetAccessPolicy sets stored access policies for the queue.
+func (client accessPolicyQueueClient) SetAccessPolicy(ctx context.Context, queueACL []SignedIdentifier, timeout *int32, requestID *string) (*QueueSetAccessPolicyResponse, error) {
+	if err := validate([]validation{
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.setAccessPolicyPreparer(nil, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	// Replace the preparer's body, which would send unset fields as zero times and empty strings
+	b, err := xml.Marshal(newSignedIdentifiersXML(queueACL))
+	if err != nil {
+		return nil, pipeline.NewError(err, "failed to marshal request body")
+	}
+	if err = req.SetBody(bytes.NewReader(b)); err != nil {
+		return nil, pipeline.NewError(err, "failed to set request body")
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.setAccessPolicyResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*QueueSetAccessPolicyResponse), err
+}
+
+// internal type used for marshalling signed identifiers without their access policies' unset fields
+type signedIdentifiersXML struct {
+	XMLName xml.Name              `xml:"SignedIdentifiers"`
+	Items   []signedIdentifierXML `xml:"SignedIdentifier"`
+}
+
+// internal type used for marshalling
+type signedIdentifierXML struct {
+	ID           string          `xml:"Id"`
+	AccessPolicy accessPolicyXML `xml:"AccessPolicy"`
+}
+
+// internal type used for marshalling
+type accessPolicyXML struct {
+	Start      *optionalTimeRFC3339 `xml:"Start,omitempty"`
+	Expiry     *optionalTimeRFC3339 `xml:"Expiry,omitempty"`
+	Permission string               `xml:"Permission,omitempty"`
+}
+
+// internal type used for marshalling a time in RFC3339 format that reads empty text as the zero time
+type optionalTimeRFC3339 struct {
+	timeRFC3339
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for optionalTimeRFC3339.
+func (t *optionalTimeRFC3339) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		t.Time = time.Time{}
+		return nil
+	}
+	return t.timeRFC3339.UnmarshalText(data)
+}
+
+// newOptionalTimeRFC3339 returns nil if t is the zero time so that it is omitted.
+func newOptionalTimeRFC3339(t time.Time) *optionalTimeRFC3339 {
+	if t.IsZero() {
+		return nil
+	}
+	return &optionalTimeRFC3339{timeRFC3339{Time: t}}
+}
+
+func newSignedIdentifiersXML(identifiers []SignedIdentifier) signedIdentifiersXML {
+	sis := signedIdentifiersXML{Items: make([]signedIdentifierXML, len(identifiers))}
+	for i, si := range identifiers {
+		sis.Items[i] = signedIdentifierXML{ID: si.ID, AccessPolicy: accessPolicyXML{
+			Start:      newOptionalTimeRFC3339(si.AccessPolicy.Start),
+			Expiry:     newOptionalTimeRFC3339(si.AccessPolicy.Expiry),
+			Permission: si.AccessPolicy.Permission,
+		}}
+	}
+	return sis
+}
+
+func (sis signedIdentifiersXML) signedIdentifiers() []SignedIdentifier {
+	identifiers := make([]SignedIdentifier, len(sis.Items))
+	for i, si := range sis.Items {
+		identifiers[i] = SignedIdentifier{ID: si.ID, AccessPolicy: AccessPolicy{Permission: si.AccessPolicy.Permission}}
+		if si.AccessPolicy.Start != nil {
+			identifiers[i].AccessPolicy.Start = si.AccessPolicy.Start.Time
+		}
+		if si.AccessPolicy.Expiry != nil {
+			identifiers[i].AccessPolicy.Expiry = si.AccessPolicy.Expiry.Time
+		}
+	}
+	return identifiers
+}
@@ -0,0 +1,170 @@
+package azqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+const (
+	// storageScope is the OAuth2 scope granting access to Azure Storage.
+	storageScope = "https://storage.azure.com/.default"
+
+	// defaultAuthorityHost is the Azure AD endpoint of the public cloud.
+	defaultAuthorityHost = "https://login.microsoftonline.com"
+
+	// tokenRefreshMargin is how long before a token expires that it is replaced with a new one.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// EnvironmentCredentialOptions configures the credential returned by NewEnvironmentCredential.
+type EnvironmentCredentialOptions struct {
+	// AuthorityHost is the Azure AD endpoint used to get tokens (""=the AZURE_AUTHORITY_HOST environment variable or,
+	// if that is not set, https://login.microsoftonline.com).
+	AuthorityHost string
+
+	// HTTPClient sends the token requests (nil=http.DefaultClient).
+	HTTPClient *http.Client
+}
+
+func (o EnvironmentCredentialOptions) defaults() EnvironmentCredentialOptions {
+	if o.AuthorityHost == "" {
+		o.AuthorityHost = os.Getenv("AZURE_AUTHORITY_HOST")
+		if o.AuthorityHost == "" {
+			o.AuthorityHost = defaultAuthorityHost
+		}
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	return o
+}
+
+// NewEnvironmentCredential creates a credential that authenticates requests with Azure AD (OAuth2) access tokens for
+// Azure Storage, obtained for the service principal described by these environment variables:
+//   - AZURE_TENANT_ID: the service principal's Azure AD tenant.
+//   - AZURE_CLIENT_ID: the service principal's client (application) ID.
+//   - AZURE_CLIENT_SECRET: the service principal's client secret; or
+//   - AZURE_FEDERATED_TOKEN_FILE: the path of a file containing a federated (OIDC) token, as used by workload identity
+//     in Kubernetes, GitHub Actions, and similar environments. The file is read each time a token is obtained since
+//     the platform rotates it.
+//
+// If both AZURE_CLIENT_SECRET and AZURE_FEDERATED_TOKEN_FILE are set, the client secret is used.
+// The credential gets a token when it is first needed, caches it, and gets a new one 5 minutes before it expires.
+// Failing to get a token fails the request being sent. The credential requires requests to use HTTPS.
+func NewEnvironmentCredential(o EnvironmentCredentialOptions) (Credential, error) {
+	c := &environmentCredential{
+		o:             o.defaults(),
+		tenantID:      os.Getenv("AZURE_TENANT_ID"),
+		clientID:      os.Getenv("AZURE_CLIENT_ID"),
+		clientSecret:  os.Getenv("AZURE_CLIENT_SECRET"),
+		tokenFilePath: os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+	}
+	if c.tenantID == "" || c.clientID == "" {
+		return nil, errors.New("environment credential requires the AZURE_TENANT_ID and AZURE_CLIENT_ID environment variables")
+	}
+	if c.clientSecret == "" && c.tokenFilePath == "" {
+		return nil, errors.New("environment credential requires the AZURE_CLIENT_SECRET or AZURE_FEDERATED_TOKEN_FILE environment variable")
+	}
+	return c, nil
+}
+
+// environmentCredential is the Credential returned by NewEnvironmentCredential.
+type environmentCredential struct {
+	o             EnvironmentCredentialOptions
+	tenantID      string
+	clientID      string
+	clientSecret  string
+	tokenFilePath string
+
+	lock    sync.Mutex // Serializes getting tokens so concurrent requests share a single new token
+	token   string
+	expires time.Time
+}
+
+// credentialMarker is a package-internal method that exists just to satisfy the Credential interface.
+func (*environmentCredential) credentialMarker() {}
+
+// New satisfies pipeline.Factory's New method creating a pipeline policy object.
+func (c *environmentCredential) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		if request.URL.Scheme != "https" {
+			// HTTPS must be used, otherwise the tokens are at the risk of being exposed
+			return nil, errors.New("token credentials require a URL using the https protocol scheme")
+		}
+		token, err := c.getToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header[headerAuthorization] = []string{"Bearer " + token}
+		return next.Do(ctx, request)
+	})
+}
+
+// getToken returns the cached token, first getting a new one from Azure AD if the cached token expires soon.
+func (c *environmentCredential) getToken(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != "" && time.Now().Add(tokenRefreshMargin).Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"client_id":  {c.clientID},
+		"scope":      {storageScope},
+		"grant_type": {"client_credentials"},
+	}
+	if c.clientSecret != "" {
+		form.Set("client_secret", c.clientSecret)
+	} else {
+		assertion, err := ioutil.ReadFile(c.tokenFilePath)
+		if err != nil {
+			return "", fmt.Errorf("reading federated token file: %v", err)
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	}
+
+	tokenURL := strings.TrimSuffix(c.o.AuthorityHost, "/") + "/" + url.PathEscape(c.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.o.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting an Azure AD token failed with status %s: %s", resp.Status, body)
+	}
+
+	var result struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"` // Some endpoints return a number, others a string
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("parsing the Azure AD token response: %v", err)
+	}
+	expiresIn, err := result.ExpiresIn.Int64()
+	if err != nil || result.AccessToken == "" {
+		return "", errors.New("the Azure AD token response is missing access_token or expires_in")
+	}
+	c.token, c.expires = result.AccessToken, time.Now().Add(time.Duration(expiresIn)*time.Second)
+	return c.token, nil
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
@@ -16,16 +17,39 @@ import (
 	"github.com/Azure/azure-pipeline-go/pipeline"
 )
 
+// sharedKeyLength is the length, in bytes, of a decoded storage account key (a 512-bit HMAC-SHA256 key).
+const sharedKeyLength = 64
+
 // NewSharedKeyCredential creates an immutable SharedKeyCredential containing the
-// storage account's name and either its primary or secondary key.
+// storage account's name and either its primary or secondary key. The key must be the
+// Base64-encoded value shown in the Azure portal; a key that does not decode to 64 bytes
+// returns an error since every request signed with it would fail authentication.
 func NewSharedKeyCredential(accountName, accountKey string) (*SharedKeyCredential, error) {
 	bytes, err := base64.StdEncoding.DecodeString(accountKey)
 	if err != nil {
 		return &SharedKeyCredential{}, err
 	}
+	if len(bytes) != sharedKeyLength {
+		if isHex(accountKey) {
+			return &SharedKeyCredential{}, fmt.Errorf("account key decodes to %d bytes instead of %d; "+
+				"the key appears to be hex-encoded but it must be Base64-encoded", len(bytes), sharedKeyLength)
+		}
+		return &SharedKeyCredential{}, fmt.Errorf("account key decodes to %d bytes instead of %d; "+
+			"make sure the key is the Base64-encoded value of the account's primary or secondary key", len(bytes), sharedKeyLength)
+	}
 	return &SharedKeyCredential{accountName: accountName, accountKey: bytes}, nil
 }
 
+// isHex returns true if s is non-empty and contains only hexadecimal digits.
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return false
+		}
+	}
+	return s != ""
+}
+
 // SharedKeyCredential contains an account's name and its primary or secondary key.
 // It is immutable making it shareable and goroutine-safe.
 type SharedKeyCredential struct {
@@ -0,0 +1,57 @@
+package azqueue
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// visibilityMessageIDClient is the MessageIDClient used by NewMessageIDURL. It adds ExtendVisibility, which the
+// generated client lacks because its Update operation always sends the message's text.
+type visibilityMessageIDClient struct {
+	messageIDClient
+}
+
+// ExtendVisibility changes the visibility timeout of a message without changing its contents by sending an Update
+// Message request without a body.
+func (client visibilityMessageIDClient) ExtendVisibility(ctx context.Context, popReceipt string, visibilitytimeout int32, timeout *int32, requestID *string) (*MessageIDUpdateResponse, error) {
+	if err := validate([]validation{
+		{targetValue: visibilitytimeout,
+			constraints: []constraint{{target: "visibilitytimeout", name: inclusiveMaximum, rule: 604800, chain: nil},
+				{target: "visibilitytimeout", name: inclusiveMinimum, rule: 0, chain: nil}}},
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.extendVisibilityPreparer(popReceipt, visibilitytimeout, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.updateResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*MessageIDUpdateResponse), err
+}
+
+// extendVisibilityPreparer prepares the ExtendVisibility request. It is updatePreparer without the request body.
+func (client visibilityMessageIDClient) extendVisibilityPreparer(popReceipt string, visibilitytimeout int32, timeout *int32, requestID *string) (pipeline.Request, error) {
+	req, err := pipeline.NewRequest("PUT", client.url, nil)
+	if err != nil {
+		return req, pipeline.NewError(err, "failed to create request")
+	}
+	params := req.URL.Query()
+	params.Set("popreceipt", popReceipt)
+	params.Set("visibilitytimeout", strconv.FormatInt(int64(visibilitytimeout), 10))
+	if timeout != nil {
+		params.Set("timeout", strconv.FormatInt(int64(*timeout), 10))
+	}
+	req.URL.RawQuery = params.Encode()
+	req.Header.Set("x-ms-version", ServiceVersion)
+	if requestID != nil {
+		req.Header.Set("x-ms-client-request-id", *requestID)
+	}
+	return req, nil
+}
@@ -0,0 +1,221 @@
+package azqueue
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// serviceTimeMessagesClient is the MessagesClient used by NewMessagesURL. It replaces the responders of the generated
+// client's Dequeue, Peek, and Enqueue operations so that the message times in their responses are parsed by
+// parseRFC1123, which accepts the RFC1123Z times some emulators send and returns UTC times with 1-second precision.
+type serviceTimeMessagesClient struct {
+	messagesClient
+}
+
+// Dequeue retrieves one or more messages from the front of the queue.
+func (client serviceTimeMessagesClient) Dequeue(ctx context.Context, numberOfMessages *int32, visibilitytimeout *int32, timeout *int32, requestID *string) (*QueueMessagesList, error) {
+	if err := validate([]validation{
+		{targetValue: numberOfMessages,
+			constraints: []constraint{{target: "numberOfMessages", name: null, rule: false,
+				chain: []constraint{{target: "numberOfMessages", name: inclusiveMinimum, rule: 1, chain: nil}}}}},
+		{targetValue: visibilitytimeout,
+			constraints: []constraint{{target: "visibilitytimeout", name: null, rule: false,
+				chain: []constraint{{target: "visibilitytimeout", name: inclusiveMaximum, rule: 604800, chain: nil},
+					{target: "visibilitytimeout", name: inclusiveMinimum, rule: 0, chain: nil},
+				}}}},
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.dequeuePreparer(numberOfMessages, visibilitytimeout, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.dequeueResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*QueueMessagesList), err
+}
+
+// dequeueResponder handles the response to the Dequeue request.
+func (client serviceTimeMessagesClient) dequeueResponder(resp pipeline.Response) (pipeline.Response, error) {
+	err := validateResponse(resp, http.StatusOK)
+	if resp == nil {
+		return nil, err
+	}
+	result := &QueueMessagesList{rawResponse: resp.Response()}
+	if err != nil {
+		return result, err
+	}
+	list := dequeuedMessagesListXML{}
+	if err = unmarshalMessagesResponse(resp, &list); err != nil {
+		return result, err
+	}
+	for _, item := range list.Items {
+		result.Items = append(result.Items, DequeuedMessageItem{MessageID: item.MessageID,
+			InsertionTime: item.InsertionTime.Time, ExpirationTime: item.ExpirationTime.Time, PopReceipt: item.PopReceipt,
+			TimeNextVisible: item.TimeNextVisible.Time, DequeueCount: item.DequeueCount, MessageText: item.MessageText})
+	}
+	return result, nil
+}
+
+// Enqueue adds a new message to the back of the message queue.
+func (client serviceTimeMessagesClient) Enqueue(ctx context.Context, queueMessage QueueMessage, visibilitytimeout *int32, messageTimeToLive *int32, timeout *int32, requestID *string) (*EnqueueResponse, error) {
+	if err := validate([]validation{
+		{targetValue: visibilitytimeout,
+			constraints: []constraint{{target: "visibilitytimeout", name: null, rule: false,
+				chain: []constraint{{target: "visibilitytimeout", name: inclusiveMaximum, rule: 604800, chain: nil},
+					{target: "visibilitytimeout", name: inclusiveMinimum, rule: 0, chain: nil},
+				}}}},
+		{targetValue: messageTimeToLive,
+			constraints: []constraint{{target: "messageTimeToLive", name: null, rule: false,
+				chain: []constraint{{target: "messageTimeToLive", name: inclusiveMinimum, rule: -1, chain: nil}}}}},
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.enqueuePreparer(queueMessage, visibilitytimeout, messageTimeToLive, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.enqueueResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*EnqueueResponse), err
+}
+
+// enqueueResponder handles the response to the Enqueue request.
+func (client serviceTimeMessagesClient) enqueueResponder(resp pipeline.Response) (pipeline.Response, error) {
+	err := validateResponse(resp, http.StatusOK, http.StatusCreated)
+	if resp == nil {
+		return nil, err
+	}
+	result := &EnqueueResponse{rawResponse: resp.Response()}
+	if err != nil {
+		return result, err
+	}
+	list := enqueuedMessagesListXML{}
+	if err = unmarshalMessagesResponse(resp, &list); err != nil {
+		return result, err
+	}
+	for _, item := range list.Items {
+		result.Items = append(result.Items, EnqueuedMessage{MessageID: item.MessageID,
+			InsertionTime: item.InsertionTime.Time, ExpirationTime: item.ExpirationTime.Time, PopReceipt: item.PopReceipt,
+			TimeNextVisible: item.TimeNextVisible.Time})
+	}
+	return result, nil
+}
+
+// Peek retrieves one or more messages from the front of the queue without changing their visibility.
+func (client serviceTimeMessagesClient) Peek(ctx context.Context, numberOfMessages *int32, timeout *int32, requestID *string) (*PeekResponse, error) {
+	if err := validate([]validation{
+		{targetValue: numberOfMessages,
+			constraints: []constraint{{target: "numberOfMessages", name: null, rule: false,
+				chain: []constraint{{target: "numberOfMessages", name: inclusiveMinimum, rule: 1, chain: nil}}}}},
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.peekPreparer(numberOfMessages, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.peekResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*PeekResponse), err
+}
+
+// peekResponder handles the response to the Peek request.
+func (client serviceTimeMessagesClient) peekResponder(resp pipeline.Response) (pipeline.Response, error) {
+	err := validateResponse(resp, http.StatusOK)
+	if resp == nil {
+		return nil, err
+	}
+	result := &PeekResponse{rawResponse: resp.Response()}
+	if err != nil {
+		return result, err
+	}
+	list := peekedMessagesListXML{}
+	if err = unmarshalMessagesResponse(resp, &list); err != nil {
+		return result, err
+	}
+	for _, item := range list.Items {
+		result.Items = append(result.Items, PeekedMessageItem{MessageID: item.MessageID,
+			InsertionTime: item.InsertionTime.Time, ExpirationTime: item.ExpirationTime.Time,
+			DequeueCount: item.DequeueCount, MessageText: item.MessageText})
+	}
+	return result, nil
+}
+
+// unmarshalMessagesResponse reads the XML body of a successful response into v.
+func unmarshalMessagesResponse(resp pipeline.Response, v interface{}) error {
+	defer resp.Response().Body.Close()
+	b, err := ioutil.ReadAll(resp.Response().Body)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		if err = xml.Unmarshal(removeBOM(b), v); err != nil {
+			return NewResponseError(err, resp.Response(), "failed to unmarshal response body")
+		}
+	}
+	return nil
+}
+
+// internal type used for unmarshalling a time parsed by parseRFC1123
+type serviceTimeRFC1123 struct {
+	time.Time
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for serviceTimeRFC1123.
+func (t *serviceTimeRFC1123) UnmarshalText(data []byte) (err error) {
+	t.Time, err = parseRFC1123(string(data))
+	return
+}
+
+// internal type used for unmarshalling
+type dequeuedMessagesListXML struct {
+	Items []struct {
+		MessageID       string             `xml:"MessageId"`
+		InsertionTime   serviceTimeRFC1123 `xml:"InsertionTime"`
+		ExpirationTime  serviceTimeRFC1123 `xml:"ExpirationTime"`
+		PopReceipt      string             `xml:"PopReceipt"`
+		TimeNextVisible serviceTimeRFC1123 `xml:"TimeNextVisible"`
+		DequeueCount    int64              `xml:"DequeueCount"`
+		MessageText     string             `xml:"MessageText"`
+	} `xml:"QueueMessage"`
+}
+
+// internal type used for unmarshalling
+type enqueuedMessagesListXML struct {
+	Items []struct {
+		MessageID       string             `xml:"MessageId"`
+		InsertionTime   serviceTimeRFC1123 `xml:"InsertionTime"`
+		ExpirationTime  serviceTimeRFC1123 `xml:"ExpirationTime"`
+		PopReceipt      string             `xml:"PopReceipt"`
+		TimeNextVisible serviceTimeRFC1123 `xml:"TimeNextVisible"`
+	} `xml:"QueueMessage"`
+}
+
+// internal type used for unmarshalling
+type peekedMessagesListXML struct {
+	Items []struct {
+		MessageID      string             `xml:"MessageId"`
+		InsertionTime  serviceTimeRFC1123 `xml:"InsertionTime"`
+		ExpirationTime serviceTimeRFC1123 `xml:"ExpirationTime"`
+		DequeueCount   int64              `xml:"DequeueCount"`
+		MessageText    string             `xml:"MessageText"`
+	} `xml:"QueueMessage"`
+}
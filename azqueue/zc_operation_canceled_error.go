@@ -0,0 +1,73 @@
+package azqueue
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// OperationCanceledError is returned by an operation whose context was canceled or exceeded its deadline.
+// errors.Is(err, context.Canceled) and errors.Is(err, context.DeadlineExceeded) tell the two cases apart.
+type OperationCanceledError struct {
+	// Operation is the name of the canceled operation, e.g. "Dequeue".
+	Operation string
+
+	// RequestSent is true if a request was written to the network before the operation was canceled; if so, the service
+	// may have performed the operation (for example, dequeued messages that are now invisible until their visibility
+	// timeout expires). If false, the service never received the request.
+	RequestSent bool
+
+	// Elapsed is how long the operation ran before it returned.
+	Elapsed time.Duration
+
+	// Err is the context's error: context.Canceled or context.DeadlineExceeded.
+	Err error
+
+	// Cause is the error returned by the pipeline.
+	Cause error
+}
+
+// Error implements the error interface's Error method.
+func (e *OperationCanceledError) Error() string {
+	return fmt.Sprintf("%s %v after %v (request sent: %t): %v", e.Operation, e.Err, e.Elapsed, e.RequestSent, e.Cause)
+}
+
+// Unwrap returns the context's error so errors.Is matches context.Canceled or context.DeadlineExceeded.
+func (e *OperationCanceledError) Unwrap() error {
+	return e.Err
+}
+
+// operationTracker records when an operation started and whether its request was written to the network.
+type operationTracker struct {
+	operation string
+	start     time.Time
+	sent      int32 // Accessed atomically; the HTTP client writes the request on its own goroutine
+}
+
+// trackOperation returns a context that records in the returned tracker whether a request sent with it was written to
+// the network. It relies on the pipeline's HTTP sender honoring the request's context, as the default sender does.
+func trackOperation(ctx context.Context, operation string) (context.Context, *operationTracker) {
+	t := &operationTracker{operation: operation, start: time.Now()}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { atomic.StoreInt32(&t.sent, 1) },
+	})
+	return ctx, t
+}
+
+// wrap returns err wrapped in an *OperationCanceledError if the caller's ctx is done; otherwise (including when err is
+// a context's error from a per-try context that expired before ctx), it returns err unchanged.
+func (t *operationTracker) wrap(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if err == nil || ctxErr == nil {
+		return err
+	}
+	return &OperationCanceledError{
+		Operation:   t.operation,
+		RequestSent: atomic.LoadInt32(&t.sent) == 1,
+		Elapsed:     time.Since(t.start),
+		Err:         ctxErr,
+		Cause:       err,
+	}
+}
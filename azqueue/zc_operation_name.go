@@ -0,0 +1,65 @@
+package azqueue
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+type operationNameKey struct{}
+
+// withOperationName returns a context naming the operation whose request is sent with it. The URL types' methods
+// call this before calling their client so that policies can tell which operation a request belongs to without parsing
+// its URL.
+func withOperationName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationNameKey{}, name)
+}
+
+// OperationName returns the name of the operation whose request is being sent with ctx, or "" if ctx wasn't passed to
+// a policy by one of this package's operations. The names are: CreateQueue, DeleteQueue, GetQueueProperties,
+// SetQueueMetadata, GetQueueAccessPolicy, SetQueueAccessPolicy, Enqueue, Dequeue, Peek, ClearMessages, UpdateMessage,
+// DeleteMessage, ListQueues, GetServiceProperties, SetServiceProperties, and GetServiceStatistics. Custom policies can
+// use it to attribute requests (for example, in logs or metrics); a request's retries all have the same name.
+func OperationName(ctx context.Context) string {
+	name, _ := ctx.Value(operationNameKey{}).(string)
+	return name
+}
+
+// splitRequestPath returns the kind of resource a request's URL refers to (service, queue, messages, or message) and
+// the name of its queue ("" for the service). Service requests are identified by their query parameters and the others
+// from the end of the path so that emulator URLs, whose paths begin with the account name, are handled too.
+func splitRequestPath(u *url.URL) (resource string, queueName string) {
+	if query := u.Query(); query.Get("restype") == "service" || query.Get("comp") == "list" {
+		return "service", ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch n := len(segments); {
+	case segments[n-1] == "messages":
+		if n >= 2 {
+			queueName = segments[n-2]
+		}
+		return "messages", queueName
+	case n >= 2 && segments[n-2] == "messages":
+		if n >= 3 {
+			queueName = segments[n-3]
+		}
+		return "message", queueName
+	case segments[n-1] == "":
+		return "service", ""
+	default:
+		return "queue", segments[n-1]
+	}
+}
+
+// operationFields formats the operation name in ctx and the queue name in u for logs and errors, for example
+// "Operation=Enqueue, Queue=orders". It returns "" if ctx has no operation name.
+func operationFields(ctx context.Context, u *url.URL) string {
+	name := OperationName(ctx)
+	if name == "" {
+		return ""
+	}
+	if _, queueName := splitRequestPath(u); queueName != "" {
+		return "Operation=" + name + ", Queue=" + queueName
+	}
+	return "Operation=" + name
+}
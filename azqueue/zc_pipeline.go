@@ -1,6 +1,8 @@
 package azqueue
 
 import (
+	"errors"
+
 	"github.com/Azure/azure-pipeline-go/pipeline"
 )
 
@@ -17,15 +19,97 @@ type PipelineOptions struct {
 
 	// Telemetry configures the built-in telemetry policy behavior.
 	Telemetry TelemetryOptions
+
+	// ServiceVersion overrides the x-ms-version header sent with every request (""=ServiceVersion). It must be in
+	// yyyy-mm-dd format. See NewServiceVersionPolicyFactory for more information.
+	ServiceVersion string
+
+	// Stats, if not nil, is updated with throttling and retry counters by the pipeline's retry and request log
+	// policies. Set it to a *PipelineStatsCounters (or your own PipelineStats) and read it to monitor the pipeline.
+	Stats PipelineStats
+
+	// PanicRecovery configures the optional panic recovery policy. See NewPanicRecoveryPolicyFactory.
+	PanicRecovery PanicRecoveryOptions
+
+	// RateLimiter, if not nil, paces every try of every request sent by the pipeline. Share one RateLimiter among
+	// several pipelines to limit their combined rate. See NewRateLimitPolicyFactory.
+	RateLimiter *RateLimiter
+}
+
+// PipelineOption sets one of the PipelineOptions used by NewPipelineWithOptions.
+type PipelineOption func(o *PipelineOptions)
+
+// WithLog returns a PipelineOption that sets PipelineOptions.Log.
+func WithLog(log pipeline.LogOptions) PipelineOption {
+	return func(o *PipelineOptions) { o.Log = log }
+}
+
+// WithRetry returns a PipelineOption that sets PipelineOptions.Retry.
+func WithRetry(retry RetryOptions) PipelineOption {
+	return func(o *PipelineOptions) { o.Retry = retry }
+}
+
+// WithRequestLog returns a PipelineOption that sets PipelineOptions.RequestLog.
+func WithRequestLog(requestLog RequestLogOptions) PipelineOption {
+	return func(o *PipelineOptions) { o.RequestLog = requestLog }
+}
+
+// WithTelemetry returns a PipelineOption that sets PipelineOptions.Telemetry.
+func WithTelemetry(telemetry TelemetryOptions) PipelineOption {
+	return func(o *PipelineOptions) { o.Telemetry = telemetry }
+}
+
+// WithServiceVersion returns a PipelineOption that sets PipelineOptions.ServiceVersion.
+func WithServiceVersion(serviceVersion string) PipelineOption {
+	return func(o *PipelineOptions) { o.ServiceVersion = serviceVersion }
+}
+
+// WithStats returns a PipelineOption that sets PipelineOptions.Stats.
+func WithStats(stats PipelineStats) PipelineOption {
+	return func(o *PipelineOptions) { o.Stats = stats }
+}
+
+// WithPanicRecovery returns a PipelineOption that sets PipelineOptions.PanicRecovery.
+func WithPanicRecovery(panicRecovery PanicRecoveryOptions) PipelineOption {
+	return func(o *PipelineOptions) { o.PanicRecovery = panicRecovery }
+}
+
+// WithRateLimiter returns a PipelineOption that sets PipelineOptions.RateLimiter.
+func WithRateLimiter(rateLimiter *RateLimiter) PipelineOption {
+	return func(o *PipelineOptions) { o.RateLimiter = rateLimiter }
 }
 
 // NewPipeline creates a Pipeline using the specified credentials and options.
 func NewPipeline(c Credential, o PipelineOptions) pipeline.Pipeline {
+	return NewPipelineWithOptions(c, func(po *PipelineOptions) { *po = o })
+}
+
+// NewPipelineWithOptions creates a Pipeline using the specified credentials and the PipelineOptions produced by
+// applying opts, in order, to the zero PipelineOptions. For example:
+//
+//	p := azqueue.NewPipelineWithOptions(credential, azqueue.WithRetry(azqueue.RetryOptions{MaxTries: 5}))
+func NewPipelineWithOptions(c Credential, opts ...PipelineOption) pipeline.Pipeline {
+	o := PipelineOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Closest to API goes first; closest to the wire goes last
-	f := []pipeline.Factory{
+	f := []pipeline.Factory{}
+	if o.PanicRecovery.Enabled {
+		f = append(f, NewPanicRecoveryPolicyFactory(o.PanicRecovery.Handler)) // First so it covers all other policies
+	}
+	f = append(f,
 		NewTelemetryPolicyFactory(o.Telemetry),
+		newRequestHeadersPolicyFactory(), // Precedes UniqueRequestIDPolicyFactory so a context can specify x-ms-client-request-id
 		NewUniqueRequestIDPolicyFactory(),
-		NewRetryPolicyFactory(o.Retry),
+		newRetryPolicyFactory(o.Retry, o.Stats))
+	if o.RateLimiter != nil {
+		f = append(f, NewRateLimitPolicyFactory(o.RateLimiter)) // Follows the retry policy so every try takes a token
+	}
+	if o.ServiceVersion != "" {
+		// NOTE: This must precede the credential's policy factory since Shared Key signs the x-ms-version header
+		f = append(f, NewServiceVersionPolicyFactory(o.ServiceVersion))
 	}
 
 	if _, ok := c.(*anonymousCredentialPolicyFactory); !ok {
@@ -35,9 +119,39 @@ func NewPipeline(c Credential, o PipelineOptions) pipeline.Pipeline {
 		f = append(f, c)
 	}
 	f = append(f,
-		NewRequestLogPolicyFactory(o.RequestLog),
+		newRequestLogPolicyFactory(o.RequestLog, o.Stats),
 		pipeline.MethodFactoryMarker()) // indicates at what stage in the pipeline the method factory is invoked
 
 
-	return pipeline.NewPipeline(f, pipeline.Options{HTTPSender: nil, Log: o.Log})
+	return &optionsPipeline{
+		Pipeline: pipeline.NewPipeline(f, pipeline.Options{HTTPSender: nil, Log: o.Log}),
+		c:        c,
+		o:        o,
+	}
+}
+
+// optionsPipeline is the Pipeline returned by NewPipeline. It remembers the credential and options used to create it
+// so that the URL types' WithRetryOptions and WithRequestLogOptions methods can create an equivalent pipeline.
+type optionsPipeline struct {
+	pipeline.Pipeline
+	c Credential
+	o PipelineOptions
+}
+
+// ErrPipelineOptionsUnknown is returned by the URL types' WithRetryOptions and WithRequestLogOptions methods when the
+// URL's pipeline wasn't created by NewPipeline or NewPipelineWithOptions (for example, a pipeline built from a custom
+// list of factories), so there is no way to know how it was configured. Create a pipeline with the desired options and
+// call the URL's WithPipeline method instead.
+var ErrPipelineOptionsUnknown = errors.New("changing pipeline options requires a pipeline created by NewPipeline; call WithPipeline instead")
+
+// withPipelineOptions creates a new pipeline using p's credential and options after update modifies the options.
+// It returns ErrPipelineOptionsUnknown if p was not created by NewPipeline.
+func withPipelineOptions(p pipeline.Pipeline, update func(o *PipelineOptions)) (pipeline.Pipeline, error) {
+	op, ok := unwrapResponsePipeline(p).(*optionsPipeline)
+	if !ok {
+		return nil, ErrPipelineOptionsUnknown
+	}
+	o := op.o
+	update(&o)
+	return NewPipeline(op.c, o), nil
 }
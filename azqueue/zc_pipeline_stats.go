@@ -0,0 +1,73 @@
+package azqueue
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// PipelineStats collects the counters that help investigate throttling. Set PipelineOptions.Stats to have the
+// pipeline's retry and request log policies update it; implementations must be goroutine-safe.
+type PipelineStats interface {
+	// IncThrottled is called for each try that gets a 503 (Service Unavailable, e.g. ServerBusy) response.
+	IncThrottled()
+
+	// IncRetriedSuccess is called for each operation that succeeded after more than one try.
+	IncRetriedSuccess()
+
+	// AddInFlight is called with 1 when a try is sent and with -1 when its response (or error) is received.
+	AddInFlight(delta int64)
+}
+
+// PipelineStatsSnapshot holds the values of a PipelineStatsCounters at a point in time.
+type PipelineStatsSnapshot struct {
+	Throttled      int64 // The number of tries that got a 503 response
+	RetriedSuccess int64 // The number of operations that succeeded only after being retried
+	InFlight       int64 // The number of tries currently waiting for a response
+}
+
+// PipelineStatsCounters is the default PipelineStats implementation; it keeps its counters in memory so that
+// applications can scrape them (for example, to export them as Prometheus metrics). The zero value is ready to use.
+type PipelineStatsCounters struct {
+	throttled      int64 // Accessed atomically
+	retriedSuccess int64 // Accessed atomically
+	inFlight       int64 // Accessed atomically
+}
+
+var _ PipelineStats = (*PipelineStatsCounters)(nil)
+
+// IncThrottled increments the throttled counter.
+func (c *PipelineStatsCounters) IncThrottled() {
+	atomic.AddInt64(&c.throttled, 1)
+}
+
+// IncRetriedSuccess increments the retried success counter.
+func (c *PipelineStatsCounters) IncRetriedSuccess() {
+	atomic.AddInt64(&c.retriedSuccess, 1)
+}
+
+// AddInFlight adds delta to the in-flight counter.
+func (c *PipelineStatsCounters) AddInFlight(delta int64) {
+	atomic.AddInt64(&c.inFlight, delta)
+}
+
+// Snapshot returns the counters' current values.
+func (c *PipelineStatsCounters) Snapshot() PipelineStatsSnapshot {
+	return PipelineStatsSnapshot{
+		Throttled:      atomic.LoadInt64(&c.throttled),
+		RetriedSuccess: atomic.LoadInt64(&c.retriedSuccess),
+		InFlight:       atomic.LoadInt64(&c.inFlight),
+	}
+}
+
+// isThrottled returns true if a try's response (or the StorageError created from it) has a 503 status code.
+func isThrottled(response pipeline.Response, err error) bool {
+	if response != nil && response.Response() != nil {
+		return response.Response().StatusCode == http.StatusServiceUnavailable
+	}
+	if stgErr, ok := asStorageError(err); ok && stgErr.Response() != nil {
+		return stgErr.Response().StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
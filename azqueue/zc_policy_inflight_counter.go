@@ -0,0 +1,34 @@
+package azqueue
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// InflightCounter reports how many requests are currently being processed by the policies created by the factory
+// returned from NewInflightCounterPolicyFactory. It is goroutine-safe.
+type InflightCounter struct {
+	count int64
+}
+
+// Count returns the number of requests currently in flight.
+func (c *InflightCounter) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// NewInflightCounterPolicyFactory creates a factory whose policies count the requests passing through them until a
+// response (or error) is returned; the returned InflightCounter reports the current count. Applications can use the
+// count to apply back-pressure, for example to slow down a dequeue loop. A request is counted once for all of its
+// retries if the factory is placed before the retry policy factory, or once per try if it is placed after it.
+func NewInflightCounterPolicyFactory() (*InflightCounter, pipeline.Factory) {
+	counter := &InflightCounter{}
+	return counter, pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			atomic.AddInt64(&counter.count, 1)
+			defer atomic.AddInt64(&counter.count, -1)
+			return next.Do(ctx, request)
+		}
+	})
+}
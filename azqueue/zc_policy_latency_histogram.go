@@ -0,0 +1,150 @@
+package azqueue
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// defaultLatencyBuckets are the bucket upper bounds used when NewLatencyHistogramPolicyFactory is passed no buckets.
+var defaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// LatencyHistogram records the latencies of the requests passing through the policies created by the factory
+// returned from NewLatencyHistogramPolicyFactory, counting them in buckets per operation. It is goroutine-safe.
+type LatencyHistogram struct {
+	buckets []time.Duration // Sorted bucket upper bounds
+
+	lock       sync.Mutex
+	operations map[string]*latencyCounts
+}
+
+// latencyCounts holds one operation's bucket counts; the last count is for latencies above the largest bucket.
+type latencyCounts struct {
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+// record adds a latency to the histogram's counts for operation.
+func (h *LatencyHistogram) record(operation string, latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	lc, ok := h.operations[operation]
+	if !ok {
+		lc = &latencyCounts{counts: make([]int64, len(h.buckets)+1)}
+		h.operations[operation] = lc
+	}
+	lc.counts[sort.Search(len(h.buckets), func(i int) bool { return latency <= h.buckets[i] })]++
+	lc.total++
+	if latency > lc.max {
+		lc.max = latency
+	}
+}
+
+// Percentile returns an estimate of the p-th percentile (0 < p <= 100) of the latencies of all operations: the upper
+// bound of the bucket containing it, or the largest recorded latency if it is above the largest bucket. It returns 0
+// if no latencies have been recorded.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	all := &latencyCounts{counts: make([]int64, len(h.buckets)+1)}
+	for _, lc := range h.operations {
+		for i, n := range lc.counts {
+			all.counts[i] += n
+		}
+		all.total += lc.total
+		if lc.max > all.max {
+			all.max = lc.max
+		}
+	}
+	return h.percentile(all, p)
+}
+
+// OperationPercentile is like Percentile but only considers the latencies of operation (see Operations).
+func (h *LatencyHistogram) OperationPercentile(operation string, p float64) time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	lc, ok := h.operations[operation]
+	if !ok {
+		return 0
+	}
+	return h.percentile(lc, p)
+}
+
+// percentile returns the p-th percentile of lc; h.lock must be held.
+func (h *LatencyHistogram) percentile(lc *latencyCounts, p float64) time.Duration {
+	if lc.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(float64(lc.total) * p / 100))
+	cumulative := int64(0)
+	for i, n := range lc.counts[:len(h.buckets)] {
+		cumulative += n
+		if cumulative >= rank {
+			return h.buckets[i]
+		}
+	}
+	return lc.max
+}
+
+// Operations returns the sorted names of the operations with recorded latencies, for example "GET messages" or
+// "PUT queue?comp=metadata".
+func (h *LatencyHistogram) Operations() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	operations := make([]string, 0, len(h.operations))
+	for operation := range h.operations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// Reset discards all recorded latencies.
+func (h *LatencyHistogram) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.operations = map[string]*latencyCounts{}
+}
+
+// NewLatencyHistogramPolicyFactory creates a factory whose policies record how long each request takes to get a
+// response (or error) in the returned LatencyHistogram. buckets are the upper bounds of the histogram's buckets
+// (nil=default buckets from 5ms to 10s); the more buckets, the more precise the percentiles. Latencies are recorded per
+// operation, named after the request's method, the kind of resource in its URL path (service, queue, messages, or
+// message), and its comp query parameter. A request is measured once for all of its retries if the factory is placed
+// before the retry policy factory, or once per try if it is placed after it.
+func NewLatencyHistogramPolicyFactory(buckets []time.Duration) (*LatencyHistogram, pipeline.Factory) {
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+	h := &LatencyHistogram{buckets: append([]time.Duration(nil), buckets...), operations: map[string]*latencyCounts{}}
+	sort.Slice(h.buckets, func(i, j int) bool { return h.buckets[i] < h.buckets[j] })
+	return h, pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			start := time.Now()
+			response, err := next.Do(ctx, request)
+			h.record(operationName(request.Request), time.Since(start))
+			return response, err
+		}
+	})
+}
+
+// operationName names a request's operation after its method, the kind of resource in its URL path, and its comp
+// query parameter.
+func operationName(r *http.Request) string {
+	resource, _ := splitRequestPath(r.URL)
+	name := r.Method + " " + resource
+	if comp := r.URL.Query().Get("comp"); comp != "" {
+		name += "?comp=" + comp
+	}
+	return name
+}
@@ -0,0 +1,60 @@
+package azqueue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// PanicRecoveryOptions configures the pipeline's panic recovery policy.
+type PanicRecoveryOptions struct {
+	// Enabled adds a policy, first in the pipeline, that recovers from a panic in any later policy (including custom
+	// ones) or in the HTTP sender and returns it as a *PanicError instead of crashing the process.
+	Enabled bool
+
+	// Handler, if not nil, is called with the recovered value and the request whenever a panic is recovered; use it to
+	// log or report the panic. See NewPanicRecoveryPolicyFactory.
+	Handler func(recovered interface{}, request pipeline.Request)
+}
+
+// PanicError is returned by a policy created by NewPanicRecoveryPolicyFactory when it recovers from a panic.
+type PanicError struct {
+	// Recovered is the value passed to panic.
+	Recovered interface{}
+
+	// Stack is the stack trace of the goroutine that panicked, captured when the panic was recovered.
+	Stack []byte
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovered from a panic while sending a request: %v", e.Recovered)
+}
+
+// Unwrap returns the recovered value if it is an error; otherwise, it returns nil.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Recovered.(error)
+	return err
+}
+
+// NewPanicRecoveryPolicyFactory creates a factory whose policies recover from a panic in the policies that follow them
+// (and the HTTP sender) and return a *PanicError wrapping the recovered value instead. If handler is not nil, it is
+// called with the recovered value and the request first. Place the factory first so that it covers every policy; a
+// recovered panic is not retried. Panics on other goroutines, such as ones started by a custom HTTP transport, can't be
+// recovered.
+func NewPanicRecoveryPolicyFactory(handler func(recovered interface{}, request pipeline.Request)) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (response pipeline.Response, err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					if handler != nil {
+						handler(recovered, request)
+					}
+					response, err = nil, &PanicError{Recovered: recovered, Stack: stack()}
+				}
+			}()
+			return next.Do(ctx, request)
+		}
+	})
+}
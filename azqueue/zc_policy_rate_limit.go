@@ -0,0 +1,122 @@
+package azqueue
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// RateLimiterClock is the source of time used by a RateLimiter. Tests can supply a fake clock to control time.
+type RateLimiterClock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives a value once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the RateLimiterClock that uses the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RateLimiterOptions configures a RateLimiter.
+type RateLimiterOptions struct {
+	// OpsPerSecond is the sustained number of requests allowed per second; it must be > 0. For example, stay under a
+	// storage account's scalability target (20,000 requests per second) or your own share of it.
+	OpsPerSecond float64
+
+	// Burst is the number of requests that can be sent at once after a period of inactivity (0=default of 1).
+	Burst int
+
+	// Clock is the source of time (nil=the system clock).
+	Clock RateLimiterClock
+}
+
+// RateLimiter is a token bucket that paces the requests sent by the pipelines that share it: each request takes a
+// token, tokens are added at OpsPerSecond up to Burst, and a request waits while no token is available. Share one
+// RateLimiter among all of the pipelines that access an account to apply an account-wide limit. It is goroutine-safe.
+type RateLimiter struct {
+	clock RateLimiterClock
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64 // Negative when requests are waiting for tokens that they've reserved
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter configured using the specified options; it starts with Burst tokens. Pass it
+// to NewRateLimitPolicyFactory or set PipelineOptions.RateLimiter.
+func NewRateLimiter(o RateLimiterOptions) (*RateLimiter, error) {
+	if o.OpsPerSecond <= 0 || math.IsInf(o.OpsPerSecond, 0) || math.IsNaN(o.OpsPerSecond) {
+		return nil, errors.New("OpsPerSecond must be > 0")
+	}
+	if o.Burst < 0 {
+		return nil, errors.New("Burst must be >= 0")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	return &RateLimiter{clock: o.Clock, rate: o.OpsPerSecond, burst: float64(o.Burst), tokens: float64(o.Burst), last: o.Clock.Now()}, nil
+}
+
+// Wait takes a token, waiting until one is available. If ctx is done first, Wait returns ctx's error and the token
+// isn't taken.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-l.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++ // Return the reserved token so that later requests don't wait for it
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, which may not be available yet, and returns how long to wait until it is.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// NewRateLimitPolicyFactory creates a factory whose policies wait for a token from limiter before passing each request
+// on. Place it after the retry policy factory (as NewPipeline does for PipelineOptions.RateLimiter) so that every
+// try takes a token. If the request's context is done while waiting, the request isn't sent and the context's error
+// is returned.
+func NewRateLimitPolicyFactory(limiter *RateLimiter) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+			return next.Do(ctx, request)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package azqueue
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// requestHeadersContextKey is the context key used by WithRequestHeaders.
+type requestHeadersContextKey struct{}
+
+// WithRequestHeaders returns a context that adds header to every request sent by an operation called with the
+// returned context. If ctx already has headers added by WithRequestHeaders, header is merged with them (header's
+// values win). A header that the package already set on the request (for example, x-ms-version or Content-Length)
+// is never replaced. The headers are added before the request is signed so any x-ms-* headers are covered by a
+// Shared Key signature. For example, to tag a single call with a tenant ID and a specific client request ID:
+//
+//	ctx = azqueue.WithRequestHeaders(ctx, http.Header{"X-Tenant-Id": {"contoso"}, "X-Ms-Client-Request-Id": {id}})
+//	_, err := messagesURL.Enqueue(ctx, "message", 0, 0)
+func WithRequestHeaders(ctx context.Context, header http.Header) context.Context {
+	merged := http.Header{}
+	if parent, ok := ctx.Value(requestHeadersContextKey{}).(http.Header); ok {
+		for k, v := range parent {
+			merged[k] = v
+		}
+	}
+	for k, v := range header {
+		merged[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	return context.WithValue(ctx, requestHeadersContextKey{}, merged)
+}
+
+// newRequestHeadersPolicyFactory creates a factory whose policies add the headers in each operation's context (see
+// WithRequestHeaders) to its request.
+func newRequestHeadersPolicyFactory() pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if header, ok := ctx.Value(requestHeadersContextKey{}).(http.Header); ok {
+				for k, v := range header {
+					if _, exists := request.Header[k]; !exists {
+						request.Header[k] = v
+					}
+				}
+			}
+			return next.Do(ctx, request)
+		}
+	})
+}
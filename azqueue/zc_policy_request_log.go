@@ -18,19 +18,39 @@ type RequestLogOptions struct {
 	// LogWarningIfTryOverThreshold logs a warning if a tried operation takes longer than the specified
 	// duration (-1=no logging; 0=default threshold).
 	LogWarningIfTryOverThreshold time.Duration
+
+	// SensitiveQueryParams lists the URL query parameters whose values are replaced with "[REDACTED]" in logged
+	// requests, in addition to the SAS signature ("sig"), which is always redacted. Names are case-insensitive.
+	SensitiveQueryParams []string
+
+	// SensitiveHeaders lists the request headers whose values are replaced with "[REDACTED]" in logged requests, in
+	// addition to "Authorization", which is always redacted. Names are case-insensitive.
+	SensitiveHeaders []string
 }
 
+// redactedValue replaces the values of sensitive query parameters and headers in logged requests and URL strings.
+const redactedValue = "[REDACTED]"
+
 func (o RequestLogOptions) defaults() RequestLogOptions {
 	if o.LogWarningIfTryOverThreshold == 0 {
 		// It would be good to relate this to https://azure.microsoft.com/en-us/support/legal/sla/storage/v1_2/
 		// But this monitors the time to get the HTTP response; NOT the time to download the response body.
 		o.LogWarningIfTryOverThreshold = 3 * time.Second // Default to 3 seconds
 	}
+	// Copy the slices so that the caller's aren't modified
+	o.SensitiveQueryParams = append([]string{"sig"}, o.SensitiveQueryParams...)
+	o.SensitiveHeaders = append([]string{"Authorization"}, o.SensitiveHeaders...)
 	return o
 }
 
 // NewRequestLogPolicyFactory creates a RequestLogPolicyFactory object configured using the specified options.
 func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
+	return newRequestLogPolicyFactory(o, nil)
+}
+
+// newRequestLogPolicyFactory creates a request log policy factory that also updates stats' throttled and in-flight
+// counters (if stats is not nil).
+func newRequestLogPolicyFactory(o RequestLogOptions, stats PipelineStats) pipeline.Factory {
 	o = o.defaults() // Force defaults to be calculated
 	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 		// These variables are per-policy; shared by multiple calls to Do
@@ -38,18 +58,31 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 		operationStart := time.Now() // If this is the 1st try, record the operation state time
 		return func(ctx context.Context, request pipeline.Request) (response pipeline.Response, err error) {
 			try++ // The first try is #1 (not #0)
+			fields := operationFields(ctx, request.URL) // Identifies the operation and queue in both log entries
+			if fields != "" {
+				fields += ", "
+			}
 
 			// Log the outgoing request as informational
 			if po.ShouldLog(pipeline.LogInfo) {
 				b := &bytes.Buffer{}
-				fmt.Fprintf(b, "==> OUTGOING REQUEST (Try=%d)\n", try)
-				pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(request), nil, nil)
+				fmt.Fprintf(b, "==> OUTGOING REQUEST (%sTry=%d)\n", fields, try)
+				pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(request, o), nil, nil)
 				po.Log(pipeline.LogInfo, b.String())
 			}
 
 			// Set the time for this particular retry operation and then Do the operation.
 			tryStart := time.Now()
+			if stats != nil {
+				stats.AddInFlight(1)
+			}
 			response, err = next.Do(ctx, request) // Make the request
+			if stats != nil {
+				stats.AddInFlight(-1)
+				if isThrottled(response, err) {
+					stats.IncThrottled()
+				}
+			}
 			tryEnd := time.Now()
 			tryDuration := tryEnd.Sub(tryStart)
 			opDuration := tryEnd.Sub(operationStart)
@@ -80,7 +113,7 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 				if o.LogWarningIfTryOverThreshold > 0 && tryDuration > o.LogWarningIfTryOverThreshold {
 					slow = fmt.Sprintf("[SLOW >%v]", o.LogWarningIfTryOverThreshold)
 				}
-				fmt.Fprintf(b, "==> REQUEST/RESPONSE (Try=%d/%v%s, OpTime=%v) -- ", try, tryDuration, slow, opDuration)
+				fmt.Fprintf(b, "==> REQUEST/RESPONSE (%sTry=%d/%v%s, OpTime=%v) -- ", fields, try, tryDuration, slow, opDuration)
 				if err != nil { // This HTTP request did not get a response from the service
 					fmt.Fprint(b, "REQUEST ERROR\n")
 				} else {
@@ -91,7 +124,7 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 					}
 				}
 
-				pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(request), response.Response(), err)
+				pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(request, o), response.Response(), err)
 				if logLevel <= pipeline.LogError {
 					b.Write(stack()) // For errors (or lower levels), we append the stack trace (an expensive operation)
 				}
@@ -111,31 +144,60 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 
 // redactSigQueryParam redacts the 'sig' query parameter in URL's raw query to protect secret.
 func redactSigQueryParam(rawQuery string) (bool, string) {
-	rawQuery = strings.ToLower(rawQuery) // lowercase the string so we can look for ?sig= and &sig=
-	sigFound := strings.Contains(rawQuery, "?sig=")
+	lowerQuery := strings.ToLower(rawQuery) // lowercase the string so we can look for sig=, ?sig= and &sig=
+	sigFound := strings.HasPrefix(lowerQuery, "sig=") || strings.Contains(lowerQuery, "?sig=") || strings.Contains(lowerQuery, "&sig=")
 	if !sigFound {
-		sigFound = strings.Contains(rawQuery, "&sig=")
-		if !sigFound {
-			return sigFound, rawQuery // [?|&]sig= not found; return same rawQuery passed in (no memory allocation)
-		}
+		return sigFound, rawQuery // [?|&]sig= not found; return same rawQuery passed in (no memory allocation)
 	}
 	// [?|&]sig= found, redact its value
-	values, _ := url.ParseQuery(rawQuery)
+	return redactQueryParams(rawQuery, []string{"sig"})
+}
+
+// redactedURLString returns u as a string with the value of its 'sig' query parameter (if any) redacted.
+func redactedURLString(u url.URL) string {
+	if sigFound, rawQuery := redactSigQueryParam(u.RawQuery); sigFound {
+		u.RawQuery = rawQuery
+	}
+	return u.String()
+}
+
+// redactQueryParams replaces the values of the query parameters in rawQuery named by names (case-insensitively)
+// with "[REDACTED]". It returns false and the same rawQuery if none of them is present. Parameters that can't be
+// parsed are removed since they might be sensitive.
+func redactQueryParams(rawQuery string, names []string) (bool, string) {
+	values, err := url.ParseQuery(rawQuery)
+	found := err != nil
 	for name := range values {
-		if strings.EqualFold(name, "sig") {
-			values[name] = []string{"REDACTED"}
+		for _, sensitive := range names {
+			if strings.EqualFold(name, sensitive) {
+				values[name] = []string{redactedValue}
+				found = true
+			}
 		}
 	}
-	return sigFound, values.Encode()
+	if !found {
+		return false, rawQuery
+	}
+	return true, strings.Replace(values.Encode(), url.QueryEscape(redactedValue), redactedValue, -1) // Keep it readable
 }
 
-func prepareRequestForLogging(request pipeline.Request) *http.Request {
+// prepareRequestForLogging returns the request to log: a copy of request with the values of o's sensitive query
+// parameters and headers redacted, or request itself if it has none.
+func prepareRequestForLogging(request pipeline.Request, o RequestLogOptions) *http.Request {
 	req := request
-	if sigFound, rawQuery := redactSigQueryParam(req.URL.RawQuery); sigFound {
+	if found, rawQuery := redactQueryParams(req.URL.RawQuery, o.SensitiveQueryParams); found {
 		// Make copy so we don't destroy the query parameters we actually need to send in the request
 		req = request.Copy()
 		req.Request.URL.RawQuery = rawQuery
 	}
+	for _, sensitive := range o.SensitiveHeaders {
+		if exist, key := doesHeaderExistCaseInsensitive(req.Header, sensitive); exist {
+			if req.Request == request.Request {
+				req = request.Copy() // The copy's headers are separate from the ones we send
+			}
+			req.Header[key] = []string{redactedValue}
+		}
+	}
 
 	return prepareRequestForServiceLogging(req)
 }
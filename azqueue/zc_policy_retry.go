@@ -3,8 +3,10 @@ package azqueue
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -55,11 +57,78 @@ type RetryOptions struct {
 	// If you specify 0, then you must also specify 0 for RetryDelay.
 	MaxRetryDelay time.Duration
 
+	// MaxRetryBudget limits the total time an operation spends on tries and the delays between them (0=unlimited).
+	// The retry policy doesn't start a retry (or wait before one) that would begin after the budget is spent; instead,
+	// it returns the last try's error wrapped in a *RetryBudgetExceededError. A try that has started isn't interrupted,
+	// so an operation can take up to MaxRetryBudget plus TryTimeout. The operation's context deadline, if any, still
+	// applies; whichever is reached first ends the operation.
+	MaxRetryBudget time.Duration
+
 	// RetryReadsFromSecondaryHost specifies whether the retry policy should retry a read operation against another host.
 	// If RetryReadsFromSecondaryHost is "" (the default) then operations are not retried against another host.
 	// NOTE: Before setting this field, make sure you understand the issues around reading stale & potentially-inconsistent
 	// data at this webpage: https://docs.microsoft.com/en-us/azure/storage/common/storage-designing-ha-apps-with-ragrs
 	RetryReadsFromSecondaryHost string
+
+	// backoff holds the multiplier and jitter set by WithExponentialBackoff (zero values use the default algorithm).
+	backoff BackoffOptions
+}
+
+// RetryBudgetExceededError is returned by the retry policy when it stops retrying an operation because another retry
+// would exceed RetryOptions.MaxRetryBudget. Use errors.As (for example, with a StorageError) to examine the last try's
+// error.
+type RetryBudgetExceededError struct {
+	// Budget is the RetryOptions.MaxRetryBudget that was exhausted.
+	Budget time.Duration
+
+	// Elapsed is how long the operation had run when the retry policy stopped retrying.
+	Elapsed time.Duration
+
+	// Tries is the number of tries made.
+	Tries int32
+
+	// Err is the last try's error.
+	Err error
+}
+
+// Error implements the error interface's Error method.
+func (e *RetryBudgetExceededError) Error() string {
+	return fmt.Sprintf("retry budget of %v exhausted after %d tries in %v: %v", e.Budget, e.Tries, e.Elapsed, e.Err)
+}
+
+// Unwrap returns the last try's error.
+func (e *RetryBudgetExceededError) Unwrap() error {
+	return e.Err
+}
+
+// BackoffOptions configures how long the retry policy waits between tries, independently of when it retries.
+// Apply it to a RetryOptions by calling RetryOptions' WithExponentialBackoff method.
+type BackoffOptions struct {
+	// InitialDelay specifies the delay before the first retry (0=default of 4 seconds).
+	InitialDelay time.Duration
+
+	// MaxDelay specifies the maximum delay before any retry (0=default of 120 seconds).
+	MaxDelay time.Duration
+
+	// Multiplier specifies the factor by which the delay grows with each retry (0=default of 2).
+	Multiplier float64
+
+	// Jitter specifies the maximum fraction by which each delay is randomly lengthened or shortened; for example,
+	// 0.2 produces delays between 80% and 120% of the computed delay (0=default of the range 80% to 130%).
+	Jitter float64
+}
+
+// WithExponentialBackoff returns a copy of the RetryOptions that uses the exponential retry policy with the delays
+// described by b. The retry delay before try n (n >= 2) is InitialDelay * Multiplier^(n-2), randomized by Jitter and
+// limited to MaxDelay. The copy's RetryDelay and MaxRetryDelay fields are set from b; all other fields are unchanged.
+func (o RetryOptions) WithExponentialBackoff(b BackoffOptions) RetryOptions {
+	o.Policy = RetryPolicyExponential
+	o.RetryDelay, o.MaxRetryDelay = b.InitialDelay, b.MaxDelay
+	if b.Multiplier == 0 {
+		b.Multiplier = 2
+	}
+	o.backoff = b
+	return o
 }
 
 func (o RetryOptions) retryReadsFromSecondaryHost() string {
@@ -110,6 +179,9 @@ func (o RetryOptions) calcDelay(try int32) time.Duration { // try is >=1; never
 	delay := time.Duration(0)
 	switch o.Policy {
 	case RetryPolicyExponential:
+		if o.backoff.Multiplier != 0 {
+			return o.calcBackoffDelay(try)
+		}
 		delay = time.Duration(pow(2, try-1)-1) * o.RetryDelay
 
 	case RetryPolicyFixed:
@@ -126,11 +198,57 @@ func (o RetryOptions) calcDelay(try int32) time.Duration { // try is >=1; never
 	return delay
 }
 
-// NewRetryPolicyFactory creates a RetryPolicyFactory object configured using the specified options.
+// calcBackoffDelay computes the delay before a try using the BackoffOptions set by WithExponentialBackoff.
+func (o RetryOptions) calcBackoffDelay(try int32) time.Duration {
+	if try < 2 {
+		return 0 // The first try is never delayed
+	}
+	delay := float64(o.RetryDelay) * math.Pow(o.backoff.Multiplier, float64(try-2))
+	if o.backoff.Jitter != 0 {
+		delay *= 1 - o.backoff.Jitter + 2*o.backoff.Jitter*rand.Float64() // NOTE: We want math/rand; not crypto/rand
+	} else {
+		delay *= rand.Float64()/2 + 0.8
+	}
+	if delay > float64(o.MaxRetryDelay) {
+		return o.MaxRetryDelay
+	}
+	return time.Duration(delay)
+}
+
+// retryOptionsContextKey is the context key used by WithRetryOptions.
+type retryOptionsContextKey struct{}
+
+// WithRetryOptions returns a context that makes the retry policy use o, instead of the options the pipeline was created
+// with, for any operation called with the returned context. The options in the context replace the pipeline's options
+// entirely: fields left at their zero value get the retry policy's defaults, not the pipeline's values. For example,
+// a health check can make a single short attempt without creating a new URL object:
+//
+//	ctx = azqueue.WithRetryOptions(ctx, azqueue.RetryOptions{MaxTries: 1, TryTimeout: 2 * time.Second})
+//	_, err := queueURL.GetProperties(ctx)
+func WithRetryOptions(ctx context.Context, o RetryOptions) context.Context {
+	return context.WithValue(ctx, retryOptionsContextKey{}, o)
+}
+
+// NewRetryPolicyFactory creates a RetryPolicyFactory object configured using the specified options. The policy records
+// in each successful response how many tries it took and how long they took, including the delays between them; the
+// response types' Attempts and TotalLatency methods return these values.
 func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
+	return newRetryPolicyFactory(o, nil)
+}
+
+// newRetryPolicyFactory creates a retry policy factory that also updates stats' retried success counter (if stats
+// is not nil).
+func newRetryPolicyFactory(o RetryOptions, stats PipelineStats) pipeline.Factory {
 	o = o.defaults() // Force defaults to be calculated
 	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 		return func(ctx context.Context, request pipeline.Request) (response pipeline.Response, err error) {
+			o := o // Use the pipeline's options unless the operation's context overrides them
+			if ctxOptions, ok := ctx.Value(retryOptionsContextKey{}).(RetryOptions); ok {
+				o = ctxOptions.defaults()
+			}
+
+			start := time.Now() // MaxRetryBudget and TotalLatency are measured from when the first try started
+
 			// Before each try, we'll select either the primary or secondary URL.
 			primaryTry := int32(0) // This indicates how many tries we've attempted against the primary DC
 
@@ -150,16 +268,23 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 				// Determine which endpoint to try. It's primary if there is no secondary or if it is an add # attempt.
 				tryingPrimary := !considerSecondary || (try%2 == 1)
 				// Select the correct host and delay
+				var delay time.Duration
 				if tryingPrimary {
 					primaryTry++
-					delay := o.calcDelay(primaryTry)
+					delay = o.calcDelay(primaryTry) // The 1st try returns 0 delay
 					logf("Primary try=%d, Delay=%v\n", primaryTry, delay)
-					time.Sleep(delay) // The 1st try returns 0 delay
 				} else {
-					delay := time.Second * time.Duration(rand.Float32()/2+0.8)
+					delay = time.Second * time.Duration(rand.Float32()/2+0.8) // Delay with some jitter before trying secondary
 					logf("Secondary try=%d, Delay=%v\n", try-primaryTry, delay)
-					time.Sleep(delay) // Delay with some jitter before trying secondary
 				}
+				if elapsed := time.Since(start); try > 1 && o.MaxRetryBudget > 0 && elapsed+delay >= o.MaxRetryBudget {
+					logf("Retry budget of %v exhausted after %v\n", o.MaxRetryBudget, elapsed)
+					if err != nil {
+						err = &RetryBudgetExceededError{Budget: o.MaxRetryBudget, Elapsed: elapsed, Tries: try - 1, Err: err}
+					}
+					return response, err
+				}
+				time.Sleep(delay)
 
 				// Clone the original request to ensure that each try starts with the original (unmutated) request.
 				requestCopy := request.Copy()
@@ -194,8 +319,9 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 				requestCopy.Request.URL.RawQuery = q.Encode()
 				logf("Url=%s\n", requestCopy.Request.URL.String())
 
-				// Set the time for this particular retry operation and then Do the operation.
-				tryCtx, tryCancel := context.WithTimeout(ctx, time.Second*time.Duration(timeout))
+				// Set the time for this particular retry operation and then Do the operation. The user's ctx deadline
+				// still applies to tryCtx; the whole seconds in timeout would end a try with less than 1 second left at once.
+				tryCtx, tryCancel := context.WithTimeout(ctx, o.TryTimeout)
 				//requestCopy.Body = &deadlineExceededReadCloser{r: requestCopy.Request.Body}
 				response, err = next.Do(tryCtx, requestCopy) // Make the request
 				/*err = improveDeadlineExceeded(err)
@@ -218,7 +344,7 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 					// NOTE: Protocol Responder returns non-nil if REST API returns invalid status code for the invoked operation.
 					// Use ServiceCode to verify if the error is related to storage service-side,
 					// ServiceCode is set only when error related to storage service happened.
-					if stErr, ok := err.(StorageError); ok {
+					if stErr, ok := asStorageError(err); ok {
 						if stErr.Temporary() {
 							action = "Retry: StorageError with error service code and Temporary()"
 						} else if stErr.Response() != nil && isSuccessStatusCode(stErr.Response()) { // TODO: This is a temporarily work around, remove this after protocol layer fix the issue that net.Error is wrapped as storageError
@@ -249,6 +375,9 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 					if err != nil {
 						tryCancel() // If we're returning an error, cancel this current/last per-retry timeout context
 					} else {
+						if try > 1 && stats != nil {
+							stats.IncRetriedSuccess()
+						}
 						// We wrap the last per-try context in a body and overwrite the Response's Body field with our wrapper.
 						// So, when the user closes the Body, the our per-try context gets closed too.
 						// Another option, is that the Last Policy do this wrapping for a per-retry context (not for the user's context)
@@ -259,7 +388,8 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 							// as in this case, current per-try has nothing to do in future.
 							return nil, errors.New("invalid state, response should not be nil when the operation is executed successfully")
 						}
-						response.Response().Body = &contextCancelReadCloser{cf: tryCancel, body: response.Response().Body}
+						response.Response().Body = &contextCancelReadCloser{cf: tryCancel, body: response.Response().Body,
+							tries: try, totalLatency: time.Since(start)}
 					}
 					break // Don't retry
 				}
@@ -277,10 +407,40 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 	})
 }
 
+// ResponseAttempts returns the number of tries the retry policy made to get resp, which may be any of this package's
+// response types, or 0 if resp didn't pass through a retry policy (for example, because its pipeline has none).
+func ResponseAttempts(resp pipeline.Response) int32 {
+	if rc, ok := retryResponseBody(resp); ok {
+		return rc.tries
+	}
+	return 0
+}
+
+// ResponseTotalLatency returns the time the retry policy took to get resp, including the delays between tries, or 0
+// if resp didn't pass through a retry policy.
+func ResponseTotalLatency(resp pipeline.Response) time.Duration {
+	if rc, ok := retryResponseBody(resp); ok {
+		return rc.totalLatency
+	}
+	return 0
+}
+
+// retryResponseBody returns the body the retry policy gave resp's HTTP response, if it has one.
+func retryResponseBody(resp pipeline.Response) (*contextCancelReadCloser, bool) {
+	if resp == nil || resp.Response() == nil {
+		return nil, false
+	}
+	rc, ok := resp.Response().Body.(*contextCancelReadCloser)
+	return rc, ok
+}
+
 // contextCancelReadCloser helps to invoke context's cancelFunc properly when the ReadCloser is closed.
 type contextCancelReadCloser struct {
 	cf   context.CancelFunc
 	body io.ReadCloser
+
+	tries        int32         // The number of tries made to get the response (see ResponseAttempts)
+	totalLatency time.Duration // The time the tries took, including the delays between them (see ResponseTotalLatency)
 }
 
 func (rc *contextCancelReadCloser) Read(p []byte) (n int, err error) {
@@ -0,0 +1,36 @@
+package azqueue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// NewServiceVersionPolicyFactory creates a factory whose policies set each request's x-ms-version header to version,
+// overriding ServiceVersion (the version the package's operations were generated for). This allows pinning an older
+// version (for example, for Azure Stack) or testing against service builds that require a newer version. version must
+// be a date in yyyy-mm-dd format; if it isn't, the policies fail every request with an error instead of sending it.
+// NOTE: The package's requests and response parsing match ServiceVersion; a different version may change the service's
+// behavior or responses in ways the package does not handle.
+func NewServiceVersionPolicyFactory(version string) pipeline.Factory {
+	err := validateServiceVersion(version)
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if err != nil {
+				return nil, err
+			}
+			request.Header.Set(headerXmsVersion, version)
+			return next.Do(ctx, request)
+		}
+	})
+}
+
+// validateServiceVersion returns an error if version isn't a valid date in the yyyy-mm-dd format service versions use.
+func validateServiceVersion(version string) error {
+	if _, err := time.Parse("2006-01-02", version); err != nil {
+		return fmt.Errorf("service version %q must be a date in yyyy-mm-dd format", version)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"os"
 	"runtime"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -12,21 +11,37 @@ import (
 
 // TelemetryOptions configures the telemetry policy's behavior.
 type TelemetryOptions struct {
-	// Value is a string prepended to each request's User-Agent and sent to the service.
+	// Value is the application ID prepended to each request's User-Agent and sent to the service.
 	// The service records the user-agent in logs for diagnostics and tracking of client requests.
+	// The resulting User-Agent is "<Value> azqueue/<SDKVersion> (<Go version>; <OS>)".
 	Value string
+
+	// UserAgent, if not empty, is sent as each request's entire User-Agent instead of the one composed from Value,
+	// SDKVersion, and the platform; use it in environments that must not disclose platform information.
+	UserAgent string
+
+	// DisableTelemetry, if true, sends no User-Agent header at all; neither Value nor UserAgent is sent.
+	// Use it in deployments that must not identify the SDK to the service.
+	DisableTelemetry bool
 }
 
 // NewTelemetryPolicyFactory creates a factory that can create telemetry policy objects
 // which add telemetry information to outgoing HTTP requests.
 func NewTelemetryPolicyFactory(o TelemetryOptions) pipeline.Factory {
-	b := &bytes.Buffer{}
-	b.WriteString(o.Value)
-	if b.Len() > 0 {
-		b.WriteRune(' ')
+	telemetryValue := o.UserAgent
+	if telemetryValue == "" {
+		b := &bytes.Buffer{}
+		b.WriteString(o.Value)
+		if b.Len() > 0 {
+			b.WriteRune(' ')
+		}
+		fmt.Fprintf(b, "azqueue/%s %s", SDKVersion, platformInfo)
+		telemetryValue = b.String()
+	}
+
+	if o.DisableTelemetry {
+		telemetryValue = "" // net/http sends no User-Agent, not even its default one, when the header is set but empty
 	}
-	fmt.Fprintf(b, "Azure-Storage/%s %s", serviceLibVersion, platformInfo)
-	telemetryValue := b.String()
 
 	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
@@ -36,16 +51,5 @@ func NewTelemetryPolicyFactory(o TelemetryOptions) pipeline.Factory {
 	})
 }
 
-// NOTE: the ONLY function that should write to this variable is this func
-var platformInfo = func() string {
-	// Azure-Storage/version (runtime; os type and version)”
-	// Azure-Storage/1.4.0 (NODE-VERSION v4.5.0; Windows_NT 10.0.14393)'
-	operatingSystem := runtime.GOOS // Default OS string
-	switch operatingSystem {
-	case "windows":
-		operatingSystem = os.Getenv("OS") // Get more specific OS information
-	case "linux": // accept default OS info
-	case "freebsd": //  accept default OS info
-	}
-	return fmt.Sprintf("(%s; %s)", runtime.Version(), operatingSystem)
-}()
+// platformInfo is the Go version and operating system, e.g. "(go1.10.3; linux)".
+var platformInfo = fmt.Sprintf("(%s; %s)", runtime.Version(), runtime.GOOS)
@@ -0,0 +1,126 @@
+package azqueue
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// responsePipeline is the pipeline the URL types give the generated clients. It wraps the pipeline passed to the URL
+// type's constructor so that each operation's method policy (the generated responder) is preceded, nearer the wire,
+// by an errorBodyPolicy and followed by an operationErrorPolicy. This lets the errors the responders create carry
+// information the generated code discards.
+type responsePipeline struct {
+	pipeline.Pipeline
+	logOptions *RequestLogOptions // The wrapped pipeline's request log options, with defaults applied
+}
+
+// newResponsePipeline returns p wrapped in a responsePipeline unless it already is one (or is nil). If p was created by
+// NewPipeline, the errors returned through the responsePipeline redact the sensitive query parameters and headers of
+// p's RequestLogOptions; otherwise, they redact only the default ones.
+func newResponsePipeline(p pipeline.Pipeline) pipeline.Pipeline {
+	if _, ok := p.(responsePipeline); ok || p == nil {
+		return p
+	}
+	o := RequestLogOptions{}
+	if op, ok := p.(*optionsPipeline); ok {
+		o = op.o.RequestLog
+	}
+	o = o.defaults()
+	return responsePipeline{Pipeline: p, logOptions: &o}
+}
+
+// unwrapResponsePipeline returns the pipeline that p wraps if p is a responsePipeline; otherwise it returns p.
+func unwrapResponsePipeline(p pipeline.Pipeline) pipeline.Pipeline {
+	if rp, ok := p.(responsePipeline); ok {
+		return rp.Pipeline
+	}
+	return p
+}
+
+// Do sends the request through the wrapped pipeline, placing responseFactory's policies around the method policy.
+func (p responsePipeline) Do(ctx context.Context, methodFactory pipeline.Factory, request pipeline.Request) (pipeline.Response, error) {
+	if methodFactory != nil {
+		methodFactory = responseFactory{method: methodFactory, logOptions: p.logOptions}
+	}
+	return p.Pipeline.Do(ctx, methodFactory, request)
+}
+
+// responseFactory creates the method policy for a request along with the policies responsePipeline places around it.
+type responseFactory struct {
+	method     pipeline.Factory
+	logOptions *RequestLogOptions
+}
+
+// New creates the method policy, passing it an errorBodyPolicy that forwards to next, and returns an
+// operationErrorPolicy that forwards to the method policy.
+func (f responseFactory) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return operationErrorPolicy{next: f.method.New(errorBodyPolicy{next: next}, po), logOptions: f.logOptions}
+}
+
+// operationErrorPolicy records the operation and queue of the request, and the request log options that say what
+// to redact from it, in each StorageError the responder returns.
+type operationErrorPolicy struct {
+	next       pipeline.Policy
+	logOptions *RequestLogOptions
+}
+
+// Do implements the pipeline.Policy interface.
+func (p operationErrorPolicy) Do(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+	response, err := p.next.Do(ctx, request)
+	if stgErr, ok := err.(*storageError); ok {
+		stgErr.setOperation(ctx, request.URL)
+		stgErr.logOptions = p.logOptions
+	}
+	return response, err
+}
+
+// errorBodyPolicy reads the body of each response whose status code indicates failure and replaces it with an
+// errorResponseBody holding the same bytes. The responder then reads and closes that body as usual, and newStorageError
+// keeps its bytes for StorageError's ResponseBody.
+type errorBodyPolicy struct {
+	next pipeline.Policy
+}
+
+// Do implements the pipeline.Policy interface.
+func (p errorBodyPolicy) Do(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+	response, err := p.next.Do(ctx, request)
+	if err != nil || response == nil || response.Response() == nil {
+		return response, err
+	}
+	r := response.Response()
+	if r.StatusCode < http.StatusMultipleChoices || r.Body == nil {
+		return response, err
+	}
+	defer r.Body.Close()
+	b, readErr := ioutil.ReadAll(r.Body)
+	r.Body = &errorResponseBody{reader: bytes.NewReader(b), raw: b, err: readErr}
+	return response, nil
+}
+
+// errorResponseBody is the body of an error response after errorBodyPolicy has read it. If reading the original body
+// failed, Read returns that error once the bytes read before the failure have been returned, so the responder reports
+// the failure as it would have.
+type errorResponseBody struct {
+	reader *bytes.Reader
+	raw    []byte
+	err    error
+}
+
+// Read implements the io.Reader interface.
+func (b *errorResponseBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err == io.EOF && b.err != nil {
+		err = b.err
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface; the original body has already been closed.
+func (b *errorResponseBody) Close() error {
+	return nil
+}
@@ -39,20 +39,7 @@ func (v AccountSASSignatureValues) NewSASQueryParameters(sharedKeyCredential *Sh
 
 	startTime, expiryTime := FormatTimesForSASSigning(v.StartTime, v.ExpiryTime)
 
-	stringToSign := strings.Join([]string{
-		sharedKeyCredential.AccountName(),
-		v.Permissions,
-		v.Services,
-		v.ResourceTypes,
-		startTime,
-		expiryTime,
-		v.IPRange.String(),
-		string(v.Protocol),
-		v.Version,
-		""}, // That right, the account SAS requires a terminating extra newline
-		"\n")
-
-	signature := sharedKeyCredential.ComputeHMACSHA256(stringToSign)
+	signature := sharedKeyCredential.ComputeHMACSHA256(v.stringToSign(sharedKeyCredential.AccountName(), startTime, expiryTime))
 	p := SASQueryParameters{
 		// Common SAS parameters
 		version:     v.Version,
@@ -72,6 +59,23 @@ func (v AccountSASSignatureValues) NewSASQueryParameters(sharedKeyCredential *Sh
 	return p, nil
 }
 
+// stringToSign returns the string that is signed to produce an account SAS. The start and expiry times are passed
+// already formatted since they must be signed exactly as they appear in the SAS.
+func (v AccountSASSignatureValues) stringToSign(accountName string, startTime string, expiryTime string) string {
+	return strings.Join([]string{
+		accountName,
+		v.Permissions,
+		v.Services,
+		v.ResourceTypes,
+		startTime,
+		expiryTime,
+		v.IPRange.String(),
+		string(v.Protocol),
+		v.Version,
+		""}, // That right, the account SAS requires a terminating extra newline
+		"\n")
+}
+
 // The AccountSASPermissions type simplifies creating the permissions string for an Azure Storage Account SAS.
 // Initialize an instance of this type and then call its String method to set AccountSASSignatureValues's Permissions field.
 type AccountSASPermissions struct {
@@ -109,6 +113,14 @@ func (p AccountSASPermissions) String() string {
 	return buffer.String()
 }
 
+// Validate returns an error if the AccountSASPermissions grants no permissions.
+func (p AccountSASPermissions) Validate() error {
+	if p == (AccountSASPermissions{}) {
+		return errors.New("account SAS permissions must grant at least one permission")
+	}
+	return nil
+}
+
 // Parse initializes the AccountSASPermissions's fields from a string.
 func (p *AccountSASPermissions) Parse(s string) error {
 	*p = AccountSASPermissions{} // Clear out the flags
@@ -1,6 +1,9 @@
 package azqueue
 
 import (
+	"crypto/subtle"
+	"errors"
+	"math"
 	"net"
 	"net/url"
 	"strings"
@@ -58,6 +61,11 @@ type SASQueryParameters struct {
 	resource      string      `param:"sr"`
 	permissions   string      `param:"sp"`
 	signature     string      `param:"sig"`
+
+	// The start and expiry times exactly as they appeared in parsed query parameters; Encode reproduces them so that
+	// the signature remains valid even if the time wasn't in SASTimeFormat (for example, if it had fractional seconds).
+	startTimeRaw  string
+	expiryTimeRaw string
 }
 
 func (p *SASQueryParameters) Version() string {
@@ -80,6 +88,28 @@ func (p *SASQueryParameters) ExpiryTime() time.Time {
 	return p.expiryTime
 }
 
+// IsExpired returns true if the SAS's expiry time has passed. IsExpired returns false if the SAS has no expiry time
+// (for example, because its expiry time is set by a stored access policy).
+func (p *SASQueryParameters) IsExpired() bool {
+	return p.IsAboutToExpire(0)
+}
+
+// IsAboutToExpire returns true if the SAS has expired or expires within window from now. Use it to replace a SAS
+// before it expires, or pass a small window to allow for clock differences with the service. IsAboutToExpire returns
+// false if the SAS has no expiry time.
+func (p *SASQueryParameters) IsAboutToExpire(window time.Duration) bool {
+	return !p.expiryTime.IsZero() && !time.Now().UTC().Add(window).Before(p.expiryTime)
+}
+
+// ExpiresIn returns the time remaining until the SAS expires; the result is negative if the SAS has already expired.
+// If the SAS has no expiry time, ExpiresIn returns the maximum time.Duration.
+func (p *SASQueryParameters) ExpiresIn() time.Duration {
+	if p.expiryTime.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Until(p.expiryTime)
+}
+
 func (p *SASQueryParameters) IPRange() IPRange {
 	return p.ipRange
 }
@@ -121,7 +151,8 @@ func (ipr *IPRange) String() string {
 // query parameter map's passed-in values. If deleteSASParametersFromValues is true,
 // all SAS-related query parameters are removed from the passed-in map. If
 // deleteSASParametersFromValues is false, the map passed-in map is unaltered.
-func newSASQueryParameters(values url.Values, deleteSASParametersFromValues bool) SASQueryParameters {
+// Calling Encode on the returned object reproduces the parsed parameters' values so the signature remains valid.
+func NewSASQueryParameters(values url.Values, deleteSASParametersFromValues bool) SASQueryParameters {
 	p := SASQueryParameters{}
 	for k, v := range values {
 		val := v[0]
@@ -136,9 +167,9 @@ func newSASQueryParameters(values url.Values, deleteSASParametersFromValues bool
 		case "spr":
 			p.protocol = SASProtocol(val)
 		case "st":
-			p.startTime, _ = time.Parse(SASTimeFormat, val)
+			p.startTime, p.startTimeRaw = parseSASTime(val), val
 		case "se":
-			p.expiryTime, _ = time.Parse(SASTimeFormat, val)
+			p.expiryTime, p.expiryTimeRaw = parseSASTime(val), val
 		case "sip":
 			dashIndex := strings.Index(val, "-")
 			if dashIndex == -1 {
@@ -154,7 +185,9 @@ func newSASQueryParameters(values url.Values, deleteSASParametersFromValues bool
 		case "sp":
 			p.permissions = val
 		case "sig":
-			p.signature = val
+			// A base64 signature never contains a space, so a space came from a '+' that wasn't escaped as %2B (for
+			// example, in a SAS token copied by hand) and was decoded as a space by url.ParseQuery.
+			p.signature = strings.Replace(val, " ", "+", -1)
 		default:
 			isSASKey = false // We didn't recognize the query parameter
 		}
@@ -165,6 +198,54 @@ func newSASQueryParameters(values url.Values, deleteSASParametersFromValues bool
 	return p
 }
 
+// ValidateSASSignature returns true if sas's signature was computed with credential's account key, without sending any
+// request. Both queue SASs (whose Resource is "q") and account SASs (which have Services) can be validated. A queue SAS
+// only grants access to a single queue and the queue's name is part of what is signed, so queueName must be the name
+// of the queue the SAS is presented for; queueName is ignored for an account SAS. ValidateSASSignature does not check
+// whether the SAS has expired (see IsExpired) or grants the permissions that an operation requires.
+func ValidateSASSignature(sas SASQueryParameters, credential *SharedKeyCredential, queueName string) (bool, error) {
+	if sas.signature == "" {
+		return false, errors.New("the SAS has no signature")
+	}
+	startTime, expiryTime := FormatTimesForSASSigning(sas.startTime, sas.expiryTime)
+	if sas.startTimeRaw != "" {
+		startTime = sas.startTimeRaw
+	}
+	if sas.expiryTimeRaw != "" {
+		expiryTime = sas.expiryTimeRaw
+	}
+
+	var stringToSign string
+	switch {
+	case sas.resource == "q":
+		if queueName == "" {
+			return false, errors.New("validating a queue SAS requires the queue's name")
+		}
+		stringToSign = QueueSASSignatureValues{Version: sas.version, Protocol: sas.protocol, Permissions: sas.permissions,
+			IPRange: sas.ipRange, Identifier: sas.identifier, QueueName: queueName}.stringToSign(credential.AccountName(), startTime, expiryTime)
+	case sas.services != "":
+		stringToSign = AccountSASSignatureValues{Version: sas.version, Protocol: sas.protocol, Permissions: sas.permissions,
+			IPRange: sas.ipRange, Services: sas.services, ResourceTypes: sas.resourceTypes}.stringToSign(credential.AccountName(), startTime, expiryTime)
+	default:
+		return false, errors.New("the SAS is neither a queue SAS nor an account SAS")
+	}
+	expected := credential.ComputeHMACSHA256(stringToSign)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(sas.signature)) == 1, nil
+}
+
+// sasTimeFormats are the ISO 8601 formats the service accepts for a SAS start or expiry time.
+var sasTimeFormats = []string{SASTimeFormat, time.RFC3339Nano, "2006-01-02T15:04Z", "2006-01-02"}
+
+// parseSASTime parses a SAS start or expiry time, returning the zero time if s is not in any of the accepted formats.
+func parseSASTime(s string) time.Time {
+	for _, format := range sasTimeFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 // AddToValues adds the SAS components to the specified query parameters map.
 func (p *SASQueryParameters) addToValues(v url.Values) url.Values {
 	if p.version != "" {
@@ -179,10 +260,14 @@ func (p *SASQueryParameters) addToValues(v url.Values) url.Values {
 	if p.protocol != "" {
 		v.Add("spr", string(p.protocol))
 	}
-	if !p.startTime.IsZero() {
+	if p.startTimeRaw != "" {
+		v.Add("st", p.startTimeRaw)
+	} else if !p.startTime.IsZero() {
 		v.Add("st", p.startTime.Format(SASTimeFormat))
 	}
-	if !p.expiryTime.IsZero() {
+	if p.expiryTimeRaw != "" {
+		v.Add("se", p.expiryTimeRaw)
+	} else if !p.expiryTime.IsZero() {
 		v.Add("se", p.expiryTime.Format(SASTimeFormat))
 	}
 	if len(p.ipRange.Start) > 0 {
@@ -203,7 +288,8 @@ func (p *SASQueryParameters) addToValues(v url.Values) url.Values {
 	return v
 }
 
-// Encode encodes the SAS query parameters into URL encoded form sorted by key.
+// Encode encodes the SAS query parameters into URL encoded form sorted by key. Each value is escaped exactly once;
+// for example, the base64 signature's '+', '/', and '=' characters are encoded as %2B, %2F, and %3D.
 func (p *SASQueryParameters) Encode() string {
 	v := url.Values{}
 	p.addToValues(v)
@@ -18,6 +18,24 @@ const (
 	// ServiceCodeAuthenticationFailed means the server failed to authenticate the request. Make sure the value of the Authorization header is formed correctly including the signature (403).
 	ServiceCodeAuthenticationFailed ServiceCodeType = "AuthenticationFailed"
 
+	// ServiceCodeAuthorizationFailure means the request is not authorized to perform this operation (403).
+	ServiceCodeAuthorizationFailure ServiceCodeType = "AuthorizationFailure"
+
+	// ServiceCodeAuthorizationPermissionMismatch means the request is not authorized to perform this operation using this permission (403).
+	ServiceCodeAuthorizationPermissionMismatch ServiceCodeType = "AuthorizationPermissionMismatch"
+
+	// ServiceCodeAuthorizationProtocolMismatch means the request is not authorized to perform this operation using this protocol (403).
+	ServiceCodeAuthorizationProtocolMismatch ServiceCodeType = "AuthorizationProtocolMismatch"
+
+	// ServiceCodeAuthorizationResourceTypeMismatch means the request is not authorized to perform this operation using this resource type (403).
+	ServiceCodeAuthorizationResourceTypeMismatch ServiceCodeType = "AuthorizationResourceTypeMismatch"
+
+	// ServiceCodeAuthorizationServiceMismatch means the request is not authorized to perform this operation using this service (403).
+	ServiceCodeAuthorizationServiceMismatch ServiceCodeType = "AuthorizationServiceMismatch"
+
+	// ServiceCodeAuthorizationSourceIPMismatch means the request is not authorized to perform this operation using this source IP (403).
+	ServiceCodeAuthorizationSourceIPMismatch ServiceCodeType = "AuthorizationSourceIPMismatch"
+
 	// ServiceCodeConditionHeadersNotSupported means the condition headers are not supported (400).
 	ServiceCodeConditionHeadersNotSupported ServiceCodeType = "ConditionHeadersNotSupported"
 
@@ -129,3 +147,13 @@ const (
 	// ServiceCodeUnsupportedHTTPVerb means the resource doesn't support the specified HTTP verb (405).
 	ServiceCodeUnsupportedHTTPVerb ServiceCodeType = "UnsupportedHttpVerb"
 )
+
+// IsRetryable returns true if the service's guidance for this error code is that the operation may succeed if it is
+// retried later: ServiceCodeInternalError, ServiceCodeOperationTimedOut, and ServiceCodeServerBusy.
+func (c ServiceCodeType) IsRetryable() bool {
+	switch c {
+	case ServiceCodeInternalError, ServiceCodeOperationTimedOut, ServiceCodeServerBusy:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package azqueue
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+)
+
+// servicePropertiesServiceClient is the ServiceClient used by NewServiceURL. It replaces the generated client's
+// SetProperties validation, whose constraints treat Logging's RetentionPolicy (a struct, not a pointer) as a field of
+// itself and so reject any properties that include Logging.
+type servicePropertiesServiceClient struct {
+	serviceClient
+}
+
+// SetProperties sets properties for a storage account's Queue service endpoint, including properties for Storage
+// Analytics and CORS (Cross-Origin Resource Sharing) rules.
+func (client servicePropertiesServiceClient) SetProperties(ctx context.Context, storageServiceProperties StorageServiceProperties, timeout *int32, requestID *string) (*ServiceSetPropertiesResponse, error) {
+	if err := validateServiceProperties(storageServiceProperties); err != nil {
+		return nil, err
+	}
+	if err := validate([]validation{
+		{targetValue: timeout,
+			constraints: []constraint{{target: "timeout", name: null, rule: false,
+				chain: []constraint{{target: "timeout", name: inclusiveMinimum, rule: 0, chain: nil}}}}}}); err != nil {
+		return nil, err
+	}
+	req, err := client.setPropertiesPreparer(storageServiceProperties, timeout, requestID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(ctx, responderPolicyFactory{responder: client.setPropertiesResponder}, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*ServiceSetPropertiesResponse), err
+}
+
+// validateServiceProperties checks that the Days of each retention policy that has them is at least 1.
+func validateServiceProperties(ssp StorageServiceProperties) error {
+	policies := []struct {
+		name   string
+		policy *RetentionPolicy
+	}{{name: "Logging"}, {name: "HourMetrics"}, {name: "MinuteMetrics"}}
+	if ssp.Logging != nil {
+		policies[0].policy = &ssp.Logging.RetentionPolicy
+	}
+	if ssp.HourMetrics != nil {
+		policies[1].policy = ssp.HourMetrics.RetentionPolicy
+	}
+	if ssp.MinuteMetrics != nil {
+		policies[2].policy = ssp.MinuteMetrics.RetentionPolicy
+	}
+	for _, p := range policies {
+		if p.policy != nil && p.policy.Days != nil && *p.policy.Days < 1 {
+			return fmt.Errorf("storageServiceProperties.%s.RetentionPolicy.Days must be at least 1, got %d", p.name, *p.policy.Days)
+		}
+	}
+	return nil
+}
+
+// MarshalXML implements the xml.Marshaler interface for StorageServiceProperties.
+// A nil Cors is omitted, leaving the service's CORS rules unchanged; a non-nil, empty Cors is sent as an empty Cors
+// element, which deletes them all.
+func (ssp StorageServiceProperties) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	ssp2 := storageServiceProperties{Logging: ssp.Logging, HourMetrics: ssp.HourMetrics, MinuteMetrics: ssp.MinuteMetrics}
+	if ssp.Cors != nil {
+		ssp2.Cors = &corsRules{Rules: ssp.Cors}
+	}
+	return e.EncodeElement(ssp2, start)
+}
+
+// internal type used for marshalling StorageServiceProperties so that an empty Cors can be distinguished from a nil one
+type storageServiceProperties struct {
+	Logging       *Logging   `xml:"Logging"`
+	HourMetrics   *Metrics   `xml:"HourMetrics"`
+	MinuteMetrics *Metrics   `xml:"MinuteMetrics"`
+	Cors          *corsRules `xml:"Cors"`
+}
+
+// internal type used for marshalling
+type corsRules struct {
+	Rules []CorsRule `xml:"CorsRule"`
+}
@@ -2,9 +2,12 @@ package azqueue
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -26,6 +29,32 @@ type StorageError interface {
 
 	// ServiceCode returns a service error code. Your code can use this to make error recovery decisions.
 	ServiceCode() ServiceCodeType
+
+	// RequestID returns the x-ms-request-id header value returned by the service ("" if there was none). Include it
+	// when reporting problems to Azure support so the failed request can be found in the service's logs.
+	RequestID() string
+
+	// ResponseBody returns the raw body of the error response (nil if it had none). It is meant for debugging responses
+	// whose body isn't the XML error document the service normally returns. You may examine it but must not modify it.
+	ResponseBody() []byte
+}
+
+// asStorageError returns the first StorageError in err's chain of wrapped errors (see errors.As), if any. Use it
+// instead of a type assertion since some errors (like *RetryBudgetExceededError) wrap a StorageError. Errors created by
+// pipeline.NewError expose what they wrap through Cause rather than Unwrap, so Cause is followed too.
+func asStorageError(err error) (StorageError, bool) {
+	var stgErr StorageError
+	for err != nil {
+		if errors.As(err, &stgErr) {
+			return stgErr, true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	return nil, false
 }
 
 // storageError is the internal struct that implements the public StorageError interface.
@@ -33,11 +62,20 @@ type storageError struct {
 	responseError
 	serviceCode ServiceCodeType
 	details     map[string]string
+	body        []byte
+
+	operation       string // The name of the operation that failed (see OperationName)
+	operationFields string // The operation and queue that failed, for example "Operation=Enqueue, Queue=orders"
+
+	logOptions *RequestLogOptions // Says which query parameters and headers Error redacts (nil means the defaults)
 }
 
-// newStorageError creates an error object that implements the error interface.
+// newStorageError creates an error object that implements the error interface. The service code comes from the
+// x-ms-error-code header, which the service sets even when the response has no body (such as for HEAD requests and
+// some 403 and 413 responses); if the header is missing, UnmarshalXML takes the code from the body's Code element.
+// The body's bytes are kept if errorBodyPolicy read them before the responder did.
 func newStorageError(cause error, response *http.Response, description string) error {
-	return &storageError{
+	e := &storageError{
 		responseError: responseError{
 			ErrorNode:   pipeline.ErrorNode{}.Initialize(cause, 3),
 			response:    response,
@@ -45,6 +83,16 @@ func newStorageError(cause error, response *http.Response, description string) e
 		},
 		serviceCode: ServiceCodeType(response.Header.Get("x-ms-error-code")),
 	}
+	if body, ok := response.Body.(*errorResponseBody); ok && len(body.raw) > 0 {
+		e.body = body.raw
+	}
+	return e
+}
+
+// setOperation records the name of the operation in ctx (see OperationName) and the queue that u refers to so that
+// Error can include them.
+func (e *storageError) setOperation(ctx context.Context, u *url.URL) {
+	e.operation, e.operationFields = OperationName(ctx), operationFields(ctx, u)
 }
 
 // ServiceCode returns service-error information. The caller may examine these values but should not modify any of them.
@@ -52,10 +100,30 @@ func (e *storageError) ServiceCode() ServiceCodeType {
 	return e.serviceCode
 }
 
+// RequestID returns the x-ms-request-id header value returned by the service.
+func (e *storageError) RequestID() string {
+	return e.response.Header.Get("x-ms-request-id")
+}
+
+// ResponseBody returns the raw body of the error response.
+func (e *storageError) ResponseBody() []byte {
+	return e.body
+}
+
 // Error implements the error interface's Error method to return a string representation of the error.
 func (e *storageError) Error() string {
 	b := &bytes.Buffer{}
-	fmt.Fprintf(b, "===== RESPONSE ERROR (ServiceCode=%s) =====\n", e.serviceCode)
+	if requestID := e.RequestID(); requestID != "" {
+		fmt.Fprintf(b, "===== RESPONSE ERROR (ServiceCode=%s, RequestID=%s) =====\n", e.serviceCode, requestID)
+	} else {
+		fmt.Fprintf(b, "===== RESPONSE ERROR (ServiceCode=%s) =====\n", e.serviceCode)
+	}
+	if e.operationFields != "" {
+		fmt.Fprintf(b, "%s\n", e.operationFields)
+	}
+	if hint := authorizationHint(e.serviceCode, e.operation); hint != "" {
+		fmt.Fprintf(b, "%s\n", hint)
+	}
 	fmt.Fprintf(b, "Description=%s, Details: ", e.description)
 	if len(e.details) == 0 {
 		b.WriteString("(none)\n")
@@ -71,8 +139,13 @@ func (e *storageError) Error() string {
 			fmt.Fprintf(b, "   %s: %+v\n", k, e.details[k])
 		}
 	}
+	o := e.logOptions
+	if o == nil {
+		defaults := RequestLogOptions{}.defaults()
+		o = &defaults
+	}
 	req := pipeline.Request{Request: e.response.Request}.Copy() // Make a copy of the response's request
-	pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(req), e.response, nil)
+	pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(req, *o), e.response, nil)
 	return e.ErrorNode.Error(b.String())
 }
 
@@ -99,6 +172,11 @@ func (e *storageError) UnmarshalXML(d *xml.Decoder, start xml.StartElement) (err
 			switch tokName {
 			case "Message":
 				e.description = string(tt)
+			case "Code":
+				if e.serviceCode == "" {
+					e.serviceCode = ServiceCodeType(tt)
+				}
+				fallthrough // The code is also kept in the details
 			default:
 				if e.details == nil {
 					e.details = map[string]string{}
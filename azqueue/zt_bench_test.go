@@ -0,0 +1,93 @@
+package azqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+)
+
+// To run the benchmarks: go test -run XXX -bench . ./azqueue
+// The benchmarks require the ACCOUNT_NAME and ACCOUNT_KEY environment variables; they are skipped otherwise.
+
+var benchmarkMessageText = strings.Repeat("x", 1024) // 1KB message
+
+// createBenchmarkQueue creates a new queue for a benchmark and registers its deletion when the benchmark completes.
+// The testing package runs a benchmark function several times (once per b.N), so each call needs a unique queue name;
+// reusing the name of a queue that is still being deleted would fail with ServiceCodeQueueBeingDeleted.
+func createBenchmarkQueue(b *testing.B) azqueue.MessagesURL {
+	serviceURL, err := getGenericQueueServiceURL()
+	if err != nil {
+		b.Skip(err.Error())
+	}
+	unique := strconv.FormatInt(time.Now().UnixNano(), 36)
+	queueURL := serviceURL.NewQueueURL(strings.ToLower(queuePrefix+"bench"+strings.Replace(b.Name(), "/", "", -1)) + unique)
+	if _, err := queueURL.Create(ctx, nil); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { queueURL.Delete(ctx) })
+	return queueURL.NewMessagesURL()
+}
+
+// fillBenchmarkQueue enqueues n messages so that the Dequeue benchmarks have messages to dequeue.
+func fillBenchmarkQueue(b *testing.B, messagesURL azqueue.MessagesURL, n int) {
+	for i := 0; i < n; i++ {
+		if _, err := messagesURL.Enqueue(ctx, benchmarkMessageText, 0, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnqueue(b *testing.B) {
+	messagesURL := createBenchmarkQueue(b)
+	b.SetBytes(int64(len(benchmarkMessageText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := messagesURL.Enqueue(ctx, benchmarkMessageText, 0, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnqueueParallel(b *testing.B) {
+	messagesURL := createBenchmarkQueue(b)
+	b.SetBytes(int64(len(benchmarkMessageText)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := messagesURL.Enqueue(ctx, benchmarkMessageText, 0, 0); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkDequeue(b *testing.B) {
+	messagesURL := createBenchmarkQueue(b)
+	fillBenchmarkQueue(b, messagesURL, b.N)
+	b.SetBytes(int64(len(benchmarkMessageText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := messagesURL.Dequeue(ctx, 1, time.Minute); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDequeueParallel(b *testing.B) {
+	messagesURL := createBenchmarkQueue(b)
+	fillBenchmarkQueue(b, messagesURL, b.N)
+	b.SetBytes(int64(len(benchmarkMessageText)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := messagesURL.Dequeue(ctx, 1, time.Minute); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
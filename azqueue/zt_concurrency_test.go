@@ -0,0 +1,146 @@
+package azqueue_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+// These tests back the concurrency guarantees documented on the URL types. Run them with -race.
+
+const concurrencyTestGoroutines = 50
+
+// hammer calls f from concurrencyTestGoroutines goroutines at once, passing each its index.
+func hammer(f func(g int)) {
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for g := 0; g < concurrencyTestGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			f(g)
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func (s *queueSuite) TestConcurrentMessagesURL(c *chk.C) {
+	messagesURL := mock.NewInMemoryQueue().NewMessageIDURL("id").MessagesURL()
+	other := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusOK, nil), nil
+	})
+
+	hammer(func(g int) {
+		for i := 0; i < 10; i++ {
+			_, err := messagesURL.Enqueue(ctx, fmt.Sprintf("%d-%d", g, i), 0, 0)
+			c.Check(err, chk.IsNil)
+
+			dequeue, err := messagesURL.Dequeue(ctx, 2, 30*time.Second)
+			c.Check(err, chk.IsNil)
+			for m := int32(0); m < dequeue.NumMessages(); m++ {
+				msg := dequeue.Message(m)
+				_ = msg.String()
+				_, err = messagesURL.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt)
+				c.Check(err, chk.IsNil)
+			}
+			_, err = messagesURL.Peek(ctx, 5)
+			c.Check(err, chk.IsNil)
+			_, err = messagesURL.QueueURL().GetProperties(ctx)
+			c.Check(err, chk.IsNil)
+
+			// Deriving URLs, and changing the URLs they return, doesn't affect the shared MessagesURL
+			u := messagesURL.URL()
+			u.RawQuery = "changed=true"
+			_ = messagesURL.WithPipeline(other).NewMessageIDURL("x").String()
+			withDefaults, err := messagesURL.WithDefaults(azqueue.EnqueueDefaults{VisibilityTimeout: time.Second})
+			c.Check(err, chk.IsNil)
+			_ = withDefaults.QueueURL().ServiceURL().String()
+		}
+	})
+	c.Assert(strings.Contains(messagesURL.String(), "changed"), chk.Equals, false)
+	peek, err := messagesURL.Peek(ctx, 1)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(0)) // Every enqueued message was dequeued and deleted exactly once
+}
+
+func (s *queueSuite) TestConcurrentServiceAndQueueURLs(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		resp := newMockedResponse(http.StatusOK, http.Header{"X-Ms-Meta-Owner": {"team"}})
+		switch {
+		case request.URL.Query().Get("comp") == "list":
+			resp.Body = ioutil.NopCloser(strings.NewReader(`<EnumerationResults><Queues><Queue><Name>q</Name></Queue></Queues><NextMarker /></EnumerationResults>`))
+		case request.URL.Query().Get("comp") == "acl":
+			resp.Body = ioutil.NopCloser(strings.NewReader(`<SignedIdentifiers><SignedIdentifier><Id>r</Id><AccessPolicy><Permission>r</Permission></AccessPolicy></SignedIdentifier></SignedIdentifiers>`))
+		case request.Method == http.MethodPut && request.URL.Query().Get("comp") == "":
+			resp.StatusCode = http.StatusCreated
+		case request.Method == http.MethodPut || request.Method == http.MethodDelete:
+			resp.StatusCode = http.StatusNoContent
+		}
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net?sv=2018-03-28&sig=secret")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+	queueURL := serviceURL.NewQueueURL("q")
+	metadata := azqueue.Metadata{"owner": "team"} // Shared read-only by all of the goroutines
+
+	hammer(func(g int) {
+		for i := 0; i < 10; i++ {
+			segment, err := serviceURL.ListQueuesSegment(ctx, azqueue.Marker{}, azqueue.ListQueuesSegmentOptions{})
+			c.Check(err, chk.IsNil)
+			c.Check(segment.QueueItems, chk.HasLen, 1)
+
+			_, err = queueURL.Create(ctx, metadata)
+			c.Check(err, chk.IsNil)
+			props, err := queueURL.GetProperties(ctx)
+			c.Check(err, chk.IsNil)
+			md := props.NewMetadata()
+			md["changed"] = "true" // Each call returns a new map
+			_, err = queueURL.SetMetadata(ctx, metadata)
+			c.Check(err, chk.IsNil)
+			_, err = queueURL.GetAccessPolicy(ctx)
+			c.Check(err, chk.IsNil)
+			_, err = queueURL.Delete(ctx)
+			c.Check(err, chk.IsNil)
+
+			derived := serviceURL.NewQueueURL(fmt.Sprintf("q%d", g)).WithPipeline(p)
+			c.Check(derived.QueueName(), chk.Equals, fmt.Sprintf("q%d", g))
+			c.Check(derived.ServiceURL().String(), chk.Equals, serviceURL.String())
+		}
+	})
+	c.Assert(queueURL.QueueName(), chk.Equals, "q")
+	c.Assert(metadata, chk.DeepEquals, azqueue.Metadata{"owner": "team"})
+}
+
+func (s *queueSuite) TestConcurrentResponseReaders(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedDequeueResponse("a", "b", "c"), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/q/messages")
+	dequeue, err := azqueue.NewMessagesURL(*u, p).Dequeue(ctx, 3, time.Minute)
+	c.Assert(err, chk.IsNil)
+
+	// A response may be read, but not modified, by multiple goroutines
+	hammer(func(g int) {
+		c.Check(dequeue.NumMessages(), chk.Equals, int32(3))
+		for m := int32(0); m < dequeue.NumMessages(); m++ {
+			msg := dequeue.Message(m)
+			_ = msg.String()
+			_ = msg.VisibleIn(time.Now())
+			_, err := msg.MarshalJSON()
+			c.Check(err, chk.IsNil)
+		}
+		_ = dequeue.Date()
+		_ = dequeue.RequestID()
+	})
+}
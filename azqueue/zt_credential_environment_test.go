@@ -0,0 +1,118 @@
+package azqueue_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+// setEnv sets the specified environment variables (unsetting those with an empty value) and returns a function that restores them.
+func setEnv(vars map[string]string) func() {
+	saved := map[string]*string{}
+	for k, v := range vars {
+		if old, ok := os.LookupEnv(k); ok {
+			saved[k] = &old
+		} else {
+			saved[k] = nil
+		}
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+	return func() {
+		for k, v := range saved {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+// newEnvironmentCredentialTestPipeline returns a pipeline that uses credential and records each request's Authorization header.
+func newEnvironmentCredentialTestPipeline(credential azqueue.Credential, authorizations *[]string) azqueue.QueueURL {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		*authorizations = append(*authorizations, request.Header.Get("Authorization"))
+		return newMockedResponse(http.StatusOK, nil), nil
+	}, credential)
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	return azqueue.NewQueueURL(*u, p)
+}
+
+func (s *queueSuite) TestEnvironmentCredentialClientSecret(c *chk.C) {
+	tokenRequests, expiresIn := 0, 3600
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, chk.Equals, "/tenant/oauth2/v2.0/token")
+		c.Check(r.FormValue("client_id"), chk.Equals, "client")
+		c.Check(r.FormValue("client_secret"), chk.Equals, "secret")
+		c.Check(r.FormValue("scope"), chk.Equals, "https://storage.azure.com/.default")
+		tokenRequests++
+		fmt.Fprintf(w, `{"token_type":"Bearer","access_token":"token%d","expires_in":%d}`, tokenRequests, expiresIn)
+	}))
+	defer server.Close()
+	defer setEnv(map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client",
+		"AZURE_CLIENT_SECRET": "secret", "AZURE_FEDERATED_TOKEN_FILE": ""})()
+
+	credential, err := azqueue.NewEnvironmentCredential(azqueue.EnvironmentCredentialOptions{AuthorityHost: server.URL})
+	c.Assert(err, chk.IsNil)
+	authorizations := []string{}
+	queueURL := newEnvironmentCredentialTestPipeline(credential, &authorizations)
+
+	// The token is cached until 5 minutes before it expires
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(tokenRequests, chk.Equals, 1)
+	c.Assert(authorizations, chk.DeepEquals, []string{"Bearer token1", "Bearer token1"})
+
+	// A token expiring within 5 minutes is replaced on the next request
+	credential, _ = azqueue.NewEnvironmentCredential(azqueue.EnvironmentCredentialOptions{AuthorityHost: server.URL})
+	queueURL = newEnvironmentCredentialTestPipeline(credential, &authorizations)
+	expiresIn = 240
+	queueURL.GetProperties(ctx)
+	queueURL.GetProperties(ctx)
+	c.Assert(tokenRequests, chk.Equals, 3)
+	c.Assert(authorizations[2:], chk.DeepEquals, []string{"Bearer token2", "Bearer token3"})
+}
+
+func (s *queueSuite) TestEnvironmentCredentialFederatedToken(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.FormValue("client_assertion_type"), chk.Equals, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		c.Check(r.FormValue("client_assertion"), chk.Equals, "oidc-token")
+		fmt.Fprint(w, `{"access_token":"aadtoken","expires_in":"3600"}`)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "azqueue")
+	c.Assert(err, chk.IsNil)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	c.Assert(ioutil.WriteFile(tokenFile, []byte("oidc-token\n"), 0600), chk.IsNil)
+	defer setEnv(map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client",
+		"AZURE_CLIENT_SECRET": "", "AZURE_FEDERATED_TOKEN_FILE": tokenFile})()
+
+	credential, err := azqueue.NewEnvironmentCredential(azqueue.EnvironmentCredentialOptions{AuthorityHost: server.URL})
+	c.Assert(err, chk.IsNil)
+	authorizations := []string{}
+	_, err = newEnvironmentCredentialTestPipeline(credential, &authorizations).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(authorizations, chk.DeepEquals, []string{"Bearer aadtoken"})
+}
+
+func (s *queueSuite) TestEnvironmentCredentialMissingVariables(c *chk.C) {
+	defer setEnv(map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client",
+		"AZURE_CLIENT_SECRET": "", "AZURE_FEDERATED_TOKEN_FILE": ""})()
+	_, err := azqueue.NewEnvironmentCredential(azqueue.EnvironmentCredentialOptions{})
+	c.Assert(err, chk.NotNil)
+}
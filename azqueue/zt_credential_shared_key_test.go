@@ -0,0 +1,31 @@
+package azqueue_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+	"strings"
+)
+
+func (s *queueSuite) TestNewSharedKeyCredentialKeyLength(c *chk.C) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	credential, err := azqueue.NewSharedKeyCredential("account", base64.StdEncoding.EncodeToString(key))
+	c.Assert(err, chk.IsNil)
+	c.Assert(credential.AccountName(), chk.Equals, "account")
+
+	_, err = azqueue.NewSharedKeyCredential("account", base64.StdEncoding.EncodeToString(key[:32]))
+	c.Assert(err, chk.NotNil)
+	c.Assert(strings.Contains(err.Error(), "32 bytes"), chk.Equals, true)
+
+	_, err = azqueue.NewSharedKeyCredential("account", hex.EncodeToString(key))
+	c.Assert(err, chk.NotNil)
+	c.Assert(strings.Contains(err.Error(), "hex-encoded"), chk.Equals, true)
+
+	_, err = azqueue.NewSharedKeyCredential("account", "not base64!")
+	c.Assert(err, chk.NotNil)
+}
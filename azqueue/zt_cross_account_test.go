@@ -0,0 +1,96 @@
+package azqueue_test
+
+import (
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+	"time"
+)
+
+// getPrimaryAndSecondaryServiceURLs returns ServiceURLs for two different storage accounts, skipping the test if
+// credentials for either account are not specified.
+func getPrimaryAndSecondaryServiceURLs(c *chk.C) (primary, secondary azqueue.ServiceURL) {
+	primary, err := getGenericQueueServiceURL()
+	if err != nil {
+		c.Skip(err.Error())
+	}
+	secondary, err = getSecondaryQueueServiceURL()
+	if err != nil {
+		c.Skip(err.Error())
+	}
+	return primary, secondary
+}
+
+func (s *queueSuite) TestCrossAccountCopyQueue(c *chk.C) {
+	primary, secondary := getPrimaryAndSecondaryServiceURLs(c)
+	srcQueueURL, _ := createNewQueue(c, primary)
+	defer deleteQueue(c, srcQueueURL)
+	dstQueueURL, _ := createNewQueue(c, secondary)
+	defer deleteQueue(c, dstQueueURL)
+
+	src, dst := srcQueueURL.NewMessagesURL(), dstQueueURL.NewMessagesURL()
+	for _, text := range []string{"one", "two", "three"} {
+		_, err := src.Enqueue(ctx, text, 0, 0)
+		c.Assert(err, chk.IsNil)
+	}
+
+	stats, err := azqueue.CopyQueue(ctx, src, dst, azqueue.CopyOptions{DeleteAfterCopy: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.Copied, chk.Equals, int64(3))
+	c.Assert(stats.Failed, chk.Equals, int64(0))
+
+	props, err := dstQueueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.ApproximateMessagesCount(), chk.Equals, int32(3))
+	props, err = srcQueueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.ApproximateMessagesCount(), chk.Equals, int32(0))
+}
+
+func (s *queueSuite) TestCrossAccountSAS(c *chk.C) {
+	primary, secondary := getPrimaryAndSecondaryServiceURLs(c)
+	queueURL, queueName := createNewQueue(c, secondary)
+	defer deleteQueue(c, queueURL)
+
+	// A SAS signed with the secondary account's key grants access to the secondary account's queue
+	credential, err := getGenericCredential("SECONDARY_")
+	c.Assert(err, chk.IsNil)
+	sasQueryParams := azqueue.QueueSASSignatureValues{
+		Protocol:    azqueue.SASProtocolHTTPS,
+		ExpiryTime:  time.Now().UTC().Add(time.Hour),
+		Permissions: azqueue.QueueSASPermissions{Add: true, Read: true}.String(),
+		QueueName:   queueName,
+	}.NewSASQueryParameters(credential)
+	u := queueURL.URL()
+	u.RawQuery = sasQueryParams.Encode()
+	sasQueueURL := azqueue.NewQueueURL(u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{}))
+
+	_, err = sasQueueURL.NewMessagesURL().Enqueue(ctx, "via sas", 0, 0)
+	c.Assert(err, chk.IsNil)
+	peek, err := sasQueueURL.NewMessagesURL().Peek(ctx, 1)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(1))
+	c.Assert(peek.Message(0).Text, chk.Equals, "via sas")
+
+	// The same SAS is rejected by a queue with the same name in the primary account
+	u = primary.NewQueueURL(queueName).URL()
+	u.RawQuery = sasQueryParams.Encode()
+	wrongAccountURL := azqueue.NewQueueURL(u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{}))
+	_, err = wrongAccountURL.NewMessagesURL().Peek(ctx, 1)
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeAuthenticationFailed)
+}
+
+func (s *queueSuite) TestSecondaryEndpointRead(c *chk.C) {
+	_, secondary := getPrimaryAndSecondaryServiceURLs(c)
+
+	// Service statistics are only available from the read-only secondary endpoint of an RA-GRS account
+	secondaryEndpoint, err := secondary.SecondaryURL()
+	c.Assert(err, chk.IsNil)
+
+	stats, err := secondaryEndpoint.GetStatistics(ctx)
+	if _, ok := err.(azqueue.StorageError); err != nil && !ok {
+		c.Skip("secondary account has no read-access secondary endpoint: " + err.Error())
+	}
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.GeoReplication, chk.NotNil)
+}
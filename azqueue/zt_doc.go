@@ -34,6 +34,10 @@ All of XxxURL's methods that make HTTP requests return rich error handling infor
 transient failures, timeout failures, service failures, etc. See the StorageError interface for more information and an
 example of how to do deal with errors.
 
+On success, the methods return typed responses exposing the service's most relevant response headers as methods.
+Every typed response also has a Response method returning the raw *http.Response so you can examine any header
+(for example, x-ms-request-id, x-ms-version, or Date). The response's body has already been read and closed.
+
 URL and Shared Access Signature Manipulation
 
 The library includes a QueueURLParts type for deconstructing and reconstructing URLs. And you can use the following types
@@ -50,6 +54,8 @@ When creating a request pipeline, you must specify one of this package's credent
  - Call the NewAnonymousCredential function for requests that contain a Shared Access Signature (SAS).
  - Call the NewSharedKeyCredential function (with an account name & key) to access any account resources. You must also use this
    to generate Shared Access Signatures.
+ - Call the NewEnvironmentCredential function to access resources with Azure AD tokens for the service principal
+   described by the AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET or AZURE_FEDERATED_TOKEN_FILE environment variables.
 
 HTTP Request Policy Factories
 
@@ -71,6 +77,11 @@ package:
  - NewRequestLogPolicyFactory      Enables rich logging support for HTTP requests/responses & failures.
  - NewTelemetryPolicyFactory       Enables simple modification of the HTTP request's User-Agent header so each request reports the SDK version & language/runtime making the requests.
  - NewUniqueRequestIDPolicyFactory Adds a x-ms-client-request-id header with a unique UUID value to an HTTP request to help with diagnosing failures.
+ - NewInflightCounterPolicyFactory Counts the HTTP requests currently in flight so applications can apply back-pressure.
+ - NewLatencyHistogramPolicyFactory Records request latencies per operation in a histogram for percentile reporting.
+ - NewServiceVersionPolicyFactory  Overrides the x-ms-version header sent with each request.
+ - NewPanicRecoveryPolicyFactory   Returns a panic in a later policy as a *PanicError instead of crashing the process.
+ - NewRateLimitPolicyFactory       Paces requests with a token bucket (RateLimiter) that pipelines can share.
 
 Also, note that all the NewXxxCredential functions return request policy factory objects which get injected into the pipeline.
 */
@@ -0,0 +1,14 @@
+package azqueue_test
+
+import (
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestNewAzureStorageEmulatorServiceURL(c *chk.C) {
+	serviceURL, err := azqueue.NewAzureStorageEmulatorServiceURL(azqueue.PipelineOptions{})
+	c.Assert(err, chk.IsNil)
+	u := serviceURL.URL()
+	c.Assert(u.String(), chk.Equals, "http://127.0.0.1:10001/devstoreaccount1")
+	c.Assert(serviceURL.NewQueueURL("myqueue").String(), chk.Equals, "http://127.0.0.1:10001/devstoreaccount1/myqueue")
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-queue-go/azqueue"
-	"net/http"
 	"strconv"
 )
 
@@ -271,6 +270,9 @@ func ExampleStorageError() {
 	if err != nil { // An error occurred
 		if serr, ok := err.(azqueue.StorageError); ok { // This error is a Service-specific error
 			// StorageError also implements net.Error so you could call its Timeout/Temporary methods if you want.
+			// RequestID returns the service's x-ms-request-id for this failed request (it's also part of the
+			// error's string); log it so the request can be found in the service's logs when contacting support.
+			log.Printf("request %s failed: %s", serr.RequestID(), serr.ServiceCode())
 			switch serr.ServiceCode() { // Compare serviceCode to various ServiceCodeXxx constants
 			case azqueue.ServiceCodeQueueAlreadyExists:
 				// You can also look at the http.Response object that failed.
@@ -532,18 +534,10 @@ func ExampleMessagesURL_Clear() {
 	}
 	fmt.Println("Msg count=" + strconv.Itoa(int(props.ApproximateMessagesCount())))
 
-	for {
-		clear, err := messagesURL.Clear(ctx)
-		if err == nil {
-			break // Don't loop if Clear successful
-		} else {
-			if clear.StatusCode() == http.StatusInternalServerError {
-				if stgErr, ok := err.(azqueue.StorageError); ok && stgErr.Response().StatusCode == http.StatusInternalServerError && stgErr.ServiceCode() == azqueue.ServiceCodeOperationTimedOut {
-					continue // Service timed out while deleting messages; call Clear again until it return success
-				}
-			}
-			log.Fatal(err)
-		}
+	// ClearAll calls Clear again if the service times out after deleting only some of the messages
+	_, err = messagesURL.ClearAll(ctx, time.Minute*5)
+	if err != nil {
+		log.Fatal(err)
 	}
 	props, err = queueURL.GetProperties(ctx)
 	if err != nil {
@@ -0,0 +1,47 @@
+package azqueue_test
+
+import (
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"net/url"
+	"testing"
+)
+
+// The fuzz targets below use the standard Go fuzzing engine (Go 1.18+). Run one with, for example:
+//     go test -run '^$' -fuzz '^FuzzNewQueueURLParts$' ./azqueue
+// Without -fuzz, "go test" runs each target once against its seed corpus.
+
+const fuzzSeedSAS = "sv=2015-02-21&sr=q&st=2111-01-09T01:42:34.936Z&se=2222-03-09T01:42:34.936Z&sp=rup&sip=168.1.5.60-168.1.5.70&" +
+	"spr=https,http&si=myIdentifier&ss=q&srt=o&sig=92836758923659283652983562=="
+
+func FuzzNewQueueURLParts(f *testing.F) {
+	f.Add("https://myaccount.queue.core.windows.net/aqueue/messages/30dd879c-ee2f-11db-8314-0800200c9a66?" + fuzzSeedSAS)
+	f.Add("https://myaccount.queue.core.windows.net/aqueue/messages")
+	f.Add("https://myaccount.queue.core.windows.net/")
+	f.Add("https://fakeaccount.queue.core.windows.net/fakequeue")
+	f.Add("http://127.0.0.1:10001/devstoreaccount1/queue?comp=metadata")
+	f.Fuzz(func(t *testing.T, rawURL string) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return // Only URLs that the url package accepts can reach NewQueueURLParts
+		}
+		parts := azqueue.NewQueueURLParts(*u)
+		// Producing a URL from the parts may fail for inconsistent parts but it must never panic
+		_, _ = parts.URL()
+	})
+}
+
+func FuzzParseSASQueryParameters(f *testing.F) {
+	f.Add(fuzzSeedSAS)
+	f.Add("sip=168.1.5.60")
+	f.Add("sip=168.1.5.60-")
+	f.Add("sip=-")
+	f.Add("st=not-a-time&se=2222-03-09T01:42:34.936Z")
+	f.Add("SV=2018-03-28&Sig=abc&other=value")
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		u := url.URL{Scheme: "https", Host: "myaccount.queue.core.windows.net", Path: "/aqueue", RawQuery: rawQuery}
+		sas := azqueue.NewQueueURLParts(u).SAS
+		ipRange := sas.IPRange()
+		_ = ipRange.String()
+		_ = sas.Encode()
+	})
+}
@@ -0,0 +1,61 @@
+package azqueue_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestHealthCheck(c *chk.C) {
+	var respond func() (*http.Response, error)
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		c.Assert(request.URL.Query().Get("comp"), chk.Equals, "list")
+		c.Assert(request.URL.Query().Get("maxresults"), chk.Equals, "1")
+		return respond()
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/?sv=2018-03-28&sig=secret")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	// An account without queues is healthy
+	respond = func() (*http.Response, error) {
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader("<EnumerationResults><Queues /><NextMarker /></EnumerationResults>"))
+		return resp, nil
+	}
+	c.Assert(azqueue.HealthCheck(ctx, serviceURL), chk.IsNil)
+
+	respond = func() (*http.Response, error) {
+		return newMockedResponse(http.StatusForbidden, http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthenticationFailed)}}), nil
+	}
+	err := azqueue.HealthCheck(ctx, serviceURL)
+	hcErr, ok := err.(*azqueue.HealthCheckError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(hcErr.Endpoint, chk.Equals, "https://fakeaccount.queue.core.windows.net/")
+	c.Assert(hcErr.Type(), chk.Equals, "ServiceCode=AuthenticationFailed")
+	c.Assert(strings.Contains(hcErr.Error(), "secret"), chk.Equals, false)
+	c.Assert(errors.Unwrap(err).(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeAuthenticationFailed)
+
+	respond = func() (*http.Response, error) { return nil, errors.New("connection refused") }
+	err = azqueue.HealthCheck(ctx, serviceURL)
+	c.Assert(err.(*azqueue.HealthCheckError).Type(), chk.Equals, "*errors.errorString")
+
+	// Network errors are recognized even when wrapped
+	respond = func() (*http.Response, error) {
+		return nil, fmt.Errorf("sending request: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	}
+	err = azqueue.HealthCheck(ctx, serviceURL)
+	c.Assert(err.(*azqueue.HealthCheckError).Type(), chk.Equals, "network")
+	respond = func() (*http.Response, error) {
+		return nil, fmt.Errorf("sending request: %w", &net.DNSError{Err: "i/o timeout", Name: "fakeaccount", IsTimeout: true})
+	}
+	err = azqueue.HealthCheck(ctx, serviceURL)
+	c.Assert(err.(*azqueue.HealthCheckError).Type(), chk.Equals, "timeout")
+}
@@ -0,0 +1,691 @@
+package azqueue_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestPollMessageCountSkipUnchanged(c *chk.C) {
+	counts := []int{5, 5, 5, 7, 7, 2}
+	polls := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		count := counts[len(counts)-1]
+		if polls < len(counts) {
+			count = counts[polls]
+		}
+		polls++
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": []string{strconv.Itoa(count)}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	samples, err := queueURL.PollMessageCount(ctx, time.Millisecond, azqueue.PollMessageCountOptions{SkipUnchanged: true})
+	c.Assert(err, chk.IsNil)
+	for _, expected := range []int64{5, 7, 2} {
+		sample := <-samples
+		c.Assert(sample.Err, chk.IsNil)
+		c.Assert(sample.Count, chk.Equals, expected)
+	}
+	cancel()
+	for range samples {
+		// Drain until the poller closes the channel
+	}
+}
+
+func (s *queueSuite) TestWaitUntilEmpty(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	queueURL := q.NewMessageIDURL("id").MessagesURL().QueueURL()
+	_, err := q.Enqueue(ctx, "msg", 0, 0)
+	c.Assert(err, chk.IsNil)
+
+	// The wait ends with the last observed count if the queue doesn't become empty
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = queueURL.WaitUntilEmpty(timeoutCtx, time.Millisecond)
+	timeoutErr, ok := err.(*azqueue.WaitUntilEmptyTimeoutError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(timeoutErr.LastCount, chk.Equals, int64(1))
+	c.Assert(errors.Is(err, context.DeadlineExceeded), chk.Equals, true)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		dequeue, _ := q.Dequeue(ctx, 1, time.Minute)
+		msg := dequeue.Message(0)
+		q.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt)
+	}()
+	c.Assert(queueURL.WaitUntilEmpty(ctx, time.Millisecond), chk.IsNil)
+}
+
+func (s *queueSuite) TestWaitUntilEmptyStrict(c *chk.C) {
+	var peeks, polls int32
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.URL.Query().Get("peekonly") == "true" {
+			if atomic.AddInt32(&peeks, 1) == 1 {
+				return newMockedDequeueResponse("msg"), nil // The count lags behind the queue's contents
+			}
+			return newMockedDequeueResponse(), nil
+		}
+		switch atomic.AddInt32(&polls, 1) {
+		case 1:
+			return newMockedResponse(http.StatusServiceUnavailable, nil), nil // Transient errors are retried
+		case 2:
+			return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": {"3"}}), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": {"0"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	c.Assert(queueURL.WaitUntilEmptyStrict(ctx, time.Millisecond), chk.IsNil)
+	c.Assert(atomic.LoadInt32(&peeks), chk.Equals, int32(3)) // One peek found a message; then two in a row found none
+
+	// Permanent errors are returned immediately
+	notFound := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusNotFound, http.Header{"X-Ms-Error-Code": {string(azqueue.ServiceCodeQueueNotFound)}}), nil
+	})
+	err := azqueue.NewQueueURL(*u, notFound).WaitUntilEmpty(ctx, time.Millisecond)
+	stgErr, ok := err.(azqueue.StorageError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(stgErr.ServiceCode(), chk.Equals, azqueue.ServiceCodeQueueNotFound)
+}
+
+func (s *queueSuite) TestDequeueWithBackoff(c *chk.C) {
+	polls := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		polls++
+		if polls <= 3 {
+			return newMockedDequeueResponse(), nil // The queue is empty for the first 3 polls
+		}
+		return newMockedDequeueResponse("msg"), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	b := &azqueue.EmptyQueueBackoff{InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Multiplier: 2}
+	dequeue, err := messagesURL.DequeueWithBackoff(ctx, 1, time.Second, b)
+	c.Assert(err, chk.IsNil)
+	c.Assert(dequeue.NumMessages(), chk.Equals, int32(1))
+	c.Assert(dequeue.Message(0).Text, chk.Equals, "msg")
+	c.Assert(b.CurrentDelay(), chk.Equals, 5*time.Millisecond) // 1ms -> 2ms -> 4ms -> 8ms capped at 5ms
+
+	b.ResetOnMessage = true
+	_, err = messagesURL.DequeueWithBackoff(ctx, 1, time.Second, b)
+	c.Assert(err, chk.IsNil)
+	c.Assert(b.CurrentDelay(), chk.Equals, time.Millisecond)
+
+	// A nil backoff uses the defaults
+	dequeue, err = messagesURL.DequeueWithBackoff(ctx, 1, time.Second, nil)
+	c.Assert(err, chk.IsNil)
+	c.Assert(dequeue.NumMessages(), chk.Equals, int32(1))
+}
+
+func (s *queueSuite) TestReceive(c *chk.C) {
+	var polls int32
+	available := int32(3) // The queue is empty for the first 3 polls
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if atomic.AddInt32(&polls, 1) <= atomic.LoadInt32(&available) {
+			return newMockedDequeueResponse(), nil
+		}
+		return newMockedDequeueResponse("msg1", "msg2"), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	b := &azqueue.EmptyQueueBackoff{InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, ResetOnMessage: true}
+	resp, err := messagesURL.ReceiveWithBackoff(ctx, 2, time.Second, time.Minute, b)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Polls, chk.Equals, 4)
+	c.Assert(len(resp.Messages), chk.Equals, 2)
+	c.Assert(resp.Messages[1].Text, chk.Equals, "msg2")
+	c.Assert(b.CurrentDelay(), chk.Equals, time.Millisecond)
+
+	// If no message becomes available within maxWait, an empty response is returned after a final poll at maxWait
+	atomic.StoreInt32(&polls, 0)
+	atomic.StoreInt32(&available, 1000)
+	start := time.Now()
+	resp, err = messagesURL.Receive(ctx, 1, time.Second, 250*time.Millisecond) // Polls at 0, 100, 250ms
+	c.Assert(err, chk.IsNil)
+	c.Assert(time.Since(start) >= 250*time.Millisecond, chk.Equals, true)
+	c.Assert(resp.Messages, chk.HasLen, 0)
+	c.Assert(resp.Polls, chk.Equals, 3)
+
+	// A done ctx is reported as an error, along with the number of polls sent
+	atomic.StoreInt32(&polls, 0)
+	cancelCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	resp, err = messagesURL.Receive(cancelCtx, 1, time.Second, time.Minute) // Polls at 0 and 100ms
+	c.Assert(err, chk.Equals, context.DeadlineExceeded)
+	c.Assert(resp.Messages, chk.HasLen, 0)
+	c.Assert(resp.Polls, chk.Equals, 2)
+
+	// So is a failed Dequeue
+	failing := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if atomic.AddInt32(&polls, 1) <= 2 {
+			return newMockedDequeueResponse(), nil
+		}
+		return newMockedResponse(http.StatusInternalServerError, nil), nil
+	})
+	atomic.StoreInt32(&polls, 0)
+	b = &azqueue.EmptyQueueBackoff{InitialDelay: time.Millisecond}
+	resp, err = azqueue.NewMessagesURL(*u, failing).ReceiveWithBackoff(ctx, 1, time.Second, time.Minute, b)
+	c.Assert(err, chk.NotNil)
+	c.Assert(resp.Polls, chk.Equals, 3)
+
+	// A nil backoff uses Receive's delays
+	atomic.StoreInt32(&polls, 0)
+	atomic.StoreInt32(&available, 1)
+	resp, err = messagesURL.ReceiveWithBackoff(ctx, 1, time.Second, time.Minute, nil)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Polls, chk.Equals, 2)
+}
+
+func (s *queueSuite) TestCopyQueueDeleteAfterCopy(c *chk.C) {
+	srcMessages := []string{"msg1", "msg2", "msg3"}
+	copied, deleted := []string{}, 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		switch {
+		case request.Method == http.MethodGet && request.URL.Path == "/src/messages":
+			resp := newMockedDequeueResponse(srcMessages...)
+			srcMessages = nil // The next Dequeue finds the source queue empty
+			return resp, nil
+		case request.Method == http.MethodPost && request.URL.Path == "/dst/messages":
+			body, _ := ioutil.ReadAll(request.Body)
+			msg := azqueue.QueueMessage{}
+			c.Assert(xml.Unmarshal(body, &msg), chk.IsNil)
+			copied = append(copied, msg.MessageText)
+			return newMockedEnqueueResponse(), nil
+		case request.Method == http.MethodDelete:
+			deleted++
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		}
+		c.Fatalf("unexpected request: %s %s", request.Method, request.URL)
+		return nil, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+	src, dst := serviceURL.NewQueueURL("src").NewMessagesURL(), serviceURL.NewQueueURL("dst").NewMessagesURL()
+
+	stats, err := azqueue.CopyQueue(ctx, src, dst, azqueue.CopyOptions{DeleteAfterCopy: true, Concurrency: 1})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*stats, chk.Equals, azqueue.CopyStats{Copied: 3})
+	c.Assert(copied, chk.DeepEquals, []string{"msg1", "msg2", "msg3"})
+	c.Assert(deleted, chk.Equals, 3)
+}
+
+func (s *queueSuite) TestCopyQueueOutlastsVisibilityTimeout(c *chk.C) {
+	src := mock.NewInMemoryQueue().NewMessageIDURL("id").MessagesURL()
+	for i := 0; i < 40; i++ {
+		_, err := src.Enqueue(ctx, fmt.Sprintf("msg%d", i), 0, 0)
+		c.Assert(err, chk.IsNil)
+	}
+	copied := []string{}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		time.Sleep(80 * time.Millisecond) // Copying the first batch of 32 outlasts the visibility timeout
+		body, _ := ioutil.ReadAll(request.Body)
+		msg := azqueue.QueueMessage{}
+		c.Assert(xml.Unmarshal(body, &msg), chk.IsNil)
+		copied = append(copied, msg.MessageText)
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/dst/messages")
+
+	// The first batch becomes visible again before the last 8 messages are dequeued; it mustn't end the copy
+	stats, err := azqueue.CopyQueue(ctx, src, azqueue.NewMessagesURL(*u, p), azqueue.CopyOptions{Concurrency: 1, VisibilityTimeout: 2 * time.Second})
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.Copied, chk.Equals, int64(40))
+	c.Assert(stats.Skipped, chk.Equals, int64(32))
+	c.Assert(copied, chk.HasLen, 40)
+	for i, text := range copied {
+		c.Assert(text, chk.Equals, fmt.Sprintf("msg%d", i))
+	}
+	props, err := src.QueueURL().GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.ApproximateMessagesCount(), chk.Equals, int32(40)) // The source queue is unchanged
+}
+
+func (s *queueSuite) TestCloneQueueConfiguration(c *chk.C) {
+	const acl = "<SignedIdentifiers><SignedIdentifier><Id>readers</Id><AccessPolicy><Permission>r</Permission></AccessPolicy></SignedIdentifier></SignedIdentifiers>"
+	var dstMetadata, dstACL string
+	dstExists := true
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		comp := request.URL.Query().Get("comp")
+		switch {
+		case request.Method == http.MethodGet && request.URL.Path == "/src" && comp == "metadata":
+			header := http.Header{}
+			header.Set("x-ms-meta-owner", "tenant1")
+			return newMockedResponse(http.StatusOK, header), nil
+		case request.Method == http.MethodGet && request.URL.Path == "/src" && comp == "acl":
+			resp := newMockedResponse(http.StatusOK, nil)
+			resp.Body = ioutil.NopCloser(strings.NewReader(acl))
+			return resp, nil
+		case request.Method == http.MethodPut && request.URL.Path == "/dst" && comp == "":
+			if dstExists {
+				header := http.Header{}
+				header.Set("x-ms-error-code", string(azqueue.ServiceCodeQueueAlreadyExists))
+				return newMockedResponse(http.StatusConflict, header), nil
+			}
+			dstExists, dstMetadata = true, request.Header.Get("x-ms-meta-owner")
+			return newMockedResponse(http.StatusCreated, nil), nil
+		case request.Method == http.MethodPut && request.URL.Path == "/dst" && comp == "metadata":
+			dstMetadata = request.Header.Get("x-ms-meta-owner")
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		case request.Method == http.MethodPut && request.URL.Path == "/dst" && comp == "acl":
+			body, _ := ioutil.ReadAll(request.Body)
+			dstACL = string(body)
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		}
+		c.Fatalf("unexpected request: %s %s", request.Method, request.URL)
+		return nil, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+	src, dst := serviceURL.NewQueueURL("src"), serviceURL.NewQueueURL("dst")
+
+	// An existing destination gets its metadata replaced
+	result, err := azqueue.CloneQueueConfiguration(ctx, src, dst, azqueue.CloneOptions{IncludeMetadata: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*result, chk.Equals, azqueue.CloneResult{MetadataApplied: true})
+	c.Assert(dstMetadata, chk.Equals, "tenant1")
+	c.Assert(dstACL, chk.Equals, "")
+
+	// A missing destination is created with the source's metadata
+	dstExists, dstMetadata = false, ""
+	result, err = azqueue.CloneQueueConfiguration(ctx, src, dst, azqueue.CloneOptions{IncludeMetadata: true, IncludeAccessPolicy: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*result, chk.Equals, azqueue.CloneResult{Created: true, MetadataApplied: true, AccessPolicyApplied: true})
+	c.Assert(dstMetadata, chk.Equals, "tenant1")
+	c.Assert(strings.Contains(dstACL, "<Id>readers</Id>"), chk.Equals, true)
+	c.Assert(strings.Contains(dstACL, "<Permission>r</Permission>"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestMultiQueueReceiverPriorityStarvation(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedDequeueResponse(request.URL.Path), nil // Every queue always has a message
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+	high, low := serviceURL.NewQueueURL("high").NewMessagesURL(), serviceURL.NewQueueURL("low").NewMessagesURL()
+
+	r, err := azqueue.NewMultiQueueReceiver([]azqueue.MessagesURL{high, low}, azqueue.MultiQueueReceiverOptions{MaxSkips: 2})
+	c.Assert(err, chk.IsNil)
+	for _, expected := range []string{"/high/messages", "/high/messages", "/low/messages", "/high/messages", "/high/messages", "/low/messages"} {
+		messages, err := r.Receive(ctx, 1)
+		c.Assert(err, chk.IsNil)
+		c.Assert(len(messages), chk.Equals, 1)
+		c.Assert(messages[0].Text, chk.Equals, expected)
+		c.Assert(messages[0].Source.String(), chk.Equals, "https://fakeaccount.queue.core.windows.net"+expected)
+	}
+}
+
+func (s *queueSuite) TestMultiQueueReceiverSkipsEmptyQueue(c *chk.C) {
+	polls := map[string]int{}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		polls[request.URL.Path]++
+		if request.URL.Path == "/empty/messages" {
+			return newMockedDequeueResponse(), nil
+		}
+		return newMockedDequeueResponse("msg"), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+	empty, full := serviceURL.NewQueueURL("empty").NewMessagesURL(), serviceURL.NewQueueURL("full").NewMessagesURL()
+
+	r, err := azqueue.NewMultiQueueReceiver([]azqueue.MessagesURL{empty, full},
+		azqueue.MultiQueueReceiverOptions{Mode: azqueue.MultiQueueModeRoundRobin, Backoff: azqueue.EmptyQueueBackoff{InitialDelay: time.Hour}})
+	c.Assert(err, chk.IsNil)
+	for n := 0; n < 4; n++ {
+		messages, err := r.Receive(ctx, 1)
+		c.Assert(err, chk.IsNil)
+		c.Assert(messages[0].Source.String(), chk.Equals, full.String())
+	}
+	c.Assert(polls["/empty/messages"], chk.Equals, 1) // The empty queue is in backoff after the 1st check
+}
+
+func (s *queueSuite) TestFindMessages(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		c.Assert(request.URL.Query().Get("peekonly"), chk.Equals, "true")
+		c.Assert(request.URL.Query().Get("numofmessages"), chk.Equals, "32")
+		return newMockedDequeueResponse("order-1", "invoice-2", "order-3", "order-4"), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+	isOrder := func(m *azqueue.PeekedMessage) bool { return strings.HasPrefix(m.Text, "order") }
+
+	found, err := messagesURL.FindMessages(ctx, isOrder, 2)
+	c.Assert(err, chk.IsNil)
+	c.Assert(found, chk.HasLen, 2)
+	c.Assert(found[0].Text, chk.Equals, "order-1")
+	c.Assert(found[1].Text, chk.Equals, "order-3")
+
+	found, err = messagesURL.FindMessages(ctx, isOrder, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(found, chk.HasLen, 3)
+}
+
+func (s *queueSuite) TestGetPropertiesAndPeek(c *chk.C) {
+	var propsStatus int32 = http.StatusOK
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if strings.HasSuffix(request.URL.Path, "/messages") {
+			return newMockedDequeueResponse("a", "b"), nil
+		}
+		if status := int(atomic.LoadInt32(&propsStatus)); status != http.StatusOK {
+			return newMockedResponse(status, http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeQueueNotFound)}}), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": []string{"42"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	snapshot, err := queueURL.GetPropertiesAndPeek(ctx, 2)
+	c.Assert(err, chk.IsNil)
+	c.Assert(snapshot.ApproximateCount, chk.Equals, int32(42))
+	c.Assert(snapshot.SampledMessages, chk.HasLen, 2)
+	c.Assert(snapshot.SampledMessages[0].Text, chk.Equals, "a")
+	c.Assert(snapshot.SampledMessages[1].Text, chk.Equals, "b")
+
+	atomic.StoreInt32(&propsStatus, http.StatusNotFound)
+	snapshot, err = queueURL.GetPropertiesAndPeek(ctx, 2)
+	c.Assert(snapshot, chk.IsNil)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeQueueNotFound)
+}
+
+func (s *queueSuite) TestInspect(c *chk.C) {
+	var numOfMessages string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if strings.HasSuffix(request.URL.Path, "/messages") {
+			numOfMessages = request.URL.Query().Get("numofmessages")
+			resp := newMockedDequeueResponse("aGVsbG8=", "not base64!")
+			b, _ := ioutil.ReadAll(resp.Body) // Make the 1st message a likely poison message
+			resp.Body = ioutil.NopCloser(strings.NewReader(strings.Replace(string(b), "<DequeueCount>1</DequeueCount>", "<DequeueCount>9</DequeueCount>", 1)))
+			return resp, nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": []string{"2"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	snapshot, err := messagesURL.Inspect(ctx, 100, azqueue.InspectOptions{DecodeBase64: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(numOfMessages, chk.Equals, "32")
+	c.Assert(snapshot.ApproximateCount, chk.Equals, int32(2))
+	inserted, _ := time.Parse(time.RFC1123, "Mon, 02 Jan 2006 15:04:05 GMT")
+	c.Assert(snapshot.OldestInsertionTime.Equal(inserted), chk.Equals, true)
+	c.Assert(snapshot.Messages, chk.HasLen, 2)
+	c.Assert(snapshot.Messages[0].Text, chk.Equals, "hello")
+	c.Assert(snapshot.Messages[0].DequeueCount, chk.Equals, int64(9))
+	c.Assert(snapshot.Messages[0].ExceedsDequeueThreshold, chk.Equals, true)
+	c.Assert(snapshot.Messages[0].Age > 0, chk.Equals, true)
+	c.Assert(snapshot.Messages[1].Text, chk.Equals, "not base64!")
+	c.Assert(snapshot.Messages[1].ExceedsDequeueThreshold, chk.Equals, false)
+
+	snapshot, err = messagesURL.Inspect(ctx, 1, azqueue.InspectOptions{DequeueCountThreshold: 10})
+	c.Assert(err, chk.IsNil)
+	c.Assert(numOfMessages, chk.Equals, "1")
+	c.Assert(snapshot.Messages[0].Text, chk.Equals, "aGVsbG8=")
+	c.Assert(snapshot.Messages[0].ExceedsDequeueThreshold, chk.Equals, false)
+
+	numOfMessages = ""
+	for _, max := range []int32{0, -1} {
+		_, err = messagesURL.Inspect(ctx, max, azqueue.InspectOptions{})
+		c.Assert(err, chk.ErrorMatches, "max must be > 0")
+	}
+	c.Assert(numOfMessages, chk.Equals, "")
+}
+
+func (s *queueSuite) TestEnqueueIdempotent(c *chk.C) {
+	var enqueued []string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		var msg azqueue.QueueMessage
+		b, _ := ioutil.ReadAll(request.Body)
+		c.Assert(xml.Unmarshal(b, &msg), chk.IsNil)
+		enqueued = append(enqueued, msg.MessageText)
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages?sig=first")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+	_, err := azqueue.NewIdempotencyCache(0)
+	c.Assert(err, chk.NotNil)
+	cache, err := azqueue.NewIdempotencyCache(2)
+	c.Assert(err, chk.IsNil)
+	_, err = messagesURL.EnqueueIdempotent(ctx, nil, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.NotNil)
+
+	first, err := messagesURL.EnqueueIdempotent(ctx, cache, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(enqueued, chk.HasLen, 1)
+	key, text, ok := azqueue.ParseIdempotentMessage(enqueued[0])
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(key, chk.Equals, "key1")
+	c.Assert(text, chk.Equals, "hello")
+
+	// A duplicate key returns the original response without sending a request, even if the SAS has changed
+	resigned, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages?sig=second")
+	second, err := azqueue.NewMessagesURL(*resigned, p).EnqueueIdempotent(ctx, cache, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(second, chk.Equals, first)
+	c.Assert(enqueued, chk.HasLen, 1)
+
+	// Another cache knows nothing of the keys in this one
+	other, _ := azqueue.NewIdempotencyCache(2)
+	_, err = messagesURL.EnqueueIdempotent(ctx, other, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(enqueued, chk.HasLen, 2)
+
+	// A different key, or the same key sent to another queue, is enqueued; the cache then forgets the least recently
+	// used key (key1 was used more recently than key2)
+	_, err = messagesURL.EnqueueIdempotent(ctx, cache, "key2", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	_, err = messagesURL.EnqueueIdempotent(ctx, cache, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	u2, _ := url.Parse("https://fakeaccount.queue.core.windows.net/otherqueue/messages")
+	_, err = azqueue.NewMessagesURL(*u2, p).EnqueueIdempotent(ctx, cache, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(enqueued, chk.HasLen, 4)
+	_, err = messagesURL.EnqueueIdempotent(ctx, cache, "key1", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(enqueued, chk.HasLen, 4)
+	_, err = messagesURL.EnqueueIdempotent(ctx, cache, "key2", "hello", 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(enqueued, chk.HasLen, 5)
+
+	_, _, ok = azqueue.ParseIdempotentMessage("plain text")
+	c.Assert(ok, chk.Equals, false)
+}
+
+func (s *queueSuite) TestEnqueueWithEnvelope(c *chk.C) {
+	var enqueued string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		var msg azqueue.QueueMessage
+		b, _ := ioutil.ReadAll(request.Body)
+		c.Assert(xml.Unmarshal(b, &msg), chk.IsNil)
+		enqueued = msg.MessageText
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	envelope := azqueue.MessageEnvelope{Body: `{"order":42}`, ContentType: "application/json", Headers: map[string]string{"route": "eu"}}
+	_, err := messagesURL.EnqueueWithEnvelope(ctx, envelope, 0, time.Minute)
+	c.Assert(err, chk.IsNil)
+
+	parsed, err := azqueue.ParseEnvelope(&azqueue.DequeuedMessage{ID: "id", Text: enqueued})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*parsed, chk.DeepEquals, envelope)
+
+	// Base64-encoded envelopes are accepted too
+	parsed, err = azqueue.ParseEnvelope(&azqueue.DequeuedMessage{ID: "id", Text: base64.StdEncoding.EncodeToString([]byte(enqueued))})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*parsed, chk.DeepEquals, envelope)
+
+	// Only JSON with an envelope's body and fields is an envelope
+	for _, text := range []string{"plain text", `{"foo":1}`, `{"contentType":"text/plain"}`, `{"body":"x","foo":1}`, `{"body":"x"} {}`, `"body"`} {
+		_, err = azqueue.ParseEnvelope(&azqueue.DequeuedMessage{ID: "id", Text: text})
+		c.Assert(err, chk.ErrorMatches, "message id is not a message envelope.*")
+	}
+	parsed, err = azqueue.ParseEnvelope(&azqueue.DequeuedMessage{ID: "id", Text: `{"body":""}`})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*parsed, chk.DeepEquals, azqueue.MessageEnvelope{})
+}
+
+func (s *queueSuite) TestRequeuePreservingProvenance(c *chk.C) {
+	src, dst := mock.NewInMemoryQueue(), mock.NewInMemoryQueue()
+	_, err := src.Enqueue(ctx, "payload", 0, 0)
+	c.Assert(err, chk.IsNil)
+	dequeue, err := src.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	msg := dequeue.Message(0)
+
+	dstURL := dst.NewMessageIDURL("id").MessagesURL()
+	_, err = msg.Requeue(ctx, dstURL, azqueue.RequeueOptions{PreserveProvenance: true, SourceQueue: "orders"})
+	c.Assert(err, chk.IsNil)
+	dequeue, err = dst.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	requeued := dequeue.Message(0)
+
+	provenance, body := azqueue.UnwrapProvenance(requeued.Text)
+	c.Assert(body, chk.Equals, "payload")
+	c.Assert(provenance.OriginalID, chk.Equals, msg.ID)
+	c.Assert(provenance.OriginalInsertionTime.Equal(msg.InsertionTime), chk.Equals, true)
+	c.Assert(provenance.DequeueCount, chk.Equals, msg.DequeueCount)
+	c.Assert(provenance.SourceQueue, chk.Equals, "orders")
+
+	// Requeuing a requeued message keeps its original provenance instead of nesting envelopes
+	_, err = requeued.Requeue(ctx, dstURL, azqueue.RequeueOptions{PreserveProvenance: true, SourceQueue: "dead-letters"})
+	c.Assert(err, chk.IsNil)
+	dequeue, err = dst.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	again, body := azqueue.UnwrapProvenance(dequeue.Message(0).Text)
+	c.Assert(body, chk.Equals, "payload")
+	c.Assert(again.OriginalID, chk.Equals, provenance.OriginalID)
+	c.Assert(again.SourceQueue, chk.Equals, "orders")
+
+	// Without PreserveProvenance, the text is requeued as is
+	_, err = msg.Requeue(ctx, dstURL, azqueue.RequeueOptions{})
+	c.Assert(err, chk.IsNil)
+	dequeue, err = dst.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(dequeue.Message(0).Text, chk.Equals, "payload")
+
+	// Messages without a provenance envelope, including ordinary JSON with a "provenance" field, pass through untouched
+	for _, text := range []string{"plain text", `{"body":"no provenance"}`, `[1,2]`, "",
+		`{"provenance":{"original-id":""},"body":"x"}`,
+		`{"provenance":"upstream","body":"x"}`,
+		`{"provenance":{"original-id":"id"}}`,
+		`{"provenance":{"original-id":"id","author":"x"},"body":"x"}`,
+		`{"provenance":{"original-id":"id"},"body":"x","order":42}`,
+		`{"provenance":{"original-id":"id"},"body":"x","contentType":"text/plain"}`} {
+		provenance, body = azqueue.UnwrapProvenance(text)
+		c.Assert(body, chk.Equals, text)
+		c.Assert(provenance, chk.DeepEquals, azqueue.Provenance{})
+	}
+}
+
+func (s *queueSuite) TestDeleteWithRetry(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	_, err := q.Enqueue(ctx, "target", 0, 0)
+	c.Assert(err, chk.IsNil)
+	dequeue, err := q.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	msg := dequeue.Message(0)
+	msgIDURL := q.NewMessageIDURL(msg.ID)
+
+	// The consumer's own heartbeat renews the message, making the pop receipt it started deleting with stale
+	update, err := msgIDURL.ExtendVisibility(ctx, msg.PopReceipt, time.Minute)
+	c.Assert(err, chk.IsNil)
+	receipts := func(popReceipts ...azqueue.PopReceipt) func() azqueue.PopReceipt {
+		return func() azqueue.PopReceipt {
+			popReceipt := popReceipts[0]
+			if len(popReceipts) > 1 {
+				popReceipts = popReceipts[1:]
+			}
+			return popReceipt
+		}
+	}
+	err = msgIDURL.DeleteWithRetry(ctx, receipts(msg.PopReceipt, update.PopReceipt), 0) // No retries allowed
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, true)
+	c.Assert(errors.Is(err, azqueue.ErrMessageTakenByAnotherConsumer), chk.Equals, false)
+	c.Assert(msgIDURL.DeleteWithRetry(ctx, receipts(msg.PopReceipt, update.PopReceipt), 1), chk.IsNil)
+	peek, err := q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(0))
+
+	// A message dequeued by another consumer after its visibility timeout expired can't be deleted, and DeleteWithRetry
+	// never dequeues it to get its pop receipt
+	_, err = q.Enqueue(ctx, "taken", 0, 0)
+	c.Assert(err, chk.IsNil)
+	dequeue, err = q.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	msg = dequeue.Message(0)
+	_, err = q.NewMessageIDURL(msg.ID).ExtendVisibility(ctx, msg.PopReceipt, 0) // Like an expired visibility timeout
+	c.Assert(err, chk.IsNil)
+	_, err = q.Dequeue(ctx, 1, time.Minute) // Another consumer
+	c.Assert(err, chk.IsNil)
+	err = q.NewMessageIDURL(msg.ID).DeleteWithRetry(ctx, receipts(msg.PopReceipt), 3)
+	c.Assert(errors.Is(err, azqueue.ErrMessageTakenByAnotherConsumer), chk.Equals, true)
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, true)
+	peek, err = q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(0)) // Still invisible, held by the other consumer
+}
+
+func (s *queueSuite) TestEnqueueBulkFromReader(c *chk.C) {
+	lock := sync.Mutex{}
+	enqueued := map[string]int{}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		var msg azqueue.QueueMessage
+		b, _ := ioutil.ReadAll(request.Body)
+		c.Assert(xml.Unmarshal(b, &msg), chk.IsNil)
+		if msg.MessageText == "fail" {
+			return newMockedResponse(http.StatusBadRequest, nil), nil
+		}
+		lock.Lock()
+		enqueued[msg.MessageText]++
+		lock.Unlock()
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	input := "one\r\ntwo\n\nfail\n" + strings.Repeat("x", 5000) + "\nthree"
+	stats, err := azqueue.EnqueueBulkFromReader(ctx, messagesURL, strings.NewReader(input), azqueue.BulkEnqueueOptions{MaxLineSize: 10})
+	c.Assert(err, chk.IsNil)
+	c.Assert(*stats, chk.Equals, azqueue.BulkEnqueueStats{EnqueuedCount: 3, FailedCount: 2, BytesRead: int64(len(input))})
+	c.Assert(enqueued, chk.DeepEquals, map[string]int{"one": 1, "two": 1, "three": 1})
+
+	// A custom delimiter
+	enqueued = map[string]int{}
+	stats, err = azqueue.EnqueueBulkFromReader(ctx, messagesURL, strings.NewReader("a,b,"), azqueue.BulkEnqueueOptions{Delimiter: ',', Concurrency: 1})
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.EnqueuedCount, chk.Equals, int64(2))
+	c.Assert(enqueued, chk.DeepEquals, map[string]int{"a": 1, "b": 1})
+
+	// A read error stops the operation
+	readErr := errors.New("read failed")
+	stats, err = azqueue.EnqueueBulkFromReader(ctx, messagesURL, io.MultiReader(strings.NewReader("a\n"), &failingReader{err: readErr}), azqueue.BulkEnqueueOptions{})
+	c.Assert(err, chk.Equals, readErr)
+	c.Assert(stats.EnqueuedCount, chk.Equals, int64(1))
+}
+
+// failingReader is an io.Reader that always returns err.
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
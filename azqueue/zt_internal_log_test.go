@@ -0,0 +1,44 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestSetInternalLogger(c *chk.C) {
+	lock := sync.Mutex{}
+	var messages []string
+	azqueue.SetInternalLogger(func(level pipeline.LogLevel, msg string) {
+		lock.Lock()
+		defer lock.Unlock()
+		c.Check(level, chk.Equals, pipeline.LogWarning)
+		messages = append(messages, msg)
+	})
+	defer azqueue.SetInternalLogger(nil)
+
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("x-ms-error-code", string(azqueue.ServiceCodeServerBusy))
+		return newMockedResponse(http.StatusServiceUnavailable, header), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages?sig=secret")
+	monitor := azqueue.NewThroughputMonitor(azqueue.NewMessagesURL(*u, p), 10*time.Millisecond)
+	monitor.Start(ctx)
+	for deadline := time.Now().Add(5 * time.Second); monitor.CurrentStats().Err == nil; time.Sleep(5 * time.Millisecond) {
+		c.Assert(time.Now().Before(deadline), chk.Equals, true)
+	}
+	monitor.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	c.Assert(len(messages) > 0, chk.Equals, true)
+	c.Assert(strings.HasPrefix(messages[0], "azqueue: throughput monitor for "), chk.Equals, true)
+	c.Assert(strings.Contains(messages[0], "secret"), chk.Equals, false) // URLs are logged with their signature redacted
+}
@@ -0,0 +1,50 @@
+package azqueue_test
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestMessageLogging(c *chk.C) {
+	inserted := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	msg := &azqueue.DequeuedMessage{
+		ID:              "id",
+		InsertionTime:   inserted,
+		ExpirationTime:  inserted.Add(time.Hour),
+		PopReceipt:      "secret",
+		NextVisibleTime: inserted.Add(time.Minute),
+		DequeueCount:    2,
+		Text:            strings.Repeat("é", 300),
+	}
+	elided := strings.Repeat("é", 256) + "...(44 more characters)"
+
+	b, err := json.Marshal(msg)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, `{"id":"id","dequeueCount":2,"insertionTime":"2006-01-02T15:04:05Z",`+
+		`"expirationTime":"2006-01-02T16:04:05Z","nextVisibleTime":"2006-01-02T15:05:05Z","popReceipt":"[REDACTED]","text":"`+elided+`"}`)
+	c.Assert(msg.String(), chk.Equals, `{id=id dequeueCount=2 insertionTime=2006-01-02T15:04:05Z expirationTime=2006-01-02T16:04:05Z `+
+		`nextVisibleTime=2006-01-02T15:05:05Z popReceipt=[REDACTED] text="`+elided+`"}`)
+
+	peeked := azqueue.PeekedMessage{ID: "id", InsertionTime: inserted, Text: "short"}
+	b, err = json.Marshal(peeked)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, `{"id":"id","dequeueCount":0,"insertionTime":"2006-01-02T15:04:05Z","text":"short"}`)
+
+	enqueued := azqueue.EnqueueMessageResponse{MessageID: "id", PopReceipt: "secret", InsertionTime: inserted}
+	c.Assert(enqueued.String(), chk.Equals, "{id=id insertionTime=2006-01-02T15:04:05Z popReceipt=[REDACTED]}")
+
+	o := azqueue.MessageLogOptions{TextMaxLength: -1, IncludePopReceipt: true}
+	c.Assert(msg.LogString(o), chk.Equals, `{id=id dequeueCount=2 insertionTime=2006-01-02T15:04:05Z expirationTime=2006-01-02T16:04:05Z `+
+		`nextVisibleTime=2006-01-02T15:05:05Z popReceipt=secret}`)
+	b, err = enqueued.LogJSON(o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, `{"id":"id","insertionTime":"2006-01-02T15:04:05Z","popReceipt":"secret"}`)
+	c.Assert(peeked.LogString(azqueue.MessageLogOptions{TextMaxLength: 2}), chk.Equals, `{id=id dequeueCount=0 insertionTime=2006-01-02T15:04:05Z text="sh...(3 more characters)"}`)
+
+	// The options only apply to the call they're passed to
+	c.Assert(enqueued.String(), chk.Equals, "{id=id insertionTime=2006-01-02T15:04:05Z popReceipt=[REDACTED]}")
+}
@@ -0,0 +1,321 @@
+package azqueue_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestQueueURLPartsSovereignClouds(c *chk.C) {
+	testCases := []struct {
+		rawURL    string
+		account   string
+		suffix    string
+		secondary bool
+	}{
+		{"https://myaccount.queue.core.windows.net/myqueue/messages", "myaccount", azqueue.EndpointSuffixAzurePublic, false},
+		{"https://myaccount.queue.core.chinacloudapi.cn/myqueue/messages?sv=2018-03-28&sig=abc", "myaccount", azqueue.EndpointSuffixAzureChina, false},
+		{"https://myaccount-secondary.queue.core.chinacloudapi.cn/myqueue", "myaccount", azqueue.EndpointSuffixAzureChina, true},
+		{"https://myaccount.queue.core.usgovcloudapi.net/myqueue/messages/id", "myaccount", azqueue.EndpointSuffixAzureUSGovernment, false},
+		{"https://myaccount-secondary.queue.core.usgovcloudapi.net/", "myaccount", azqueue.EndpointSuffixAzureUSGovernment, true},
+		{"https://myaccount.queue.local.azurestack.external:443/myqueue", "myaccount", "local.azurestack.external", false},
+		{"http://127.0.0.1:10001/devstoreaccount1", "", "", false},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.rawURL)
+		parts := azqueue.NewQueueURLParts(*u)
+		c.Assert(parts.AccountName(), chk.Equals, tc.account, chk.Commentf("%s", tc.rawURL))
+		c.Assert(parts.EndpointSuffix(), chk.Equals, tc.suffix, chk.Commentf("%s", tc.rawURL))
+		c.Assert(parts.IsSecondary(), chk.Equals, tc.secondary, chk.Commentf("%s", tc.rawURL))
+
+		// Reconstructing the URL must preserve the host whatever the cloud
+		reconstructed, err := parts.URL()
+		c.Assert(err, chk.IsNil)
+		c.Assert(reconstructed.Host, chk.Equals, u.Host)
+	}
+}
+
+func (s *queueSuite) TestNewServiceURLParts(c *chk.C) {
+	parts := azqueue.NewServiceURLParts("myaccount", azqueue.EndpointSuffixAzureChina)
+	u, err := parts.URL()
+	c.Assert(err, chk.IsNil)
+	c.Assert(u.String(), chk.Equals, "https://myaccount.queue.core.chinacloudapi.cn")
+
+	parts = azqueue.NewServiceURLParts("myaccount", "")
+	c.Assert(parts.Host, chk.Equals, "myaccount.queue.core.windows.net")
+	c.Assert(parts.AccountName(), chk.Equals, "myaccount")
+}
+
+func (s *queueSuite) TestSecondaryURL(c *chk.C) {
+	testCases := []struct{ primary, secondary string }{
+		{"https://myaccount.queue.core.windows.net?sv=2018-03-28&sig=abc", "https://myaccount-secondary.queue.core.windows.net?sig=abc&sv=2018-03-28"},
+		{"https://myaccount.queue.core.chinacloudapi.cn/", "https://myaccount-secondary.queue.core.chinacloudapi.cn"},
+		{"https://myaccount-secondary.queue.core.usgovcloudapi.net", "https://myaccount-secondary.queue.core.usgovcloudapi.net"},
+		{"https://myaccount.queue.local.azurestack.external:443", "https://myaccount-secondary.queue.local.azurestack.external:443"},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.primary)
+		secondary, err := azqueue.NewServiceURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{})).SecondaryURL()
+		c.Assert(err, chk.IsNil)
+		secondaryURL := secondary.URL()
+		c.Assert(secondaryURL.String(), chk.Equals, tc.secondary)
+	}
+
+	for _, rawURL := range []string{"http://127.0.0.1:10001/devstoreaccount1", "http://localhost:10001/devstoreaccount1"} {
+		u, _ := url.Parse(rawURL)
+		_, err := azqueue.NewQueueURLParts(*u).Secondary()
+		c.Assert(err, chk.NotNil)
+	}
+}
+
+func (s *queueSuite) TestNewSASQueryParametersRoundTrip(c *chk.C) {
+	const rawQuery = "comp=metadata&se=2222-03-09T01%3A42%3A34.936Z&sig=92836758923659283652983562%3D%3D&sp=rup&sr=q&st=2111-01-09&sv=2015-02-21"
+	values, _ := url.ParseQuery(rawQuery)
+	sas := azqueue.NewSASQueryParameters(values, true)
+	c.Assert(values.Encode(), chk.Equals, "comp=metadata") // Only the non-SAS parameters remain
+	c.Assert(sas.Permissions(), chk.Equals, "rup")
+	c.Assert(sas.ExpiryTime(), chk.Equals, time.Date(2222, time.March, 9, 1, 42, 34, 936000000, time.UTC))
+	c.Assert(sas.StartTime(), chk.Equals, time.Date(2111, time.January, 9, 0, 0, 0, 0, time.UTC))
+
+	// Encoding must reproduce the signed values exactly
+	c.Assert("comp=metadata&"+sas.Encode(), chk.Equals, rawQuery)
+}
+
+func (s *queueSuite) TestSASQueryParametersEncodeSignature(c *chk.C) {
+	// A precomputed signature with every base64 character that must be escaped in a query string
+	const signature = "a+b/c+/d+xYz0/9+Q=="
+	const encoded = "sig=a%2Bb%2Fc%2B%2Fd%2BxYz0%2F9%2BQ%3D%3D&sv=2018-03-28"
+
+	for _, rawQuery := range []string{
+		encoded,
+		"sig=a+b/c+/d+xYz0/9+Q==&sv=2018-03-28", // Not escaped at all
+		"sig=a%2bb%2fc%2b%2fd%2bxYz0%2f9%2bQ%3d%3d&sv=2018-03-28", // Lowercase escapes
+		"sig=a+b%2Fc%2B/d+xYz0/9%2BQ%3D=&sv=2018-03-28",           // Partly escaped
+	} {
+		values, err := url.ParseQuery(rawQuery)
+		c.Assert(err, chk.IsNil)
+		sas := azqueue.NewSASQueryParameters(values, false)
+		c.Assert(sas.Signature(), chk.Equals, signature)
+		c.Assert(sas.Encode(), chk.Equals, encoded) // Never double-encoded
+
+		// The same holds for a SAS parsed from a URL and the URL rebuilt from its parts
+		u, _ := url.Parse("https://account.queue.core.windows.net/queue?" + rawQuery)
+		parts := azqueue.NewQueueURLParts(*u)
+		c.Assert(parts.SAS.Signature(), chk.Equals, signature)
+		rebuilt, err := parts.URL()
+		c.Assert(err, chk.IsNil)
+		c.Assert(rebuilt.RawQuery, chk.Equals, encoded)
+	}
+}
+
+func (s *queueSuite) TestSASQueryParametersIsExpired(c *chk.C) {
+	newSAS := func(expiry time.Time) azqueue.SASQueryParameters {
+		values := url.Values{"sv": {"2018-03-28"}, "sig": {"abc"}}
+		if !expiry.IsZero() {
+			values.Set("se", expiry.UTC().Format(azqueue.SASTimeFormat))
+		}
+		return azqueue.NewSASQueryParameters(values, false)
+	}
+
+	sas := newSAS(time.Now().Add(time.Hour))
+	c.Assert(sas.IsExpired(), chk.Equals, false)
+	c.Assert(sas.IsAboutToExpire(30*time.Minute), chk.Equals, false)
+	c.Assert(sas.IsAboutToExpire(2*time.Hour), chk.Equals, true)
+	c.Assert(sas.ExpiresIn() > 59*time.Minute && sas.ExpiresIn() <= time.Hour, chk.Equals, true)
+
+	sas = newSAS(time.Now().Add(-time.Hour))
+	c.Assert(sas.IsExpired(), chk.Equals, true)
+	c.Assert(sas.IsAboutToExpire(time.Minute), chk.Equals, true)
+	c.Assert(sas.ExpiresIn() < 0, chk.Equals, true)
+
+	sas = newSAS(time.Time{}) // The expiry time comes from a stored access policy
+	c.Assert(sas.IsExpired(), chk.Equals, false)
+	c.Assert(sas.IsAboutToExpire(time.Hour), chk.Equals, false)
+	c.Assert(sas.ExpiresIn(), chk.Equals, time.Duration(math.MaxInt64))
+}
+
+func (s *queueSuite) TestURLStringRedactsSignature(c *chk.C) {
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue?sig=secret%3D&sp=r&sv=2018-03-28")
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{})
+	queueURL := azqueue.NewQueueURL(*u, p)
+	messagesURL := queueURL.NewMessagesURL()
+	messageIDURL := messagesURL.NewMessageIDURL("id")
+	for _, s := range []string{azqueue.NewServiceURL(*u, p).String(), queueURL.String(), messagesURL.String(), messageIDURL.String()} {
+		c.Assert(strings.Contains(s, "secret"), chk.Equals, false)
+		c.Assert(strings.Contains(s, "sig=[REDACTED]"), chk.Equals, true)
+		c.Assert(strings.Contains(s, "sp=r&sv=2018-03-28"), chk.Equals, true) // The other SAS parameters are kept as is
+	}
+
+	// URL returns the signature for the cases that need it
+	u2 := messageIDURL.URL()
+	c.Assert(u2.Query().Get("sig"), chk.Equals, "secret=")
+
+	u, _ = url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue?comp=metadata")
+	c.Assert(azqueue.NewQueueURL(*u, p).String(), chk.Equals, "https://fakeaccount.queue.core.windows.net/fakequeue?comp=metadata")
+}
+
+func (s *queueSuite) TestURLAccessorsRoundTrip(c *chk.C) {
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages/id?sig=a%2Bb%3D&sv=2018-03-28")
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{})
+	messageIDURL := azqueue.NewMessageIDURL(*u, p)
+	messagesURL := messageIDURL.MessagesURL()
+	queueURL := messagesURL.QueueURL()
+
+	// Each URL type's URL can be passed to NewQueueURLParts directly, for example to derive the secondary endpoint
+	for _, tc := range []struct {
+		u         url.URL
+		messages  bool
+		messageID azqueue.MessageID
+	}{{queueURL.URL(), false, ""}, {messagesURL.URL(), true, ""}, {messageIDURL.URL(), true, "id"}} {
+		parts := azqueue.NewQueueURLParts(tc.u)
+		c.Assert(parts.QueueName, chk.Equals, "fakequeue")
+		c.Assert(parts.Messages, chk.Equals, tc.messages)
+		c.Assert(parts.MessageID, chk.Equals, tc.messageID)
+		c.Assert(parts.SAS.Signature(), chk.Equals, "a+b=")
+		rebuilt, err := parts.URL()
+		c.Assert(err, chk.IsNil)
+		c.Assert(rebuilt, chk.DeepEquals, tc.u)
+	}
+
+	// The returned URL is a copy
+	copied := queueURL.URL()
+	copied.Host, copied.RawQuery = "other.queue.core.windows.net", ""
+	c.Assert(queueURL.String(), chk.Equals, "https://fakeaccount.queue.core.windows.net/fakequeue?sig=[REDACTED]&sv=2018-03-28")
+}
+
+func (s *queueSuite) TestParentURLs(c *chk.C) {
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{})
+	testCases := []struct{ messageIDURL, messagesURL, queueURL, serviceURL string }{
+		{"https://myaccount.queue.core.windows.net/myqueue/messages/id?sig=abc&sv=2018-03-28",
+			"https://myaccount.queue.core.windows.net/myqueue/messages?sig=abc&sv=2018-03-28",
+			"https://myaccount.queue.core.windows.net/myqueue?sig=abc&sv=2018-03-28",
+			"https://myaccount.queue.core.windows.net?sig=abc&sv=2018-03-28"},
+		{"http://127.0.0.1:10001/devstoreaccount1/myqueue/messages/id",
+			"http://127.0.0.1:10001/devstoreaccount1/myqueue/messages",
+			"http://127.0.0.1:10001/devstoreaccount1/myqueue",
+			"http://127.0.0.1:10001/devstoreaccount1"},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.messageIDURL)
+		messageIDURL := azqueue.NewMessageIDURL(*u, p)
+		c.Assert(messageIDURL.MessageID(), chk.Equals, azqueue.MessageID("id"))
+
+		messagesURL := messageIDURL.MessagesURL()
+		mu := messagesURL.URL()
+		c.Assert(mu.String(), chk.Equals, tc.messagesURL)
+
+		queueURL := messagesURL.QueueURL()
+		qu := queueURL.URL()
+		c.Assert(qu.String(), chk.Equals, tc.queueURL)
+		c.Assert(queueURL.QueueName(), chk.Equals, "myqueue")
+
+		serviceURL := queueURL.ServiceURL()
+		su := serviceURL.URL()
+		c.Assert(su.String(), chk.Equals, tc.serviceURL)
+
+		// Navigating back down produces the original URL
+		back := serviceURL.NewQueueURL("myqueue").NewMessagesURL().NewMessageIDURL("id").URL()
+		c.Assert(back.String(), chk.Equals, tc.messageIDURL)
+	}
+}
+
+func (s *queueSuite) TestValidateSASSignature(c *chk.C) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 64))
+	credential, err := azqueue.NewSharedKeyCredential("myaccount", key)
+	c.Assert(err, chk.IsNil)
+	parse := func(sas azqueue.SASQueryParameters) azqueue.SASQueryParameters {
+		values, _ := url.ParseQuery(sas.Encode())
+		return azqueue.NewSASQueryParameters(values, false)
+	}
+
+	queueSAS := parse(azqueue.QueueSASSignatureValues{
+		Protocol:    azqueue.SASProtocolHTTPS,
+		ExpiryTime:  time.Now().UTC().Add(time.Hour),
+		Permissions: azqueue.QueueSASPermissions{Read: true, Process: true}.String(),
+		QueueName:   "myqueue",
+	}.NewSASQueryParameters(credential))
+	valid, err := azqueue.ValidateSASSignature(queueSAS, credential, "myqueue")
+	c.Assert(err, chk.IsNil)
+	c.Assert(valid, chk.Equals, true)
+
+	// The SAS doesn't grant access to another queue
+	valid, err = azqueue.ValidateSASSignature(queueSAS, credential, "otherqueue")
+	c.Assert(err, chk.IsNil)
+	c.Assert(valid, chk.Equals, false)
+
+	// A SAS whose signature or signed fields were changed is invalid
+	for param, value := range map[string]string{"sig": "bXV0YXRlZA==", "sp": "raup", "se": "2999-01-01T00:00:00Z"} {
+		values, _ := url.ParseQuery(queueSAS.Encode())
+		values.Set(param, value)
+		valid, err = azqueue.ValidateSASSignature(azqueue.NewSASQueryParameters(values, false), credential, "myqueue")
+		c.Assert(err, chk.IsNil)
+		c.Assert(valid, chk.Equals, false)
+	}
+
+	// A SAS signed with another account's key is invalid
+	otherCredential, _ := azqueue.NewSharedKeyCredential("myaccount", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 64)))
+	valid, err = azqueue.ValidateSASSignature(queueSAS, otherCredential, "myqueue")
+	c.Assert(err, chk.IsNil)
+	c.Assert(valid, chk.Equals, false)
+
+	accountSAS, err := azqueue.AccountSASSignatureValues{
+		ExpiryTime:    time.Now().UTC().Add(time.Hour),
+		Permissions:   azqueue.AccountSASPermissions{Read: true, List: true}.String(),
+		Services:      azqueue.AccountSASServices{Queue: true}.String(),
+		ResourceTypes: azqueue.AccountSASResourceTypes{Service: true, Container: true, Object: true}.String(),
+	}.NewSASQueryParameters(credential)
+	c.Assert(err, chk.IsNil)
+	valid, err = azqueue.ValidateSASSignature(parse(accountSAS), credential, "")
+	c.Assert(err, chk.IsNil)
+	c.Assert(valid, chk.Equals, true)
+
+	_, err = azqueue.ValidateSASSignature(azqueue.SASQueryParameters{}, credential, "myqueue")
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *queueSuite) TestSASPermissionsValidate(c *chk.C) {
+	queueCases := []struct {
+		permissions azqueue.QueueSASPermissions
+		valid       bool
+	}{
+		{azqueue.QueueSASPermissions{}, false},
+		{azqueue.QueueSASPermissions{Read: true}, true},
+		{azqueue.QueueSASPermissions{Add: true}, true},
+		{azqueue.QueueSASPermissions{Update: true}, true},
+		{azqueue.QueueSASPermissions{Process: true}, true},
+		{azqueue.QueueSASPermissions{Read: true, Process: true}, true},
+		{azqueue.QueueSASPermissions{Read: true, Add: true, Update: true, Process: true}, true},
+	}
+	for _, tc := range queueCases {
+		if tc.valid {
+			c.Assert(tc.permissions.Validate(), chk.IsNil, chk.Commentf("%+v", tc.permissions))
+		} else {
+			c.Assert(tc.permissions.Validate(), chk.NotNil, chk.Commentf("%+v", tc.permissions))
+		}
+	}
+
+	accountCases := []struct {
+		permissions azqueue.AccountSASPermissions
+		valid       bool
+	}{
+		{azqueue.AccountSASPermissions{}, false},
+		{azqueue.AccountSASPermissions{Read: true}, true},
+		{azqueue.AccountSASPermissions{List: true}, true},
+		{azqueue.AccountSASPermissions{Process: true}, true},
+		{azqueue.AccountSASPermissions{Read: true, Process: true}, true},
+		{azqueue.AccountSASPermissions{Read: true, Write: true, Delete: true, List: true, Add: true, Create: true, Update: true, Process: true}, true},
+	}
+	for _, tc := range accountCases {
+		if tc.valid {
+			c.Assert(tc.permissions.Validate(), chk.IsNil, chk.Commentf("%+v", tc.permissions))
+		} else {
+			c.Assert(tc.permissions.Validate(), chk.NotNil, chk.Commentf("%+v", tc.permissions))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestPipelineStats(c *chk.C) {
+	stats := &azqueue.PipelineStatsCounters{}
+	var inFlight []int64
+	busyResponses := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight = append(inFlight, stats.Snapshot().InFlight)
+		if busyResponses > 0 {
+			busyResponses--
+			w.Header().Set("x-ms-error-code", string(azqueue.ServiceCodeServerBusy))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{
+		Retry: azqueue.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond},
+		Stats: stats,
+	}))
+
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.Snapshot(), chk.Equals, azqueue.PipelineStatsSnapshot{Throttled: 2, RetriedSuccess: 1, InFlight: 0})
+	c.Assert(inFlight, chk.DeepEquals, []int64{1, 1, 1})
+
+	// A first-try success is not counted as a retried success
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.Snapshot(), chk.Equals, azqueue.PipelineStatsSnapshot{Throttled: 2, RetriedSuccess: 1, InFlight: 0})
+}
@@ -0,0 +1,49 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestNewPipelineWithOptions(c *chk.C) {
+	var userAgents, versions []string
+	busyResponses := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		versions = append(versions, r.Header.Get("x-ms-version"))
+		if busyResponses > 0 {
+			busyResponses--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	stats := &azqueue.PipelineStatsCounters{}
+	p := azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(),
+		azqueue.WithRetry(azqueue.RetryOptions{MaxTries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}),
+		azqueue.WithTelemetry(azqueue.TelemetryOptions{UserAgent: "custom"}),
+		azqueue.WithServiceVersion("2017-07-29"),
+		azqueue.WithStats(stats))
+
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(userAgents, chk.DeepEquals, []string{"custom", "custom"})
+	c.Assert(versions, chk.DeepEquals, []string{"2017-07-29", "2017-07-29"})
+	c.Assert(stats.Snapshot().RetriedSuccess, chk.Equals, int64(1))
+
+	// Later options override earlier ones, and the pipeline can still be reconfigured like one from NewPipeline
+	p = azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(),
+		azqueue.WithTelemetry(azqueue.TelemetryOptions{UserAgent: "first"}),
+		azqueue.WithTelemetry(azqueue.TelemetryOptions{UserAgent: "second"}))
+	userAgents = nil
+	queueURL, err := azqueue.NewQueueURL(*u, p).WithRetryOptions(azqueue.RetryOptions{MaxTries: 1})
+	c.Assert(err, chk.IsNil)
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(userAgents, chk.DeepEquals, []string{"second"})
+}
@@ -0,0 +1,41 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestInflightCounterPolicy(c *chk.C) {
+	counter, factory := azqueue.NewInflightCounterPolicyFactory()
+	arrived, release := make(chan struct{}), make(chan struct{})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		arrived <- struct{}{}
+		<-release
+		return newMockedResponse(http.StatusOK, nil), nil
+	}, factory)
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	const requests = 3
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queueURL.GetProperties(ctx)
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		<-arrived
+	}
+	c.Assert(counter.Count(), chk.Equals, int64(requests))
+
+	close(release)
+	wg.Wait()
+	c.Assert(counter.Count(), chk.Equals, int64(0))
+}
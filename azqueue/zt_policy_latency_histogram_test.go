@@ -0,0 +1,59 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestLatencyHistogramPolicy(c *chk.C) {
+	histogram, factory := azqueue.NewLatencyHistogramPolicyFactory([]time.Duration{50 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond})
+	delay := time.Duration(0)
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		time.Sleep(delay)
+		return newMockedDequeueResponse(), nil
+	}, factory)
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+	c.Assert(histogram.Percentile(50), chk.Equals, time.Duration(0))
+
+	// 8 fast dequeues and 2 slow ones exceeding the largest bucket
+	for i := 0; i < 10; i++ {
+		if i == 8 {
+			delay = 60 * time.Millisecond
+		}
+		_, err := queueURL.NewMessagesURL().Dequeue(ctx, 1, time.Second)
+		c.Assert(err, chk.IsNil)
+	}
+	c.Assert(histogram.Operations(), chk.DeepEquals, []string{"GET messages"})
+	c.Assert(histogram.Percentile(50), chk.Equals, 10*time.Millisecond)
+	c.Assert(histogram.Percentile(80), chk.Equals, 10*time.Millisecond)
+	c.Assert(histogram.Percentile(90) >= 60*time.Millisecond, chk.Equals, true) // The largest recorded latency
+	c.Assert(histogram.OperationPercentile("GET messages", 50), chk.Equals, 10*time.Millisecond)
+	c.Assert(histogram.OperationPercentile("GET queue", 50), chk.Equals, time.Duration(0))
+
+	histogram.Reset()
+	c.Assert(histogram.Operations(), chk.HasLen, 0)
+	c.Assert(histogram.Percentile(50), chk.Equals, time.Duration(0))
+}
+
+func (s *queueSuite) TestLatencyHistogramOperationNames(c *chk.C) {
+	histogram, factory := azqueue.NewLatencyHistogramPolicyFactory(nil)
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusOK, nil), nil
+	}, factory)
+	for _, rawURL := range []string{"https://fakeaccount.queue.core.windows.net", "http://127.0.0.1:10001/devstoreaccount1"} {
+		u, _ := url.Parse(rawURL)
+		serviceURL := azqueue.NewServiceURL(*u, p)
+		serviceURL.GetProperties(ctx)
+		queueURL := serviceURL.NewQueueURL("q")
+		queueURL.GetProperties(ctx)
+		queueURL.SetMetadata(ctx, azqueue.Metadata{})
+		queueURL.NewMessagesURL().NewMessageIDURL("id").Delete(ctx, "pr")
+	}
+	c.Assert(histogram.Operations(), chk.DeepEquals, []string{"DELETE message", "GET queue?comp=metadata", "GET service?comp=properties", "PUT queue?comp=metadata"})
+}
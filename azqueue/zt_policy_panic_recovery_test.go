@@ -0,0 +1,66 @@
+package azqueue_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestPanicRecoveryPolicy(c *chk.C) {
+	bug := errors.New("nil pointer in a custom policy")
+	var handled interface{}
+	var handledURL string
+	recovery := azqueue.NewPanicRecoveryPolicyFactory(func(recovered interface{}, request pipeline.Request) {
+		handled, handledURL = recovered, request.URL.Path
+	})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) { panic(bug) }, recovery)
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	panicErr, ok := err.(*azqueue.PanicError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(panicErr.Recovered, chk.Equals, bug)
+	c.Assert(errors.Is(err, bug), chk.Equals, true)
+	c.Assert(len(panicErr.Stack) > 0, chk.Equals, true)
+	c.Assert(handled, chk.Equals, bug)
+	c.Assert(handledURL, chk.Equals, "/fakequeue")
+
+	// A nil handler is allowed and a recovered value that isn't an error isn't unwrapped
+	p = newMockedPipeline(func(request pipeline.Request) (*http.Response, error) { panic("oops") },
+		azqueue.NewPanicRecoveryPolicyFactory(nil))
+	_, err = azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.ErrorMatches, "recovered from a panic while sending a request: oops")
+	c.Assert(errors.Unwrap(err), chk.IsNil)
+}
+
+func (s *queueSuite) TestPipelinePanicRecoveryOption(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+
+	// A log function that panics stands in for a buggy part of the pipeline
+	panickyLog := pipeline.LogOptions{
+		ShouldLog: func(level pipeline.LogLevel) bool { return true },
+		Log:       func(level pipeline.LogLevel, message string) { panic("log failed") },
+	}
+	handled := 0
+	p := azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(), azqueue.WithLog(panickyLog),
+		azqueue.WithPanicRecovery(azqueue.PanicRecoveryOptions{
+			Enabled: true,
+			Handler: func(recovered interface{}, request pipeline.Request) { handled++ },
+		}))
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.FitsTypeOf, &azqueue.PanicError{})
+	c.Assert(strings.Contains(err.Error(), "log failed"), chk.Equals, true)
+	c.Assert(handled, chk.Equals, 1) // The panic isn't retried
+
+	// Without the option, the panic propagates
+	p = azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(), azqueue.WithLog(panickyLog))
+	c.Assert(func() { azqueue.NewQueueURL(*u, p).GetProperties(ctx) }, chk.PanicMatches, "log failed")
+}
@@ -0,0 +1,117 @@
+package azqueue_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+// fakeClock is a RateLimiterClock whose time only moves when a test advances it or when a wait (After) completes.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+	block bool // If true, After never fires
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waits = append(f.waits, d)
+	ch := make(chan time.Time, 1)
+	if !f.block {
+		f.now = f.now.Add(d)
+		ch <- f.now
+	}
+	return ch
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (s *queueSuite) TestRateLimiter(c *chk.C) {
+	_, err := azqueue.NewRateLimiter(azqueue.RateLimiterOptions{})
+	c.Assert(err, chk.ErrorMatches, "OpsPerSecond must be > 0")
+	_, err = azqueue.NewRateLimiter(azqueue.RateLimiterOptions{OpsPerSecond: 1, Burst: -1})
+	c.Assert(err, chk.ErrorMatches, "Burst must be >= 0")
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter, err := azqueue.NewRateLimiter(azqueue.RateLimiterOptions{OpsPerSecond: 10, Burst: 2, Clock: clock})
+	c.Assert(err, chk.IsNil)
+
+	// The burst is available at once; then each token takes 100ms
+	for i := 0; i < 4; i++ {
+		c.Assert(limiter.Wait(ctx), chk.IsNil)
+	}
+	c.Assert(clock.waits, chk.DeepEquals, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond})
+
+	// Tokens accumulate while idle, up to the burst
+	clock.waits = nil
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		c.Assert(limiter.Wait(ctx), chk.IsNil)
+	}
+	c.Assert(clock.waits, chk.DeepEquals, []time.Duration{100 * time.Millisecond})
+
+	// A canceled wait returns the context's error and doesn't take the token
+	clock.waits, clock.block = nil, true
+	cancelCtx, cancel := context.WithCancel(ctx)
+	go func() { time.Sleep(10 * time.Millisecond); cancel() }()
+	c.Assert(limiter.Wait(cancelCtx), chk.Equals, context.Canceled)
+	c.Assert(limiter.Wait(cancelCtx), chk.Equals, context.Canceled) // Already canceled
+	c.Assert(clock.waits, chk.DeepEquals, []time.Duration{100 * time.Millisecond})
+	clock.block = false
+	c.Assert(limiter.Wait(ctx), chk.IsNil)
+	c.Assert(clock.waits, chk.DeepEquals, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond})
+}
+
+func (s *queueSuite) TestRateLimiterPipelines(c *chk.C) {
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter, _ := azqueue.NewRateLimiter(azqueue.RateLimiterOptions{OpsPerSecond: 100, Clock: clock})
+	retry := azqueue.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}
+
+	// Two pipelines share the limiter, and every try takes a token
+	u, _ := url.Parse(server.URL + "/queue")
+	p1 := azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(), azqueue.WithRateLimiter(limiter), azqueue.WithRetry(retry))
+	_, err := azqueue.NewQueueURL(*u, p1).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	busy, _ := url.Parse(server.URL + "/busy")
+	p2 := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{RateLimiter: limiter, Retry: retry})
+	_, err = azqueue.NewQueueURL(*busy, p2).GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 4)
+	c.Assert(clock.waits, chk.HasLen, 3) // The first try had the only token of the burst
+	for _, wait := range clock.waits {
+		c.Assert(wait, chk.Equals, 10*time.Millisecond)
+	}
+
+	// A pipeline created by changing options shares the limiter too
+	queueURL, err := azqueue.NewQueueURL(*u, p1).WithRetryOptions(azqueue.RetryOptions{MaxTries: 1})
+	c.Assert(err, chk.IsNil)
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(clock.waits, chk.HasLen, 4)
+}
@@ -0,0 +1,35 @@
+package azqueue_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestWithRequestHeaders(c *chk.C) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{}))
+
+	reqCtx := azqueue.WithRequestHeaders(context.Background(), http.Header{"X-Tenant-Id": {"contoso"}, "x-ms-version": {"1999-01-01"}})
+	reqCtx = azqueue.WithRequestHeaders(reqCtx, http.Header{"x-ms-client-request-id": {"my-request-id"}})
+	_, err := queueURL.GetProperties(reqCtx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(received.Get("X-Tenant-Id"), chk.Equals, "contoso")
+	c.Assert(received.Get("x-ms-client-request-id"), chk.Equals, "my-request-id")
+	c.Assert(received.Get("x-ms-version"), chk.Equals, azqueue.ServiceVersion) // Headers set by the package are never replaced
+
+	// Operations called without the context don't get the headers
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(received.Get("X-Tenant-Id"), chk.Equals, "")
+	c.Assert(received.Get("x-ms-client-request-id"), chk.Not(chk.Equals), "my-request-id")
+}
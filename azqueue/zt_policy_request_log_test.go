@@ -0,0 +1,123 @@
+package azqueue_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestRequestLogRedactsSensitiveValues(c *chk.C) {
+	var sent *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue?sv=2018-03-28&sig=secretsig&Token=secrettoken&comp=metadata")
+
+	credential, err := azqueue.NewSharedKeyCredential("account", base64.StdEncoding.EncodeToString(make([]byte, 64)))
+	c.Assert(err, chk.IsNil)
+	logged := &strings.Builder{}
+	p := azqueue.NewPipeline(credential, azqueue.PipelineOptions{
+		RequestLog: azqueue.RequestLogOptions{SensitiveQueryParams: []string{"token"}, SensitiveHeaders: []string{"X-Api-Key"}},
+		Log: pipeline.LogOptions{
+			ShouldLog: func(level pipeline.LogLevel) bool { return true },
+			Log:       func(level pipeline.LogLevel, message string) { logged.WriteString(message) },
+		},
+	})
+	ctxWithHeader := azqueue.WithRequestHeaders(ctx, http.Header{"X-Api-Key": {"secretkey"}})
+	_, err = azqueue.NewQueueURL(*u, p).GetProperties(ctxWithHeader)
+	c.Assert(err, chk.IsNil)
+
+	log := logged.String()
+	c.Assert(strings.Contains(log, "secret"), chk.Equals, false)
+	c.Assert(strings.Contains(log, "sig=[REDACTED]"), chk.Equals, true)
+	c.Assert(strings.Contains(log, "Token=[REDACTED]"), chk.Equals, true)
+	c.Assert(strings.Contains(log, "X-Api-Key: "), chk.Equals, true) // Its value is redacted
+
+	// The request that was sent is unchanged
+	c.Assert(sent.URL.Query().Get("sig"), chk.Equals, "secretsig")
+	c.Assert(sent.URL.Query().Get("Token"), chk.Equals, "secrettoken")
+	c.Assert(sent.Header.Get("X-Api-Key"), chk.Equals, "secretkey")
+	c.Assert(strings.HasPrefix(sent.Header.Get("Authorization"), "SharedKey account:"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestStorageErrorRedactsPipelineSensitiveValues(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", string(azqueue.ServiceCodeQueueNotFound))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue?sv=2018-03-28&sig=secretsig&Token=secrettoken")
+
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{
+		RequestLog: azqueue.RequestLogOptions{SensitiveQueryParams: []string{"token"}, SensitiveHeaders: []string{"X-Api-Key"}},
+	})
+	ctxWithHeader := azqueue.WithRequestHeaders(ctx, http.Header{"X-Api-Key": {"secretkey"}})
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctxWithHeader)
+	c.Assert(err, chk.NotNil)
+
+	// The error's description of the request redacts what the pipeline's request log does, with the same placeholder
+	msg := err.Error()
+	c.Assert(strings.Contains(msg, "secret"), chk.Equals, false)
+	c.Assert(strings.Contains(msg, "sig=[REDACTED]"), chk.Equals, true)
+	c.Assert(strings.Contains(msg, "Token=[REDACTED]"), chk.Equals, true)
+	c.Assert(strings.Contains(msg, "X-Api-Key: [[REDACTED]]"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestRequestLogIncludesOperation(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", string(azqueue.ServiceCodeQueueNotFound))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/orders")
+
+	logged := &strings.Builder{}
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{
+		Log: pipeline.LogOptions{
+			ShouldLog: func(level pipeline.LogLevel) bool { return true },
+			Log:       func(level pipeline.LogLevel, message string) { logged.WriteString(message) },
+		},
+	})
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+
+	log := logged.String()
+	c.Assert(strings.Contains(log, "==> OUTGOING REQUEST (Operation=GetQueueProperties, Queue=orders, Try=1)"), chk.Equals, true)
+	c.Assert(strings.Contains(log, "==> REQUEST/RESPONSE (Operation=GetQueueProperties, Queue=orders, Try=1/"), chk.Equals, true)
+	c.Assert(strings.Contains(err.Error(), "\nOperation=GetQueueProperties, Queue=orders\n"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestOperationName(c *chk.C) {
+	var operations []string
+	recorder := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			operations = append(operations, azqueue.OperationName(ctx))
+			return next.Do(ctx, request)
+		}
+	})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPost {
+			return newMockedEnqueueResponse(), nil
+		}
+		return newMockedResponse(http.StatusNoContent, nil), nil
+	}, recorder)
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/orders")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	_, err := queueURL.NewMessagesURL().Enqueue(ctx, "text", 0, 0)
+	c.Assert(err, chk.IsNil)
+	_, err = queueURL.NewMessagesURL().NewMessageIDURL("id").Delete(ctx, "pr")
+	c.Assert(err, chk.IsNil)
+	_, err = queueURL.Delete(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(operations, chk.DeepEquals, []string{"Enqueue", "DeleteMessage", "DeleteQueue"})
+	c.Assert(azqueue.OperationName(ctx), chk.Equals, "")
+}
@@ -2,9 +2,11 @@ package azqueue_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"time"
@@ -37,6 +39,16 @@ func (s *queueSuite) TestRetryTestScenarioUntilOperationCancel(c *chk.C) {
 func (s *queueSuite) TestRetryTestScenarioUntilMaxRetries(c *chk.C) {
 	testRetryTestScenario(c, retryTestScenarioRetryUntilMaxRetries)
 }
+
+func (s *queueSuite) TestServiceCodeIsRetryable(c *chk.C) {
+	c.Assert(azqueue.ServiceCodeServerBusy.IsRetryable(), chk.Equals, true)
+	c.Assert(azqueue.ServiceCodeInternalError.IsRetryable(), chk.Equals, true)
+	c.Assert(azqueue.ServiceCodeOperationTimedOut.IsRetryable(), chk.Equals, true)
+	c.Assert(azqueue.ServiceCodeAuthorizationFailure.IsRetryable(), chk.Equals, false)
+	c.Assert(azqueue.ServiceCodeQueueNotFound.IsRetryable(), chk.Equals, false)
+	c.Assert(azqueue.ServiceCodeNone.IsRetryable(), chk.Equals, false)
+}
+
 func newRetryTestPolicyFactory(c *chk.C, scenario retryTestScenario, maxRetries int32, cancel context.CancelFunc) *retryTestPolicyFactory {
 	return &retryTestPolicyFactory{c: c, scenario: scenario, maxRetries: maxRetries, cancel: cancel}
 }
@@ -202,3 +214,208 @@ func testRetryTestScenario(c *chk.C, scenario retryTestScenario) {
    	error where Temporary() & Timeout don't exist; no retry
     no error; no retry; return success, nil
 */
+
+func (s *queueSuite) TestWithRetryOptions(c *chk.C) {
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		w.Header().Set("x-ms-error-code", string(azqueue.ServiceCodeServerBusy))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	retry := azqueue.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 3)
+
+	// Only the derived URL's pipeline uses the new retry options
+	tries = 0
+	retry.MaxTries = 1
+	derived, err := queueURL.WithRetryOptions(retry)
+	c.Assert(err, chk.IsNil)
+	_, err = derived.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 1)
+	tries = 0
+	_, err = queueURL.NewMessagesURL().Peek(ctx, 1)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 3)
+
+	// A pipeline built from a custom list of factories can't be reconfigured; the URL is returned unchanged
+	_, histogram := azqueue.NewLatencyHistogramPolicyFactory(nil)
+	custom := pipeline.NewPipeline([]pipeline.Factory{histogram, pipeline.MethodFactoryMarker()}, pipeline.Options{})
+	customURL := azqueue.NewQueueURL(*u, custom)
+	same, err := customURL.WithRetryOptions(retry)
+	c.Assert(err, chk.Equals, azqueue.ErrPipelineOptionsUnknown)
+	c.Assert(same, chk.DeepEquals, customURL)
+	_, err = customURL.NewMessagesURL().NewMessageIDURL("id").WithRequestLogOptions(azqueue.RequestLogOptions{})
+	c.Assert(err, chk.Equals, azqueue.ErrPipelineOptionsUnknown)
+	_, err = customURL.ServiceURL().WithRequestLogOptions(azqueue.RequestLogOptions{})
+	c.Assert(err, chk.Equals, azqueue.ErrPipelineOptionsUnknown)
+}
+
+func (s *queueSuite) TestWithExponentialBackoff(c *chk.C) {
+	o := azqueue.RetryOptions{Policy: azqueue.RetryPolicyFixed, MaxTries: 3}.WithExponentialBackoff(azqueue.BackoffOptions{
+		InitialDelay: 20 * time.Millisecond, MaxDelay: 30 * time.Millisecond, Multiplier: 3, Jitter: 0.1})
+	c.Assert(o.Policy, chk.Equals, azqueue.RetryPolicyExponential)
+	c.Assert(o.MaxTries, chk.Equals, int32(3))
+	c.Assert(o.RetryDelay, chk.Equals, 20*time.Millisecond)
+	c.Assert(o.MaxRetryDelay, chk.Equals, 30*time.Millisecond)
+
+	tries := []time.Time{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries = append(tries, time.Now())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: o}))
+
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.HasLen, 3)
+	// The first retry waits about InitialDelay; the second would wait 60ms but is limited to MaxDelay
+	c.Assert(tries[1].Sub(tries[0]) >= 18*time.Millisecond, chk.Equals, true)
+	c.Assert(tries[2].Sub(tries[1]) >= 30*time.Millisecond, chk.Equals, true)
+	c.Assert(tries[2].Sub(tries[1]) < 60*time.Millisecond, chk.Equals, true)
+}
+
+func (s *queueSuite) TestRetryOptionsContextOverride(c *chk.C) {
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	retry := azqueue.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+
+	// Options in the context override the pipeline's options
+	_, err := queueURL.GetProperties(azqueue.WithRetryOptions(ctx, azqueue.RetryOptions{MaxTries: 1}))
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 1)
+
+	// Without options in the context, the pipeline's options are used
+	tries = 0
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 3)
+}
+
+func (s *queueSuite) TestMaxRetryBudget(c *chk.C) {
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	retry := azqueue.RetryOptions{MaxTries: 10, MaxRetryBudget: 250 * time.Millisecond}.WithExponentialBackoff(
+		azqueue.BackoffOptions{InitialDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Multiplier: 1, Jitter: 0.001})
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+
+	// The 4th try would start about 300ms after the 1st, so it isn't attempted
+	start := time.Now()
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(time.Since(start) < 250*time.Millisecond, chk.Equals, true)
+	c.Assert(tries, chk.Equals, 3)
+	budgetErr, ok := err.(*azqueue.RetryBudgetExceededError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(budgetErr.Budget, chk.Equals, 250*time.Millisecond)
+	c.Assert(budgetErr.Tries, chk.Equals, int32(3))
+	c.Assert(err, chk.ErrorMatches, "(?s)retry budget of 250ms exhausted after 3 tries in .*503.*")
+	var stgErr azqueue.StorageError
+	c.Assert(errors.As(err, &stgErr), chk.Equals, true)
+	c.Assert(stgErr.Response().StatusCode, chk.Equals, http.StatusServiceUnavailable)
+
+	// A stricter context deadline ends the operation first
+	retry.MaxRetryBudget = time.Minute
+	queueURL = azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+	deadlineCtx, cancel := context.WithTimeout(ctx, 1100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = queueURL.GetProperties(deadlineCtx)
+	c.Assert(time.Since(start) < 2*time.Second, chk.Equals, true)
+	c.Assert(err, chk.NotNil)
+	c.Assert(err, chk.Not(chk.FitsTypeOf), &azqueue.RetryBudgetExceededError{})
+}
+
+func (s *queueSuite) TestResponseAttemptsAndTotalLatency(c *chk.C) {
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		switch {
+		case tries%3 != 0:
+			w.WriteHeader(http.StatusServiceUnavailable) // Succeed every 3rd try
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `<QueueMessagesList><QueueMessage><MessageId>id</MessageId><PopReceipt>pr</PopReceipt></QueueMessage></QueueMessagesList>`)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	retry := azqueue.RetryOptions{Policy: azqueue.RetryPolicyFixed, MaxTries: 3, RetryDelay: 20 * time.Millisecond, MaxRetryDelay: 20 * time.Millisecond}
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+
+	props, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(azqueue.ResponseAttempts(props), chk.Equals, int32(3))
+	c.Assert(azqueue.ResponseTotalLatency(props) >= 2*16*time.Millisecond, chk.Equals, true) // Includes both delays (with jitter)
+	c.Assert(azqueue.ResponseTotalLatency(props) < 5*time.Second, chk.Equals, true)
+
+	// Wrapped responses return the same values
+	enqueue, err := queueURL.NewMessagesURL().Enqueue(ctx, "m", 0, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(azqueue.ResponseAttempts(enqueue), chk.Equals, int32(3))
+	c.Assert(azqueue.ResponseTotalLatency(enqueue) > 0, chk.Equals, true)
+
+	// A response that didn't pass through a retry policy has no values
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusOK, nil), nil
+	})
+	props, err = azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(azqueue.ResponseAttempts(props), chk.Equals, int32(0))
+	c.Assert(azqueue.ResponseTotalLatency(props), chk.Equals, time.Duration(0))
+}
+
+func (s *queueSuite) TestMaxRetryBudgetKeepsStorageErrors(c *chk.C) {
+	failures, tries := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tries++; tries <= failures {
+			w.Header().Set("x-ms-error-code", string(azqueue.ServiceCodeOperationTimedOut))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	retry := azqueue.RetryOptions{Policy: azqueue.RetryPolicyFixed, MaxTries: 10, RetryDelay: 30 * time.Millisecond,
+		MaxRetryDelay: 30 * time.Millisecond, MaxRetryBudget: 50 * time.Millisecond}
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{Retry: retry}))
+
+	// ClearAll recognizes OperationTimedOut after the budget ends Clear's retries, and calls Clear again
+	failures = 5
+	calls, err := queueURL.NewMessagesURL().ClearAll(ctx, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(calls > 1, chk.Equals, true)
+	c.Assert(tries, chk.Equals, 6)
+
+	// A budget-exhausted error still reports its service code
+	tries, failures = 0, 100
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.FitsTypeOf, &azqueue.RetryBudgetExceededError{})
+	var stgErr azqueue.StorageError
+	c.Assert(errors.As(err, &stgErr), chk.Equals, true)
+	c.Assert(stgErr.ServiceCode(), chk.Equals, azqueue.ServiceCodeOperationTimedOut)
+
+	// ClearAll returns the wrapped error once maxWait has elapsed
+	tries = 0
+	_, err = queueURL.NewMessagesURL().ClearAll(ctx, 0)
+	c.Assert(err, chk.FitsTypeOf, &azqueue.RetryBudgetExceededError{})
+}
@@ -0,0 +1,54 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestServiceVersionOverride(c *chk.C) {
+	var version string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.Header.Get("x-ms-version")
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{}))
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(version, chk.Equals, azqueue.ServiceVersion)
+
+	queueURL = azqueue.NewQueueURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(), azqueue.PipelineOptions{ServiceVersion: "2099-01-01"}))
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(version, chk.Equals, "2099-01-01")
+
+	// The override is kept when other pipeline options change
+	queueURL, err = queueURL.WithRetryOptions(azqueue.RetryOptions{MaxTries: 1})
+	c.Assert(err, chk.IsNil)
+	queueURL.NewMessagesURL().Peek(ctx, 1)
+	c.Assert(version, chk.Equals, "2099-01-01")
+}
+
+func (s *queueSuite) TestServiceVersionValidation(c *chk.C) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { requests++ }))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+
+	for _, version := range []string{"2019-02-30", "2019-2-2", "20190202", "latest", "2019-02-02x"} {
+		queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(), azqueue.WithServiceVersion(version)))
+		_, err := queueURL.GetProperties(ctx)
+		c.Assert(err, chk.ErrorMatches, `service version ".*" must be a date in yyyy-mm-dd format`)
+	}
+	c.Assert(requests, chk.Equals, 0) // Invalid versions are never sent (or retried)
+
+	queueURL := azqueue.NewQueueURL(*u, azqueue.NewPipelineWithOptions(azqueue.NewAnonymousCredential(), azqueue.WithServiceVersion("2017-11-09")))
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(requests, chk.Equals, 1)
+}
@@ -0,0 +1,50 @@
+package azqueue_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestTelemetryUserAgent(c *chk.C) {
+	sdk := "azqueue/" + azqueue.SDKVersion + " (" + runtime.Version() + "; " + runtime.GOOS + ")"
+	testCases := []struct {
+		o         azqueue.TelemetryOptions
+		userAgent string
+	}{
+		{azqueue.TelemetryOptions{}, sdk},
+		{azqueue.TelemetryOptions{Value: "myapp/1.2"}, "myapp/1.2 " + sdk},
+		{azqueue.TelemetryOptions{Value: "myapp/1.2", UserAgent: "locked-down"}, "locked-down"},
+	}
+	for _, tc := range testCases {
+		var userAgent string
+		p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+			userAgent = request.Header.Get("User-Agent")
+			return newMockedResponse(http.StatusOK, nil), nil
+		}, azqueue.NewTelemetryPolicyFactory(tc.o))
+		u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+		_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+		c.Assert(err, chk.IsNil)
+		c.Assert(userAgent, chk.Equals, tc.userAgent)
+	}
+}
+
+func (s *queueSuite) TestTelemetryDisabledSendsNoUserAgent(c *chk.C) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue")
+	p := azqueue.NewPipeline(azqueue.NewAnonymousCredential(),
+		azqueue.PipelineOptions{Telemetry: azqueue.TelemetryOptions{Value: "myapp/1.2", DisableTelemetry: true}})
+	_, err := azqueue.NewQueueURL(*u, p).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	_, sent := received["User-Agent"]
+	c.Assert(sent, chk.Equals, false)
+}
@@ -0,0 +1,84 @@
+package azqueue_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestQueueSemaphore(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	messagesURL := q.NewMessageIDURL("id").MessagesURL() // Backed by the in-memory queue
+
+	_, err := azqueue.NewQueueSemaphore(messagesURL, 0, time.Minute)
+	c.Assert(err, chk.NotNil)
+	_, err = azqueue.NewQueueSemaphore(messagesURL, 1, time.Second)
+	c.Assert(err, chk.NotNil)
+
+	sem, err := azqueue.NewQueueSemaphore(messagesURL, 2, 2*time.Second)
+	c.Assert(err, chk.IsNil)
+	c.Assert(sem.Initialize(ctx), chk.IsNil)
+
+	p1, err := sem.Acquire(ctx)
+	c.Assert(err, chk.IsNil)
+	p2, err := sem.Acquire(ctx)
+	c.Assert(err, chk.IsNil)
+
+	// No permits are left, even after the lease timeout, since the holders renew their leases
+	waitCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err = sem.Acquire(waitCtx)
+	c.Assert(err, chk.Equals, context.DeadlineExceeded)
+
+	// A released permit can be acquired again
+	c.Assert(p1.Release(ctx), chk.IsNil)
+	p3, err := sem.Acquire(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(p2.Release(ctx), chk.IsNil)
+	c.Assert(p3.Release(ctx), chk.IsNil)
+
+	peek, err := q.Peek(ctx, 32)
+	c.Assert(err, chk.IsNil)
+	c.Assert(peek.NumMessages(), chk.Equals, int32(2))
+}
+
+// fakeSemaphoreClient is a fakeMessagesClient whose MessageIDURLs use a fakeMessageIDClient.
+type fakeSemaphoreClient struct {
+	fakeMessagesClient
+	messageID *fakeMessageIDClient
+}
+
+func (f *fakeSemaphoreClient) NewMessageIDClient(messageID azqueue.MessageID) azqueue.MessageIDClient {
+	return f.messageID
+}
+
+// fakeMessageIDClient implements azqueue.MessageIDClient's ExtendVisibility method without a pipeline by recording its
+// arguments and returning err; calling any other method panics.
+type fakeMessageIDClient struct {
+	azqueue.MessageIDClient
+	err               error
+	popReceipt        string
+	visibilityTimeout int32
+}
+
+func (f *fakeMessageIDClient) ExtendVisibility(ctx context.Context, popReceipt string, visibilitytimeout int32, timeout *int32, requestID *string) (*azqueue.MessageIDUpdateResponse, error) {
+	f.popReceipt, f.visibilityTimeout = popReceipt, visibilitytimeout
+	return nil, f.err
+}
+
+func (s *queueSuite) TestSemaphorePermitReleaseExtendsVisibility(c *chk.C) {
+	client := &fakeSemaphoreClient{messageID: &fakeMessageIDClient{err: errors.New("release failed")}}
+	sem, err := azqueue.NewQueueSemaphore(azqueue.NewMessagesURLFromClient(client), 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	permit, err := sem.Acquire(ctx)
+	c.Assert(err, chk.IsNil)
+
+	// Releasing makes the permit's message visible immediately using the pop receipt it was acquired with
+	c.Assert(permit.Release(ctx), chk.Equals, client.messageID.err)
+	c.Assert(client.messageID.popReceipt, chk.Equals, "pr")
+	c.Assert(client.messageID.visibilityTimeout, chk.Equals, int32(0))
+}
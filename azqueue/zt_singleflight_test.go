@@ -0,0 +1,133 @@
+package azqueue_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestSingleFlightQueueURL(c *chk.C) {
+	var requests int32
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		started <- struct{}{}
+		<-release
+		header := http.Header{}
+		header.Set("x-ms-approximate-messages-count", "7")
+		return newMockedResponse(http.StatusOK, header), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewSingleFlightQueueURL(azqueue.NewQueueURL(*u, p))
+
+	// Concurrent calls share one request
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			props, err := queueURL.GetProperties(ctx)
+			c.Check(err, chk.IsNil)
+			c.Check(props.ApproximateMessagesCount(), chk.Equals, int32(7))
+		}()
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond) // Let the other calls start waiting
+	close(release)
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&requests), chk.Equals, int32(1))
+
+	// A call made after the shared one completed sends a new request
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(atomic.LoadInt32(&requests), chk.Equals, int32(2))
+}
+
+func (s *queueSuite) TestSingleFlightQueueURLStarterCanceled(c *chk.C) {
+	var requests int32
+	gaveUp := make(chan struct{})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-gaveUp // The first request lasts until its caller gives up
+			return nil, context.Canceled
+		}
+		return newMockedResponse(http.StatusOK, nil), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewSingleFlightQueueURL(azqueue.NewQueueURL(*u, p))
+
+	starterCtx, cancel := context.WithCancel(ctx)
+	starterErr := make(chan error)
+	go func() {
+		_, err := queueURL.GetProperties(starterCtx)
+		starterErr <- err
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiterErr := make(chan error)
+	go func() {
+		_, err := queueURL.GetProperties(ctx)
+		waiterErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // Let the second call start waiting
+	cancel()
+	close(gaveUp)
+
+	// The starter's cancellation doesn't fail the waiting call, which sends its own request instead
+	c.Assert(<-starterErr, chk.NotNil)
+	c.Assert(<-waiterErr, chk.IsNil)
+	c.Assert(atomic.LoadInt32(&requests), chk.Equals, int32(2))
+}
+
+func (s *queueSuite) TestSingleFlightQueueURLStarterPanics(c *chk.C) {
+	var requests int32
+	release := make(chan struct{})
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+			panic("sender failed")
+		}
+		return newMockedResponse(http.StatusOK, nil), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewSingleFlightQueueURL(azqueue.NewQueueURL(*u, p))
+
+	starterPanic := make(chan interface{})
+	go func() {
+		defer func() { starterPanic <- recover() }()
+		queueURL.GetProperties(ctx)
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiterErr := make(chan error)
+	go func() {
+		_, err := queueURL.GetProperties(ctx)
+		waiterErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // Let the second call start waiting
+	close(release)
+
+	// The panic reaches the starter, and the waiting call is released with an error rather than blocking forever
+	c.Assert(<-starterPanic, chk.Equals, "sender failed")
+	select {
+	case err := <-waiterErr:
+		c.Assert(err, chk.ErrorMatches, "the shared call panicked")
+	case <-time.After(5 * time.Second):
+		c.Fatal("the waiting call was not released")
+	}
+
+	// Later calls send their own request
+	_, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(atomic.LoadInt32(&requests), chk.Equals, int32(2))
+}
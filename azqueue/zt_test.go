@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-queue-go/azqueue"
 	chk "gopkg.in/check.v1"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"runtime"
@@ -35,7 +38,17 @@ func getGenericCredential(accountType string) (*azqueue.SharedKeyCredential, err
 }
 
 func getGenericQueueServiceURL() (azqueue.ServiceURL, error) {
-	credential, err := getGenericCredential("")
+	return getAccountQueueServiceURL("")
+}
+
+// getSecondaryQueueServiceURL returns a ServiceURL for a second storage account specified by the
+// SECONDARY_ACCOUNT_NAME and SECONDARY_ACCOUNT_KEY environment variables.
+func getSecondaryQueueServiceURL() (azqueue.ServiceURL, error) {
+	return getAccountQueueServiceURL("SECONDARY_")
+}
+
+func getAccountQueueServiceURL(accountType string) (azqueue.ServiceURL, error) {
+	credential, err := getGenericCredential(accountType)
 	if err != nil {
 		return azqueue.ServiceURL{}, err
 	}
@@ -94,6 +107,61 @@ func deleteQueue(c *chk.C, qsu azqueue.QueueURL) {
 	c.Assert(resp.StatusCode(), chk.Equals, 204)
 }
 
+// newMockedPipeline creates a pipeline whose HTTP sender calls respond instead of sending the request over the network.
+// This allows testing response processing without a storage account. The pipeline's policies are created by factories
+// (if any), in order, followed by the method policy.
+func newMockedPipeline(respond func(request pipeline.Request) (*http.Response, error), factories ...pipeline.Factory) pipeline.Pipeline {
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			response, err := respond(request)
+			if err != nil {
+				return nil, err
+			}
+			response.Request = request.Request
+			if response.Body == nil {
+				response.Body = http.NoBody
+			}
+			return pipeline.NewHTTPResponse(response), nil
+		}
+	})
+	factories = append(append([]pipeline.Factory{}, factories...), pipeline.MethodFactoryMarker())
+	return pipeline.NewPipeline(factories, pipeline.Options{HTTPSender: sender})
+}
+
+// newMockedResponse creates an HTTP response with the specified status code and headers.
+func newMockedResponse(statusCode int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: statusCode, Status: http.StatusText(statusCode), Header: header, Body: http.NoBody}
+}
+
+// newMockedEnqueueResponse creates a successful Enqueue response.
+func newMockedEnqueueResponse() *http.Response {
+	const now = "Mon, 02 Jan 2006 15:04:05 GMT"
+	resp := newMockedResponse(http.StatusCreated, nil)
+	resp.Body = ioutil.NopCloser(strings.NewReader(fmt.Sprintf("<QueueMessagesList><QueueMessage><MessageId>id</MessageId>"+
+		"<InsertionTime>%s</InsertionTime><ExpirationTime>%s</ExpirationTime><PopReceipt>pr</PopReceipt>"+
+		"<TimeNextVisible>%s</TimeNextVisible></QueueMessage></QueueMessagesList>", now, now, now)))
+	return resp
+}
+
+// newMockedDequeueResponse creates a successful Dequeue response containing the specified message texts.
+func newMockedDequeueResponse(texts ...string) *http.Response {
+	const now = "Mon, 02 Jan 2006 15:04:05 GMT"
+	b := &strings.Builder{}
+	b.WriteString("<QueueMessagesList>")
+	for i, text := range texts {
+		fmt.Fprintf(b, "<QueueMessage><MessageId>id%d</MessageId><InsertionTime>%s</InsertionTime><ExpirationTime>%s</ExpirationTime>"+
+			"<PopReceipt>pr%d</PopReceipt><TimeNextVisible>%s</TimeNextVisible><DequeueCount>1</DequeueCount><MessageText>%s</MessageText></QueueMessage>",
+			i, now, now, i, now, text)
+	}
+	b.WriteString("</QueueMessagesList>")
+	resp := newMockedResponse(http.StatusOK, nil)
+	resp.Body = ioutil.NopCloser(strings.NewReader(b.String()))
+	return resp
+}
+
 /*
 Add 204 to Create Queue success status codes
 
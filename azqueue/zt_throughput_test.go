@@ -0,0 +1,47 @@
+package azqueue_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestThroughputMonitor(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	monitor := azqueue.NewThroughputMonitor(q.NewMessageIDURL("id").MessagesURL(), 20*time.Millisecond)
+	monitor.Start(ctx)
+	defer monitor.Stop()
+	waitFor := func(condition func(azqueue.ThroughputStats) bool) azqueue.ThroughputStats {
+		deadline := time.Now().Add(5 * time.Second)
+		for stats := monitor.CurrentStats(); ; stats = monitor.CurrentStats() {
+			if condition(stats) {
+				return stats
+			}
+			c.Assert(time.Now().Before(deadline), chk.Equals, true)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(func(stats azqueue.ThroughputStats) bool { return !stats.SampledAt.IsZero() })
+
+	for i := 0; i < 10; i++ {
+		_, err := q.Enqueue(ctx, fmt.Sprint(i), 0, 0)
+		c.Assert(err, chk.IsNil)
+	}
+	stats := waitFor(func(stats azqueue.ThroughputStats) bool { return stats.ApproximateMessagesCount == 10 })
+	c.Assert(stats.EnqueueRate > 0, chk.Equals, true)
+	c.Assert(stats.DequeueRate, chk.Equals, 0.0)
+	c.Assert(monitor.EnqueueRate(), chk.Equals, stats.EnqueueRate)
+
+	_, err := q.Clear(ctx)
+	c.Assert(err, chk.IsNil)
+	stats = waitFor(func(stats azqueue.ThroughputStats) bool { return stats.ApproximateMessagesCount == 0 })
+	c.Assert(stats.DequeueRate > 0, chk.Equals, true)
+	c.Assert(stats.EnqueueRate, chk.Equals, 0.0)
+
+	// After Stop, the last stats remain available
+	monitor.Stop()
+	c.Assert(monitor.CurrentStats().SampledAt.IsZero(), chk.Equals, false)
+}
@@ -0,0 +1,136 @@
+package azqueue_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func (s *queueSuite) TestDeleteIfExists(c *chk.C) {
+	var status int
+	var code string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		header := http.Header{}
+		if code != "" {
+			header.Set("x-ms-error-code", code)
+		}
+		return newMockedResponse(status, header), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages/fakeid")
+	messageIDURL := azqueue.NewMessageIDURL(*u, p)
+
+	status, code = http.StatusNoContent, ""
+	deleted, err := messageIDURL.DeleteIfExists(ctx, azqueue.PopReceipt("pr"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(deleted, chk.Equals, true)
+
+	status, code = http.StatusNotFound, string(azqueue.ServiceCodeMessageNotFound)
+	deleted, err = messageIDURL.DeleteIfExists(ctx, azqueue.PopReceipt("pr"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(deleted, chk.Equals, false)
+
+	status, code = http.StatusBadRequest, string(azqueue.ServiceCodePopReceiptMismatch)
+	deleted, err = messageIDURL.DeleteIfExists(ctx, azqueue.PopReceipt("pr"))
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodePopReceiptMismatch)
+	c.Assert(deleted, chk.Equals, false)
+}
+
+func (s *queueSuite) TestIsMessageNotFoundAndIsPopReceiptMismatchWrapped(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusBadRequest, http.Header{"X-Ms-Error-Code": []string{"PopReceiptMismatch"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages/fakeid")
+	_, err := azqueue.NewMessageIDURL(*u, p).Delete(ctx, azqueue.PopReceipt("pr"))
+
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, true)
+	c.Assert(azqueue.IsMessageNotFound(err), chk.Equals, false)
+	c.Assert(azqueue.IsPopReceiptMismatch(fmt.Errorf("deleting message: %w", err)), chk.Equals, true)
+	c.Assert(azqueue.IsPopReceiptMismatch(pipeline.NewError(err, "deleting message")), chk.Equals, true)
+	c.Assert(azqueue.IsPopReceiptMismatch(errors.New("PopReceiptMismatch")), chk.Equals, false)
+	c.Assert(azqueue.IsPopReceiptMismatch(nil), chk.Equals, false)
+}
+
+func (s *queueSuite) TestStalePopReceipt(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+	messagesURL := queueURL.NewMessagesURL()
+
+	_, err := messagesURL.Enqueue(ctx, "message", 0, 0)
+	c.Assert(err, chk.IsNil)
+
+	// Dequeue the message twice, letting the first visibility timeout expire, so the first pop receipt becomes stale
+	first, err := messagesURL.Dequeue(ctx, 1, time.Second)
+	c.Assert(err, chk.IsNil)
+	c.Assert(first.NumMessages(), chk.Equals, int32(1))
+	time.Sleep(2 * time.Second)
+	second, err := messagesURL.Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(second.NumMessages(), chk.Equals, int32(1))
+
+	msg := first.Message(0)
+	_, err = messagesURL.NewMessageIDURL(msg.ID).Update(ctx, msg.PopReceipt, 0, "stale")
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, true)
+	c.Assert(azqueue.IsMessageNotFound(err), chk.Equals, false)
+
+	// Once the current owner deletes the message, the message is gone for everyone
+	msg = second.Message(0)
+	_, err = messagesURL.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt)
+	c.Assert(err, chk.IsNil)
+	_, err = messagesURL.NewMessageIDURL(msg.ID).Delete(ctx, msg.PopReceipt)
+	c.Assert(azqueue.IsMessageNotFound(err), chk.Equals, true)
+	c.Assert(azqueue.IsPopReceiptMismatch(err), chk.Equals, false)
+}
+
+func (s *queueSuite) TestExtendVisibility(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		c.Assert(request.Method, chk.Equals, http.MethodPut)
+		c.Assert(request.Body, chk.IsNil) // No body means the message's content is unchanged
+		c.Assert(request.URL.Query().Get("popreceipt"), chk.Equals, "pr")
+		c.Assert(request.URL.Query().Get("visibilitytimeout"), chk.Equals, "60")
+		return newMockedResponse(http.StatusNoContent, http.Header{"X-Ms-Popreceipt": []string{"pr2"},
+			"X-Ms-Time-Next-Visible": []string{"Mon, 02 Jan 2006 07:05:05 -0800"}}), nil // Some emulators send RFC1123Z
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages/fakeid")
+
+	resp, err := azqueue.NewMessageIDURL(*u, p).ExtendVisibility(ctx, azqueue.PopReceipt("pr"), time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.PopReceipt, chk.Equals, azqueue.PopReceipt("pr2"))
+	c.Assert(resp.TimeNextVisible, chk.Equals, time.Date(2006, time.January, 2, 15, 5, 5, 0, time.UTC))
+
+	// Like Update, a visibility timeout longer than 7 days is rejected without sending a request
+	_, err = azqueue.NewMessageIDURL(*u, p).ExtendVisibility(ctx, azqueue.PopReceipt("pr"), 8*24*time.Hour)
+	c.Assert(err, chk.ErrorMatches, "(?s).*visibilitytimeout.*")
+}
+
+func (s *queueSuite) TestMessageURLsWithPipeline(c *chk.C) {
+	var used []string
+	newPipeline := func(name string) pipeline.Pipeline {
+		return newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+			used = append(used, name)
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		})
+	}
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, newPipeline("original"))
+	messageIDURL := messagesURL.NewMessageIDURL("id")
+
+	// Message operations can use a different pipeline than the one they were created with, without changing the URL
+	switched := messagesURL.WithPipeline(newPipeline("messages"))
+	c.Assert(switched.URL(), chk.DeepEquals, messagesURL.URL())
+	_, err := switched.Clear(ctx)
+	c.Assert(err, chk.IsNil)
+	switchedID := messageIDURL.WithPipeline(newPipeline("messageID"))
+	c.Assert(switchedID.URL(), chk.DeepEquals, messageIDURL.URL())
+	_, err = switchedID.Delete(ctx, "popreceipt")
+	c.Assert(err, chk.IsNil)
+	_, err = messageIDURL.Delete(ctx, "popreceipt")
+	c.Assert(err, chk.IsNil)
+	c.Assert(used, chk.DeepEquals, []string{"messages", "messageID", "original"})
+}
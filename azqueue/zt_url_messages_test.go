@@ -1,8 +1,19 @@
 package azqueue_test
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-queue-go/azqueue"
 	chk "gopkg.in/check.v1"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -71,3 +82,420 @@ func (s *queueSuite) TestEnqueue(c *chk.C) {
 	messagesURL2 := queueURL2.NewMessagesURL()
 	validateEnqueueError(c, messagesURL2, "testContent", 0, 0, "QueueNotFound")
 }
+
+func (s *queueSuite) TestDequeueCount(c *chk.C) {
+	// setup
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+	messagesURL := queueURL.NewMessagesURL()
+	_, err := messagesURL.Enqueue(ctx, "poison", 0, 0)
+	c.Assert(err, chk.IsNil)
+
+	// dequeue the message without deleting it; it becomes visible again when its visibility timeout expires
+	const n = 3
+	var msg *azqueue.DequeuedMessage
+	for i := 1; i <= n; i++ {
+		msg = dequeueVisibleMessage(c, messagesURL)
+		c.Assert(msg.Text, chk.Equals, "poison")
+		c.Assert(msg.DequeueCount, chk.Equals, int64(i))
+	}
+	c.Assert(msg.DequeueCount, chk.Equals, int64(n))
+
+	// one more dequeue increments the count past n, as the poison message pattern in Example relies on
+	msg = dequeueVisibleMessage(c, messagesURL)
+	c.Assert(msg.DequeueCount, chk.Equals, int64(n+1))
+}
+
+// dequeueVisibleMessage dequeues a single message with a 1 second visibility timeout, waiting for it to become visible.
+func dequeueVisibleMessage(c *chk.C, messagesURL azqueue.MessagesURL) *azqueue.DequeuedMessage {
+	for start := time.Now(); time.Since(start) < time.Minute; time.Sleep(500 * time.Millisecond) {
+		dequeue, err := messagesURL.Dequeue(ctx, 1, time.Second)
+		c.Assert(err, chk.IsNil)
+		if dequeue.NumMessages() == 1 {
+			return dequeue.Message(0)
+		}
+	}
+	c.Fatal("the message did not become visible again")
+	return nil
+}
+
+func (s *queueSuite) TestClearAllRetriesOperationTimedOut(c *chk.C) {
+	calls := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			return newMockedResponse(http.StatusInternalServerError, http.Header{"X-Ms-Error-Code": []string{"OperationTimedOut"}}), nil
+		}
+		return newMockedResponse(http.StatusNoContent, nil), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	n, err := messagesURL.ClearAll(ctx, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(n, chk.Equals, 3)
+
+	// Once maxWait elapses, the service's error is returned
+	calls = 0
+	n, err = messagesURL.ClearAll(ctx, 0)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeOperationTimedOut)
+	c.Assert(n, chk.Equals, 1)
+}
+
+func (s *queueSuite) TestEnqueueRawResponse(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		resp := newMockedEnqueueResponse()
+		resp.Header.Set("x-ms-version", azqueue.ServiceVersion)
+		resp.Header.Set("x-ms-request-id", "requestid")
+		resp.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue/messages")
+
+	resp, err := azqueue.NewMessagesURL(*u, p).Enqueue(ctx, "message", 0, 0)
+	c.Assert(err, chk.IsNil)
+	raw := resp.Response()
+	c.Assert(raw, chk.NotNil)
+	c.Assert(raw.Header.Get("x-ms-version"), chk.Equals, azqueue.ServiceVersion)
+	c.Assert(raw.Header.Get("x-ms-request-id"), chk.Equals, "requestid")
+	c.Assert(raw.Header.Get("Date"), chk.Equals, "Mon, 02 Jan 2006 15:04:05 GMT")
+
+	// The body has already been read and deserialized into the typed response
+	b, _ := ioutil.ReadAll(raw.Body)
+	c.Assert(b, chk.HasLen, 0)
+}
+
+func (s *queueSuite) TestDequeueCanceled(c *chk.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never respond; the client gives up
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, azqueue.NewPipeline(azqueue.NewAnonymousCredential(),
+		azqueue.PipelineOptions{Retry: azqueue.RetryOptions{MaxTries: 1}}))
+
+	// Canceled after the request was sent
+	cancelCtx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := messagesURL.Dequeue(cancelCtx, 1, time.Minute)
+	canceledErr, ok := err.(*azqueue.OperationCanceledError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(canceledErr.Operation, chk.Equals, "Dequeue")
+	c.Assert(canceledErr.RequestSent, chk.Equals, true)
+	c.Assert(canceledErr.Elapsed >= 100*time.Millisecond, chk.Equals, true)
+	c.Assert(errors.Is(err, context.Canceled), chk.Equals, true)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), chk.Equals, false)
+
+	// Deadline exceeded
+	deadlineCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = messagesURL.Dequeue(deadlineCtx, 1, time.Minute)
+	canceledErr, ok = err.(*azqueue.OperationCanceledError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(canceledErr.RequestSent, chk.Equals, true) // A deadline under 1 second still leaves time to send the request
+	c.Assert(errors.Is(err, context.DeadlineExceeded), chk.Equals, true)
+	c.Assert(errors.Is(err, context.Canceled), chk.Equals, false)
+
+	// Canceled before the request was sent
+	cancelCtx, cancel = context.WithCancel(ctx)
+	cancel()
+	_, err = messagesURL.Dequeue(cancelCtx, 1, time.Minute)
+	canceledErr, ok = err.(*azqueue.OperationCanceledError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(canceledErr.RequestSent, chk.Equals, false)
+
+	// A context error from the pipeline (like a per-try timeout) is passed through since the caller's ctx is still live
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	})
+	_, err = azqueue.NewMessagesURL(*u, p).Dequeue(ctx, 1, time.Minute)
+	_, ok = err.(*azqueue.OperationCanceledError)
+	c.Assert(ok, chk.Equals, false)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), chk.Equals, true)
+}
+
+func (s *queueSuite) TestDequeueShutdownVisibilityTimeout(c *chk.C) {
+	var visibilityTimeout string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		visibilityTimeout = request.URL.Query().Get("visibilitytimeout")
+		return newMockedDequeueResponse("a"), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	stopping := make(chan struct{})
+	shutdownCtx := azqueue.WithShutdownVisibilityTimeout(ctx, stopping, 5*time.Second)
+	_, err := messagesURL.Dequeue(shutdownCtx, 1, 10*time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(visibilityTimeout, chk.Equals, "600") // Not shutting down yet
+
+	close(stopping)
+	_, err = messagesURL.Dequeue(shutdownCtx, 1, 10*time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(visibilityTimeout, chk.Equals, "5")
+
+	_, err = messagesURL.Dequeue(shutdownCtx, 1, time.Second) // A shorter timeout is left alone
+	c.Assert(err, chk.IsNil)
+	c.Assert(visibilityTimeout, chk.Equals, "1")
+}
+
+// fakeMessagesClient implements azqueue.MessagesClient's Dequeue method without a pipeline; calling any other method panics.
+type fakeMessagesClient struct {
+	azqueue.MessagesClient
+	maxMessages, visibilityTimeout int32
+}
+
+func (f *fakeMessagesClient) Dequeue(ctx context.Context, numberOfMessages *int32, visibilitytimeout *int32, timeout *int32, requestID *string) (*azqueue.QueueMessagesList, error) {
+	f.maxMessages, f.visibilityTimeout = *numberOfMessages, *visibilitytimeout
+	return &azqueue.QueueMessagesList{Items: []azqueue.DequeuedMessageItem{{MessageID: "id", PopReceipt: "pr", MessageText: "from fake"}}}, nil
+}
+
+func (s *queueSuite) TestNewMessagesURLFromClient(c *chk.C) {
+	client := &fakeMessagesClient{}
+	messagesURL := azqueue.NewMessagesURLFromClient(client)
+	dequeue, err := messagesURL.Dequeue(ctx, 3, 30*time.Second)
+	c.Assert(err, chk.IsNil)
+	c.Assert(client.maxMessages, chk.Equals, int32(3))
+	c.Assert(client.visibilityTimeout, chk.Equals, int32(30))
+	c.Assert(dequeue.NumMessages(), chk.Equals, int32(1))
+	c.Assert(dequeue.Message(0).Text, chk.Equals, "from fake")
+}
+
+func (s *queueSuite) TestDequeuedMessageLatencyUsesServerTime(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		resp := newMockedDequeueResponse("msg") // Inserted at 15:04:05
+		resp.Header.Set("Date", "Mon, 02 Jan 2006 15:05:35 GMT")
+		return resp, nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	dequeue, err := azqueue.NewMessagesURL(*u, p).Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	msg := dequeue.Message(0)
+
+	// The local clock is years ahead of the service's, which doesn't affect the results
+	c.Assert(msg.QueueLatency(), chk.Equals, 90*time.Second)
+	age := msg.Age()
+	c.Assert(age >= 90*time.Second && age < 91*time.Second, chk.Equals, true)
+
+	// Without the service's time, the local clock is used
+	c.Assert((&azqueue.DequeuedMessage{InsertionTime: time.Now().Add(-time.Minute)}).QueueLatency() >= time.Minute, chk.Equals, true)
+}
+
+func (s *queueSuite) TestDequeuedMessageTimesAreUTCSeconds(c *chk.C) {
+	body := "<QueueMessagesList><QueueMessage><MessageId>id</MessageId>" +
+		"<InsertionTime>Mon, 02 Jan 2006 15:04:05 GMT</InsertionTime>" +
+		"<ExpirationTime>Mon, 09 Jan 2006 07:04:05 -0800</ExpirationTime>" + // Some emulators send RFC1123Z
+		"<PopReceipt>pr</PopReceipt><TimeNextVisible>Mon, 02 Jan 2006 15:05:05 GMT</TimeNextVisible>" +
+		"<DequeueCount>1</DequeueCount><MessageText>msg</MessageText></QueueMessage></QueueMessagesList>"
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(body))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	dequeue, err := azqueue.NewMessagesURL(*u, p).Dequeue(ctx, 1, time.Minute)
+	c.Assert(err, chk.IsNil)
+	msg := dequeue.Message(0)
+
+	inserted := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	c.Assert(msg.InsertionTime, chk.Equals, inserted)
+	c.Assert(msg.ExpirationTime, chk.Equals, inserted.Add(7*24*time.Hour))
+	c.Assert(msg.NextVisibleTime, chk.Equals, inserted.Add(time.Minute))
+
+	// VisibleIn clamps at zero instead of going negative once the message is visible again
+	c.Assert(msg.VisibleIn(inserted.Add(30*time.Second+500*time.Millisecond)), chk.Equals, 29*time.Second+500*time.Millisecond)
+	c.Assert(msg.VisibleIn(inserted.Add(time.Minute)), chk.Equals, time.Duration(0))
+	c.Assert(msg.VisibleIn(inserted.Add(time.Hour)), chk.Equals, time.Duration(0))
+}
+
+func (s *queueSuite) TestEnqueueTextUsesDefaults(c *chk.C) {
+	var query url.Values
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		query = request.URL.Query()
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	// Without defaults, the message is visible immediately and the service's default TTL applies
+	_, err := messagesURL.EnqueueText(ctx, "text")
+	c.Assert(err, chk.IsNil)
+	c.Assert(query.Get("visibilitytimeout"), chk.Equals, "0")
+	c.Assert(query["messagettl"], chk.IsNil)
+
+	withDefaults, err := messagesURL.WithDefaults(azqueue.EnqueueDefaults{VisibilityTimeout: 10 * time.Second, TimeToLive: time.Hour})
+	c.Assert(err, chk.IsNil)
+	_, err = withDefaults.WithPipeline(p).EnqueueText(ctx, "text") // The defaults are kept by derived MessagesURLs
+	c.Assert(err, chk.IsNil)
+	c.Assert(query.Get("visibilitytimeout"), chk.Equals, "10")
+	c.Assert(query.Get("messagettl"), chk.Equals, "3600")
+
+	// Invalid defaults, such as a swapped visibility timeout and TTL, fail when they are configured
+	for _, d := range []azqueue.EnqueueDefaults{
+		{VisibilityTimeout: time.Minute, TimeToLive: time.Second},
+		{VisibilityTimeout: -time.Second},
+		{VisibilityTimeout: 8 * 24 * time.Hour},
+		{TimeToLive: time.Millisecond},
+		{TimeToLive: -time.Minute},
+	} {
+		_, err = messagesURL.WithDefaults(d)
+		c.Assert(err, chk.NotNil)
+	}
+	_, err = messagesURL.WithDefaults(azqueue.EnqueueDefaults{VisibilityTimeout: time.Hour, TimeToLive: -time.Second}) // Never expires
+	c.Assert(err, chk.IsNil)
+}
+
+func (s *queueSuite) TestEnqueueTimeToLive(c *chk.C) {
+	var query url.Values
+	requests := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		requests++
+		query = request.URL.Query()
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	for ttl, expected := range map[time.Duration]string{
+		azqueue.MessageTTLInfinite: "-1",
+		-time.Second:               "-1", // Accepted for compatibility
+		time.Second:                "1",
+		30 * 24 * time.Hour:        "2592000", // Longer than the default of 7 days
+	} {
+		_, err := messagesURL.Enqueue(ctx, "text", 0, ttl)
+		c.Assert(err, chk.IsNil)
+		c.Assert(query.Get("messagettl"), chk.Equals, expected)
+	}
+	_, err := messagesURL.Enqueue(ctx, "text", 0, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(query["messagettl"], chk.IsNil)
+
+	requests = 0
+	for _, ttl := range []time.Duration{-2, -time.Hour, time.Millisecond, (math.MaxInt32 + 1) * time.Second} {
+		_, err := messagesURL.Enqueue(ctx, "text", 0, ttl)
+		c.Assert(err, chk.NotNil)
+	}
+	c.Assert(requests, chk.Equals, 0)
+}
+
+func (s *queueSuite) TestDequeueWithOptions(c *chk.C) {
+	var query url.Values
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		query = request.URL.Query()
+		return newMockedDequeueResponse("a"), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	// Nil options omit the query parameters so the service defaults apply
+	_, err := messagesURL.DequeueWithOptions(ctx, azqueue.DequeueOptions{})
+	c.Assert(err, chk.IsNil)
+	_, hasNum := query["numofmessages"]
+	_, hasVT := query["visibilitytimeout"]
+	c.Assert(hasNum, chk.Equals, false)
+	c.Assert(hasVT, chk.Equals, false)
+
+	maxMessages, vt := int32(32), 7*24*time.Hour
+	_, err = messagesURL.DequeueWithOptions(ctx, azqueue.DequeueOptions{MaxMessages: &maxMessages, VisibilityTimeout: &vt})
+	c.Assert(err, chk.IsNil)
+	c.Assert(query.Get("numofmessages"), chk.Equals, "32")
+	c.Assert(query.Get("visibilitytimeout"), chk.Equals, "604800")
+
+	query = nil
+	for _, o := range []azqueue.DequeueOptions{
+		{MaxMessages: func() *int32 { n := int32(0); return &n }()},
+		{MaxMessages: func() *int32 { n := int32(33); return &n }()},
+		{VisibilityTimeout: func() *time.Duration { d := 500 * time.Millisecond; return &d }()},
+		{VisibilityTimeout: func() *time.Duration { d := 7*24*time.Hour + time.Second; return &d }()},
+	} {
+		_, err = messagesURL.DequeueWithOptions(ctx, o)
+		c.Assert(err, chk.NotNil)
+	}
+	c.Assert(query, chk.IsNil) // No request was sent
+
+	// During shutdown, the service default is capped too
+	stopping := make(chan struct{})
+	close(stopping)
+	_, err = messagesURL.DequeueWithOptions(azqueue.WithShutdownVisibilityTimeout(ctx, stopping, 5*time.Second), azqueue.DequeueOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(query.Get("visibilitytimeout"), chk.Equals, "5")
+}
+
+func (s *queueSuite) TestEnqueueMessageTextRoundTrip(c *chk.C) {
+	var sent []string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		var msg azqueue.QueueMessage
+		b, _ := ioutil.ReadAll(request.Body)
+		c.Assert(xml.Unmarshal(b, &msg), chk.IsNil)
+		sent = append(sent, msg.MessageText)
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	for _, text := range []string{"emoji 😀🚀", "CJK 漢字かなカナ한국어", "line1\r\nline2\rline3\n", "tab\there", "<xml> & \"quotes\" 'too'", "� replacement char", "\U0010FFFF"} {
+		_, err := messagesURL.Enqueue(ctx, text, 0, 0)
+		c.Assert(err, chk.IsNil)
+		c.Assert(sent[len(sent)-1], chk.Equals, text)
+	}
+
+	sent = nil
+	for _, tc := range []struct {
+		text   string
+		offset int
+	}{
+		{"csv,value\x0b,more", 9},
+		{"\x00", 0},
+		{"ok\x1f", 2},
+		{"漢\x7f\x08", 4}, // DEL is allowed; backspace is not
+		{"bad utf-8 \xff", 10},
+		{"￾", 0},
+	} {
+		_, err := messagesURL.Enqueue(ctx, tc.text, 0, 0)
+		validationErr, ok := err.(*azqueue.MessageTextValidationError)
+		c.Assert(ok, chk.Equals, true)
+		c.Assert(validationErr.Offset, chk.Equals, tc.offset)
+		c.Assert(strings.Contains(err.Error(), "base64"), chk.Equals, true)
+		c.Assert(errors.Is(err, azqueue.ErrInvalidMessageContent), chk.Equals, true)
+		c.Assert(azqueue.ValidateMessageEncoding(tc.text), chk.DeepEquals, err)
+	}
+	c.Assert(sent, chk.IsNil) // No request was sent
+	c.Assert(azqueue.ValidateMessageEncoding("emoji 😀 and\ttab"), chk.IsNil)
+
+	c.Assert(azqueue.StripInvalidXMLChars("a\x0bb\xffc\U0001F600"), chk.Equals, "abc\U0001F600")
+	c.Assert(azqueue.StripInvalidXMLChars("valid"), chk.Equals, "valid")
+
+	_, err := azqueue.NewMessageIDURL(*u, p).Update(ctx, "pr", 0, "\x0b")
+	c.Assert(err, chk.FitsTypeOf, &azqueue.MessageTextValidationError{})
+}
+
+func (s *queueSuite) TestEnqueueMessageTooLarge(c *chk.C) {
+	sentBodies := []int{}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(request.Body)
+		sentBodies = append(sentBodies, len(b))
+		return newMockedEnqueueResponse(), nil
+	})
+	u, _ := url.Parse("https://account.queue.core.windows.net/queue/messages")
+	messagesURL := azqueue.NewMessagesURL(*u, p)
+
+	// The largest message that fits reports its encoded size
+	resp, err := messagesURL.Enqueue(ctx, strings.Repeat("a", azqueue.QueueMessageMaxBytes), 0, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.EncodedSize, chk.Equals, azqueue.QueueMessageMaxBytes)
+
+	// XML escaping counts toward the limit
+	resp, err = messagesURL.Enqueue(ctx, "a<b", 0, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.EncodedSize, chk.Equals, len("a&lt;b"))
+
+	for _, text := range []string{strings.Repeat("a", azqueue.QueueMessageMaxBytes+1), strings.Repeat("&", azqueue.QueueMessageMaxBytes/4)} {
+		_, err = messagesURL.Enqueue(ctx, text, 0, 0)
+		tooLarge, ok := err.(*azqueue.MessageTooLargeError)
+		c.Assert(ok, chk.Equals, true)
+		c.Assert(tooLarge.Limit, chk.Equals, azqueue.QueueMessageMaxBytes)
+		c.Assert(tooLarge.Size > tooLarge.Limit, chk.Equals, true)
+	}
+	c.Assert(len(sentBodies), chk.Equals, 2) // The oversized messages were not sent
+}
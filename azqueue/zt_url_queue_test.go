@@ -1,6 +1,17 @@
 package azqueue_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-queue-go/azqueue"
 	chk "gopkg.in/check.v1"
 )
@@ -35,3 +46,593 @@ func (s *queueSuite) TestGetPropertiesOnNonExistentQueue(c *chk.C) {
 	c.Assert(storageErr.ServiceCode(), chk.Equals, azqueue.ServiceCodeType("QueueNotFound"))
 	c.Assert(storageErr.Response().StatusCode, chk.Equals, 404)
 }
+
+func (s *queueSuite) TestApproximateMessagesCountAboveInt32(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": []string{"4294967296"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	resp, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.ApproximateMessagesCountInt64(), chk.Equals, int64(4294967296))
+	c.Assert(resp.ApproximateMessagesCount(), chk.Equals, int32(math.MaxInt32)) // Saturates rather than wraps
+}
+
+func (s *queueSuite) TestCreateOrGetProperties(c *chk.C) {
+	createStatus, createCode := http.StatusCreated, ""
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			c.Assert(request.Header.Get("x-ms-meta-owner"), chk.Equals, "team")
+			return newMockedResponse(createStatus, http.Header{"X-Ms-Error-Code": {createCode}}), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Meta-Owner": {"existing"}, "X-Ms-Approximate-Messages-Count": {"3"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+	metadata := azqueue.Metadata{"owner": "team"}
+
+	resp, err := queueURL.CreateOrGetProperties(ctx, metadata)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Created, chk.Equals, true)
+
+	// The queue exists with the same metadata
+	createStatus = http.StatusNoContent
+	resp, err = queueURL.CreateOrGetProperties(ctx, metadata)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Created, chk.Equals, false)
+
+	// The queue exists with different metadata, which is returned unchanged
+	createStatus, createCode = http.StatusConflict, string(azqueue.ServiceCodeQueueAlreadyExists)
+	resp, err = queueURL.CreateOrGetProperties(ctx, metadata)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Created, chk.Equals, false)
+	c.Assert(resp.NewMetadata(), chk.DeepEquals, azqueue.Metadata{"owner": "existing"})
+	c.Assert(resp.ApproximateMessagesCount(), chk.Equals, int32(3))
+
+	// Other errors are returned
+	createStatus, createCode = http.StatusConflict, string(azqueue.ServiceCodeQueueBeingDeleted)
+	_, err = queueURL.CreateOrGetProperties(ctx, metadata)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeQueueBeingDeleted)
+}
+
+func (s *queueSuite) TestSetAccessPolicyValidation(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		c.Fatal("SetAccessPolicy must not send a request when validation fails")
+		return nil, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+	now := time.Now()
+
+	tooMany := make([]azqueue.SignedIdentifier, azqueue.QueueMaxSignedIdentifiers+1)
+	for i := range tooMany {
+		tooMany[i].ID = strconv.Itoa(i)
+	}
+	testCases := []struct {
+		identifiers []azqueue.SignedIdentifier
+		index       int
+		violation   azqueue.SignedIdentifierViolation
+	}{
+		{tooMany, -1, azqueue.SignedIdentifierViolationTooMany},
+		{[]azqueue.SignedIdentifier{{ID: "a"}, {ID: "a"}}, 1, azqueue.SignedIdentifierViolationDuplicateID},
+		{[]azqueue.SignedIdentifier{{ID: strings.Repeat("x", azqueue.SignedIdentifierMaxIDLength+1)}}, 0, azqueue.SignedIdentifierViolationIDTooLong},
+		{[]azqueue.SignedIdentifier{{ID: "a", AccessPolicy: azqueue.AccessPolicy{Start: now, Expiry: now.Add(-time.Hour)}}}, 0, azqueue.SignedIdentifierViolationStartNotBeforeExpiry},
+	}
+	for _, tc := range testCases {
+		_, err := queueURL.SetAccessPolicy(ctx, tc.identifiers)
+		validationErr, ok := err.(*azqueue.SignedIdentifierValidationError)
+		c.Assert(ok, chk.Equals, true)
+		c.Assert(validationErr.Index, chk.Equals, tc.index)
+		c.Assert(validationErr.Violation, chk.Equals, tc.violation)
+	}
+
+	// Permission letters this package doesn't know are not a violation; they round-trip from GetAccessPolicy to SetAccessPolicy
+	var body string
+	p = newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			b, _ := ioutil.ReadAll(request.Body)
+			body = string(b)
+			return newMockedResponse(http.StatusOK, nil), nil
+		}
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader("<SignedIdentifiers><SignedIdentifier><Id>a</Id><AccessPolicy>" +
+			"<Permission>rw</Permission></AccessPolicy></SignedIdentifier></SignedIdentifiers>"))
+		return resp, nil
+	})
+	queueURL = azqueue.NewQueueURL(*u, p)
+	identifiers, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	permission := azqueue.AccessPolicyPermission{}
+	c.Assert(permission.Parse(identifiers.Items[0].AccessPolicy.Permission), chk.IsNil)
+	c.Assert(permission, chk.Equals, azqueue.AccessPolicyPermission{Read: true, Extra: "w"})
+	c.Assert(permission.Validate(), chk.IsNil)
+	identifiers.Items[0].AccessPolicy.Permission = permission.String()
+	_, err = queueURL.SetAccessPolicy(ctx, identifiers.Items)
+	c.Assert(err, chk.IsNil)
+	c.Assert(strings.Contains(body, "<Permission>rw</Permission>"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestExists(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		switch request.URL.Path {
+		case "/exists":
+			return newMockedResponse(http.StatusOK, nil), nil
+		case "/missing":
+			return newMockedResponse(http.StatusNotFound, http.Header{"X-Ms-Error-Code": []string{"QueueNotFound"}}), nil
+		}
+		return newMockedResponse(http.StatusForbidden, http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthorizationFailure)}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	exists, err := serviceURL.QueueExists(ctx, "exists")
+	c.Assert(err, chk.IsNil)
+	c.Assert(exists, chk.Equals, true)
+
+	exists, err = serviceURL.NewQueueURL("missing").Exists(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(exists, chk.Equals, false)
+
+	exists, err = serviceURL.NewQueueURL("forbidden").Exists(ctx)
+	c.Assert(err, chk.NotNil) // Not having permission must not be reported as not existing
+	c.Assert(exists, chk.Equals, false)
+}
+
+func (s *queueSuite) TestCreateWithRetryOnBeingDeleted(c *chk.C) {
+	tries := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		tries++
+		if tries == 1 {
+			return newMockedResponse(http.StatusConflict, http.Header{"X-Ms-Error-Code": []string{"QueueBeingDeleted"}}), nil
+		}
+		return newMockedResponse(http.StatusCreated, nil), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	resp, err := queueURL.CreateWithRetryOnBeingDeleted(ctx, nil, time.Minute)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.StatusCode(), chk.Equals, http.StatusCreated)
+	c.Assert(tries, chk.Equals, 2)
+
+	// If maxWait doesn't allow another try, the service's error is returned
+	tries = 0
+	_, err = queueURL.CreateWithRetryOnBeingDeleted(ctx, nil, 0)
+	c.Assert(err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeQueueBeingDeleted)
+	c.Assert(tries, chk.Equals, 1)
+}
+
+func (s *queueSuite) TestSignedIdentifierBuilder(c *chk.C) {
+	var body string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			b, _ := ioutil.ReadAll(request.Body)
+			body = string(b)
+			return newMockedResponse(http.StatusOK, nil), nil
+		}
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader("<SignedIdentifiers><SignedIdentifier><Id>full</Id><AccessPolicy>" +
+			"<Start>2030-01-02T03:04:05.0000000Z</Start><Expiry>2030-01-03T03:04:05.0000000Z</Expiry><Permission>rp</Permission>" +
+			"</AccessPolicy></SignedIdentifier><SignedIdentifier><Id>empty</Id><AccessPolicy><Start /><Expiry /><Permission />" +
+			"</AccessPolicy></SignedIdentifier></SignedIdentifiers>"))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	start := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	full := azqueue.NewSignedIdentifier("full").WithPermissions(azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true}).
+		StartingAt(start).ExpiringIn(24 * time.Hour)
+	c.Assert(full.AccessPolicy.Expiry, chk.Equals, start.Add(24*time.Hour))
+	c.Assert(full.AccessPolicy.Permission, chk.Equals, "rp")
+
+	// Unset access policy fields are omitted so that a SAS can supply them
+	_, err := queueURL.SetAccessPolicy(ctx, []azqueue.SignedIdentifier{full, azqueue.NewSignedIdentifier("empty")})
+	c.Assert(err, chk.IsNil)
+	c.Assert(strings.Contains(body, "<Start>2030-01-02T03:04:05.0000000Z</Start>"), chk.Equals, true)
+	c.Assert(strings.Contains(body, "<Id>empty</Id><AccessPolicy></AccessPolicy>"), chk.Equals, true)
+
+	identifiers, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(identifiers.Items, chk.HasLen, 2)
+	c.Assert(identifiers.Items[0].AccessPolicy.Start.Equal(start), chk.Equals, true)
+	permission := azqueue.AccessPolicyPermission{}
+	c.Assert(permission.Parse(identifiers.Items[0].AccessPolicy.Permission), chk.IsNil)
+	c.Assert(permission, chk.Equals, azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true})
+	c.Assert(identifiers.Items[1].AccessPolicy.Start.IsZero(), chk.Equals, true)
+}
+
+func (s *queueSuite) TestAccessPolicyStartAndExpiryTimes(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader("<SignedIdentifiers>" +
+			"<SignedIdentifier><Id>bounded</Id><AccessPolicy><Start>2030-01-02T03:04:05.0000000Z</Start>" +
+			"<Expiry>2030-01-03T03:04:05.0000000Z</Expiry><Permission>r</Permission></AccessPolicy></SignedIdentifier>" +
+			"<SignedIdentifier><Id>openstart</Id><AccessPolicy><Expiry>2030-01-03T03:04:05.0000000Z</Expiry><Permission>r</Permission></AccessPolicy></SignedIdentifier>" +
+			"<SignedIdentifier><Id>open</Id><AccessPolicy><Start /><Expiry /><Permission>r</Permission></AccessPolicy></SignedIdentifier>" +
+			"</SignedIdentifiers>"))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	identifiers, err := azqueue.NewQueueURL(*u, p).GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(identifiers.Items, chk.HasLen, 3)
+	start, expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), time.Date(2030, 1, 3, 3, 4, 5, 0, time.UTC)
+
+	bounded := identifiers.Items[0].AccessPolicy
+	c.Assert(bounded.StartTime().Equal(start), chk.Equals, true)
+	c.Assert(bounded.ExpiryTime().Equal(expiry), chk.Equals, true)
+
+	openStart := identifiers.Items[1].AccessPolicy
+	c.Assert(openStart.StartTime(), chk.IsNil)
+	c.Assert(openStart.ExpiryTime().Equal(expiry), chk.Equals, true)
+
+	open := identifiers.Items[2].AccessPolicy
+	c.Assert(open.StartTime(), chk.IsNil)
+	c.Assert(open.ExpiryTime(), chk.IsNil)
+
+	// The returned times are copies
+	*bounded.StartTime() = time.Time{}
+	c.Assert(bounded.StartTime().Equal(start), chk.Equals, true)
+}
+
+func (s *queueSuite) TestAccessPolicyPermissionParse(c *chk.C) {
+	p := azqueue.AccessPolicyPermission{}
+	c.Assert(p.Parse("rap"), chk.IsNil)
+	c.Assert(p, chk.Equals, azqueue.AccessPolicyPermission{Read: true, Add: true, ProcessMessages: true})
+	c.Assert(p.Equal(azqueue.AccessPolicyPermission{ProcessMessages: true, Add: true, Read: true}), chk.Equals, true)
+	c.Assert(p.Equal(azqueue.AccessPolicyPermission{Read: true, Add: true}), chk.Equals, false)
+
+	// Letters added to the service later are kept, so a parsed permission formats back to the same letters
+	c.Assert(p.Parse("rxpyx"), chk.IsNil)
+	c.Assert(p, chk.Equals, azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true, Extra: "xy"})
+	c.Assert(p.String(), chk.Equals, "rpxy")
+	c.Assert(p.Equal(azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true, Extra: "yx"}), chk.Equals, true)
+	c.Assert(p.Equal(azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true}), chk.Equals, false)
+	c.Assert(p.Validate(), chk.IsNil)
+	c.Assert(azqueue.AccessPolicyPermission{Extra: "w"}.Validate(), chk.IsNil)
+	c.Assert(azqueue.AccessPolicyPermission{}.Validate(), chk.NotNil)
+	c.Assert(azqueue.AccessPolicyPermission{Update: true}.Validate(), chk.IsNil)
+}
+
+func (s *queueSuite) TestSetGetAccessPolicy(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+
+	permission := azqueue.AccessPolicyPermission{Read: true, ProcessMessages: true}
+	identifiers := []azqueue.SignedIdentifier{
+		azqueue.NewSignedIdentifier("readers").WithPermissions(permission),
+		azqueue.NewSignedIdentifier("writers").WithPermissions(azqueue.AccessPolicyPermission{Add: true, Update: true}),
+	}
+	_, err := queueURL.SetAccessPolicy(ctx, identifiers)
+	c.Assert(err, chk.IsNil)
+
+	resp, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, 2)
+	c.Assert(resp.Items[0].ID, chk.Equals, "readers")
+	c.Assert(resp.Items[1].ID, chk.Equals, "writers")
+	parsed := azqueue.AccessPolicyPermission{}
+	c.Assert(parsed.Parse(resp.Items[0].AccessPolicy.Permission), chk.IsNil)
+	c.Assert(parsed.Equal(permission), chk.Equals, true)
+	c.Assert(parsed.Parse(resp.Items[1].AccessPolicy.Permission), chk.IsNil)
+	c.Assert(parsed.Equal(azqueue.AccessPolicyPermission{Add: true, Update: true}), chk.Equals, true)
+
+	// Duplicate IDs are rejected without sending a request, leaving the stored policies unchanged
+	_, err = queueURL.SetAccessPolicy(ctx, []azqueue.SignedIdentifier{identifiers[0], identifiers[0]})
+	validationErr, ok := err.(*azqueue.SignedIdentifierValidationError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(validationErr.Violation, chk.Equals, azqueue.SignedIdentifierViolationDuplicateID)
+	resp, err = queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, 2)
+
+	// Setting no identifiers removes all stored policies
+	_, err = queueURL.SetAccessPolicy(ctx, nil)
+	c.Assert(err, chk.IsNil)
+	resp, err = queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, 0)
+}
+
+func (s *queueSuite) TestAccessPolicyWithTimeRange(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+
+	// The service stores times in UTC; use whole seconds so the comparison doesn't depend on its precision
+	start := time.Now().UTC().Truncate(time.Second)
+	expiry := start.Add(24 * time.Hour)
+	_, err := queueURL.SetAccessPolicy(ctx, []azqueue.SignedIdentifier{
+		azqueue.NewSignedIdentifier("bounded").WithPermissions(azqueue.AccessPolicyPermission{Read: true}).StartingAt(start).ExpiringAt(expiry),
+		azqueue.NewSignedIdentifier("open").WithPermissions(azqueue.AccessPolicyPermission{Read: true}),
+	})
+	c.Assert(err, chk.IsNil)
+
+	resp, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, 2)
+	bounded := resp.Items[0].AccessPolicy
+	c.Assert(bounded.StartTime(), chk.NotNil)
+	c.Assert(bounded.StartTime().Equal(start), chk.Equals, true)
+	c.Assert(bounded.ExpiryTime(), chk.NotNil)
+	c.Assert(bounded.ExpiryTime().Equal(expiry), chk.Equals, true)
+	open := resp.Items[1].AccessPolicy
+	c.Assert(open.StartTime(), chk.IsNil)
+	c.Assert(open.ExpiryTime(), chk.IsNil)
+}
+
+func (s *queueSuite) TestAccessPolicyMaxIdentifiers(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+
+	identifiers := make([]azqueue.SignedIdentifier, azqueue.QueueMaxSignedIdentifiers)
+	for i := range identifiers {
+		identifiers[i] = azqueue.NewSignedIdentifier("policy" + strconv.Itoa(i)).WithPermissions(azqueue.AccessPolicyPermission{Read: true})
+	}
+	_, err := queueURL.SetAccessPolicy(ctx, identifiers)
+	c.Assert(err, chk.IsNil)
+	resp, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, azqueue.QueueMaxSignedIdentifiers)
+
+	// One more than the maximum is rejected without sending a request
+	identifiers = append(identifiers, azqueue.NewSignedIdentifier("onetoomany"))
+	_, err = queueURL.SetAccessPolicy(ctx, identifiers)
+	validationErr, ok := err.(*azqueue.SignedIdentifierValidationError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(validationErr.Violation, chk.Equals, azqueue.SignedIdentifierViolationTooMany)
+	resp, err = queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, azqueue.QueueMaxSignedIdentifiers)
+}
+
+func (s *queueSuite) TestAccessPolicyInvalidPermission(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	queueURL, _ := createNewQueue(c, qsu)
+	defer deleteQueue(c, queueURL)
+
+	// Unknown letters are passed through, so it is the service that rejects them
+	for _, permission := range []string{"rw", "rad", "x"} {
+		_, err := queueURL.SetAccessPolicy(ctx, []azqueue.SignedIdentifier{{ID: "invalid", AccessPolicy: azqueue.AccessPolicy{Permission: permission}}})
+		_, ok := err.(azqueue.StorageError)
+		c.Assert(ok, chk.Equals, true)
+	}
+	resp, err := queueURL.GetAccessPolicy(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.Items, chk.HasLen, 0)
+}
+
+func (s *queueSuite) TestUpdateMetadata(c *chk.C) {
+	var setHeader http.Header
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			setHeader = request.Header
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Meta-Owner": []string{"ops"}, "X-Ms-Meta-Stale": []string{"x"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	_, err := queueURL.UpdateMetadata(ctx, func(m azqueue.Metadata) azqueue.Metadata {
+		c.Assert(m, chk.DeepEquals, azqueue.Metadata{"owner": "ops", "stale": "x"})
+		m["team"] = "queue"
+		delete(m, "stale")
+		return m
+	})
+	c.Assert(err, chk.IsNil)
+	c.Assert(setHeader.Get("x-ms-meta-owner"), chk.Equals, "ops")
+	c.Assert(setHeader.Get("x-ms-meta-team"), chk.Equals, "queue")
+	c.Assert(setHeader.Get("x-ms-meta-stale"), chk.Equals, "")
+}
+
+func (s *queueSuite) TestStorageErrorRequestID(c *chk.C) {
+	requestID := ""
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		header := http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeQueueNotFound)}}
+		if requestID != "" {
+			header.Set("x-ms-request-id", requestID)
+		}
+		return newMockedResponse(http.StatusNotFound, header), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	requestID = "3c9a1f7e-0003-0042-5d4b-0a1b2c000000"
+	_, err := queueURL.GetProperties(ctx)
+	stgErr, ok := err.(azqueue.StorageError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(stgErr.RequestID(), chk.Equals, requestID)
+	c.Assert(strings.Contains(err.Error(), "(ServiceCode=QueueNotFound, RequestID="+requestID+")"), chk.Equals, true)
+
+	requestID = ""
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err.(azqueue.StorageError).RequestID(), chk.Equals, "")
+	c.Assert(strings.Contains(err.Error(), "(ServiceCode=QueueNotFound)"), chk.Equals, true)
+}
+
+func (s *queueSuite) TestStorageErrorServiceCodeSources(c *chk.C) {
+	testCases := []struct {
+		header http.Header
+		body   string
+		code   azqueue.ServiceCodeType
+	}{
+		// A header-only error, like a 403 from an account firewall
+		{http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthorizationFailure)}}, "", azqueue.ServiceCodeAuthorizationFailure},
+		// A body-only error
+		{nil, "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>RequestBodyTooLarge</Code><Message>too large</Message></Error>", azqueue.ServiceCodeRequestBodyTooLarge},
+		// The header takes precedence over the body
+		{http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthorizationFailure)}}, "<Error><Code>InternalError</Code></Error>", azqueue.ServiceCodeAuthorizationFailure},
+		// A body that isn't XML, like an error page from a proxy
+		{http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthorizationFailure)}}, "Forbidden", azqueue.ServiceCodeAuthorizationFailure},
+		// Malformed XML
+		{http.Header{"X-Ms-Error-Code": []string{string(azqueue.ServiceCodeAuthorizationFailure)}}, "<html><body>Forbidden", azqueue.ServiceCodeAuthorizationFailure},
+	}
+	for _, tc := range testCases {
+		p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+			resp := newMockedResponse(http.StatusForbidden, tc.header)
+			resp.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			return resp, nil
+		})
+		u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+		_, err := azqueue.NewQueueURL(*u, p).Delete(ctx)
+		stgErr, ok := err.(azqueue.StorageError)
+		c.Assert(ok, chk.Equals, true)
+		c.Assert(stgErr.ServiceCode(), chk.Equals, tc.code)
+		c.Assert(string(stgErr.ResponseBody()), chk.Equals, tc.body)
+		c.Assert(stgErr.Response().StatusCode, chk.Equals, http.StatusForbidden)
+	}
+}
+
+func (s *queueSuite) TestAuthorizationErrorNamesDataAction(c *chk.C) {
+	code := azqueue.ServiceCodeAuthorizationPermissionMismatch
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		return newMockedResponse(http.StatusForbidden, http.Header{"X-Ms-Error-Code": []string{string(code)}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/orders")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	_, err := queueURL.NewMessagesURL().Enqueue(ctx, "m", 0, 0)
+	c.Assert(err, chk.ErrorMatches, "(?s).*Operation=Enqueue, Queue=orders\n.*"+
+		"Microsoft.Storage/storageAccounts/queueServices/queues/messages/add/action.*Storage Queue Data Message Sender.*")
+	_, err = queueURL.GetProperties(ctx)
+	c.Assert(err, chk.ErrorMatches, "(?s).*queueServices/queues/read permission.*Storage Queue Data Reader.*")
+	_, err = queueURL.NewMessagesURL().NewMessageIDURL("id").ExtendVisibility(ctx, "pr", 0)
+	c.Assert(err, chk.ErrorMatches, "(?s).*Operation=UpdateMessage, Queue=orders\n.*queues/messages/write.*")
+
+	// Operations without a data action and other errors get no hint
+	_, err = queueURL.GetAccessPolicy(ctx)
+	c.Assert(strings.Contains(err.Error(), "Microsoft.Storage"), chk.Equals, false)
+	code = azqueue.ServiceCodeQueueNotFound
+	_, err = queueURL.NewMessagesURL().Enqueue(ctx, "m", 0, 0)
+	c.Assert(strings.Contains(err.Error(), "Microsoft.Storage"), chk.Equals, false)
+}
+
+func (s *queueSuite) TestMetadataCopiesAreIndependent(c *chk.C) {
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Meta-Owner": []string{"ops"}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+	props, err := queueURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+
+	// Concurrent readers and mutators of the same response; run with -race to detect shared state
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			md := props.NewMetadata()
+			md["owner"] = strconv.Itoa(i)
+			md["worker"] = strconv.Itoa(i)
+			queueURL.SetMetadata(ctx, md)
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(props.NewMetadata(), chk.DeepEquals, azqueue.Metadata{"owner": "ops"})
+
+	original := azqueue.Metadata{"owner": "ops"}
+	clone := original.Clone()
+	clone["owner"] = "dev"
+	c.Assert(original, chk.DeepEquals, azqueue.Metadata{"owner": "ops"})
+	c.Assert(azqueue.Metadata(nil).Clone(), chk.IsNil)
+}
+
+func (s *queueSuite) TestCompareAndSetMetadata(c *chk.C) {
+	current := http.Header{"X-Ms-Meta-Owner": []string{"ops"}, "X-Ms-Meta-Version": []string{"1"}}
+	sets := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			sets++
+			current = http.Header{}
+			for k, v := range request.Header {
+				if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+					current[k] = v
+				}
+			}
+			return newMockedResponse(http.StatusNoContent, nil), nil
+		}
+		return newMockedResponse(http.StatusOK, current), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net/fakequeue")
+	queueURL := azqueue.NewQueueURL(*u, p)
+
+	ok, err := queueURL.CompareAndSetMetadata(ctx, azqueue.Metadata{"owner": "ops", "version": "1"}, azqueue.Metadata{"owner": "ops", "version": "2"})
+	c.Assert(err, chk.IsNil)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(sets, chk.Equals, 1)
+
+	// The metadata changed, so stale expectations fail without setting anything
+	for _, expected := range []azqueue.Metadata{{"owner": "ops", "version": "1"}, {"owner": "ops"}, {"owner": "ops", "version": "2", "extra": "x"}} {
+		ok, err = queueURL.CompareAndSetMetadata(ctx, expected, azqueue.Metadata{"owner": "dev"})
+		c.Assert(err, chk.IsNil)
+		c.Assert(ok, chk.Equals, false)
+	}
+	c.Assert(sets, chk.Equals, 1)
+
+	ok, err = queueURL.CompareAndSetMetadata(ctx, azqueue.Metadata{"Owner": "ops", "version": "2"}, azqueue.Metadata{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(sets, chk.Equals, 2)
+}
+
+func (s *queueSuite) TestListQueuesSegmentPrefix(c *chk.C) {
+	qsu, _ := getGenericQueueServiceURL()
+	base := generateQueueName()
+	prefixes := []string{base + "a", base + "b"}
+
+	// Create 5 queues with each prefix
+	created := map[string][]string{}
+	for _, prefix := range prefixes {
+		for i := 0; i < 5; i++ {
+			name := fmt.Sprintf("%s%d", prefix, i)
+			queueURL := qsu.NewQueueURL(name)
+			_, err := queueURL.Create(ctx, nil)
+			c.Assert(err, chk.IsNil)
+			defer deleteQueue(c, queueURL)
+			created[prefix] = append(created[prefix], name)
+		}
+	}
+
+	// listQueueNames returns the names of all of the account's queues whose names start with prefix.
+	listQueueNames := func(prefix string) []string {
+		names := []string{}
+		for marker := (azqueue.Marker{}); marker.NotDone(); {
+			resp, err := qsu.ListQueuesSegment(ctx, marker, azqueue.ListQueuesSegmentOptions{Prefix: prefix})
+			c.Assert(err, chk.IsNil)
+			marker = resp.NextMarker
+			for _, item := range resp.QueueItems {
+				names = append(names, item.Name)
+			}
+		}
+		return names
+	}
+
+	// Only the queues with the matching prefix are returned (in alphabetical order)
+	for _, prefix := range prefixes {
+		c.Assert(listQueueNames(prefix), chk.DeepEquals, created[prefix])
+	}
+
+	// An empty prefix returns all of the account's queues, including every queue created above
+	all := map[string]bool{}
+	for _, name := range listQueueNames("") {
+		all[name] = true
+	}
+	for _, prefix := range prefixes {
+		for _, name := range created[prefix] {
+			c.Assert(all[name], chk.Equals, true)
+		}
+	}
+
+	// A prefix matching no queue returns an empty list rather than an error
+	c.Assert(listQueueNames(base+"z"), chk.HasLen, 0)
+}
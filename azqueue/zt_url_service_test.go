@@ -0,0 +1,227 @@
+package azqueue_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestGetAllQueueMetadata(c *chk.C) {
+	segments := map[string]string{
+		"":   `<EnumerationResults><Queues><Queue><Name>q1</Name><Metadata><k>v1</k></Metadata></Queue></Queues><NextMarker>m2</NextMarker></EnumerationResults>`,
+		"m2": `<EnumerationResults><Queues><Queue><Name>q2</Name><Metadata><k>v2</k></Metadata></Queue></Queues><NextMarker /></EnumerationResults>`,
+	}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		q := request.URL.Query()
+		c.Assert(q.Get("include"), chk.Equals, "metadata")
+		c.Assert(q.Get("prefix"), chk.Equals, "q")
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(segments[q.Get("marker")]))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	queues, err := serviceURL.GetAllQueueMetadata(ctx, "q")
+	c.Assert(err, chk.IsNil)
+	c.Assert(queues, chk.DeepEquals, map[string]azqueue.Metadata{"q1": {"k": "v1"}, "q2": {"k": "v2"}})
+}
+
+func (s *queueSuite) TestCountQueues(c *chk.C) {
+	segments := map[string]string{
+		"":   `<EnumerationResults><Queues><Queue><Name>q1</Name></Queue><Queue><Name>q2</Name></Queue></Queues><NextMarker>m2</NextMarker></EnumerationResults>`,
+		"m2": `<EnumerationResults><Queues><Queue><Name>q3</Name></Queue></Queues><NextMarker /></EnumerationResults>`,
+	}
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		q := request.URL.Query()
+		c.Assert(q.Get("include"), chk.Equals, "")
+		c.Assert(q.Get("prefix"), chk.Equals, "q")
+		c.Assert(q.Get("maxresults"), chk.Equals, "5000")
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(segments[q.Get("marker")]))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+
+	count, err := azqueue.NewServiceURL(*u, p).CountQueues(ctx, "q")
+	c.Assert(err, chk.IsNil)
+	c.Assert(count, chk.Equals, int64(3))
+}
+
+func (s *queueSuite) TestListQueuesSegmentValidatesOptions(c *chk.C) {
+	requests, maxResults := 0, ""
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		requests++
+		maxResults = request.URL.Query().Get("maxresults")
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(`<EnumerationResults><Queues /><NextMarker /></EnumerationResults>`))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	for _, o := range []azqueue.ListQueuesSegmentOptions{
+		{MaxResults: azqueue.ListQueuesMaxResults + 1},
+		{MaxResults: math.MaxInt32},
+		{Prefix: strings.Repeat("q", azqueue.QueueNameMaxLength+1)},
+	} {
+		_, err := serviceURL.ListQueuesSegment(ctx, azqueue.Marker{}, o)
+		c.Assert(err, chk.NotNil)
+		if o.MaxResults != 0 {
+			c.Assert(err, chk.Equals, azqueue.ErrMaxResultsExceeded)
+		}
+	}
+	c.Assert(requests, chk.Equals, 0)
+
+	_, err := serviceURL.ListQueuesSegment(ctx, azqueue.Marker{}, azqueue.ListQueuesSegmentOptions{
+		Prefix: strings.Repeat("q", azqueue.QueueNameMaxLength), MaxResults: azqueue.ListQueuesMaxResults})
+	c.Assert(err, chk.IsNil)
+	c.Assert(requests, chk.Equals, 1)
+
+	// MaxResults from 1 to ListQueuesMaxResults is sent; a MaxResults <= 0 is omitted so the service uses its default
+	for maxResultsOption, expected := range map[int32]string{1: "1", azqueue.ListQueuesMaxResults: "5000", 0: "", -1: "", math.MinInt32: ""} {
+		_, err := serviceURL.ListQueuesSegment(ctx, azqueue.Marker{}, azqueue.ListQueuesSegmentOptions{MaxResults: maxResultsOption})
+		c.Assert(err, chk.IsNil)
+		c.Assert(maxResults, chk.Equals, expected)
+	}
+	c.Assert(azqueue.ListQueuesSegmentDetails{}.None(), chk.Equals, true)
+	c.Assert(azqueue.ListQueuesSegmentDetails{Metadata: true}.None(), chk.Equals, false)
+}
+
+func (s *queueSuite) TestSetCORSRulesPreservesOtherProperties(c *chk.C) {
+	const properties = `<StorageServiceProperties><Logging><Version>1.0</Version><Delete>true</Delete><Read>false</Read>` +
+		`<Write>true</Write><RetentionPolicy><Enabled>false</Enabled></RetentionPolicy></Logging>` +
+		`<Cors><CorsRule><AllowedOrigins>https://old.example</AllowedOrigins><AllowedMethods>GET</AllowedMethods>` +
+		`<AllowedHeaders></AllowedHeaders><ExposedHeaders></ExposedHeaders><MaxAgeInSeconds>60</MaxAgeInSeconds></CorsRule></Cors>` +
+		`</StorageServiceProperties>`
+	var setBody string
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.Method == http.MethodPut {
+			b, _ := ioutil.ReadAll(request.Body)
+			setBody = string(b)
+			return newMockedResponse(http.StatusAccepted, nil), nil
+		}
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(properties))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	rules, err := serviceURL.GetCORSRules(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(rules, chk.DeepEquals, []azqueue.CorsRule{{AllowedOrigins: "https://old.example", AllowedMethods: "GET", MaxAgeInSeconds: 60}})
+
+	err = serviceURL.SetCORSRules(ctx, []azqueue.CorsRule{{AllowedOrigins: "https://new.example", AllowedMethods: "GET,PUT"}})
+	c.Assert(err, chk.IsNil)
+	c.Assert(strings.Contains(setBody, "<Logging><Version>1.0</Version><Delete>true</Delete>"), chk.Equals, true)
+	c.Assert(strings.Contains(setBody, "<Cors><CorsRule><AllowedOrigins>https://new.example</AllowedOrigins>"), chk.Equals, true)
+	c.Assert(strings.Contains(setBody, "old.example"), chk.Equals, false)
+
+	// Deleting all the rules sends an empty Cors element; without the element, the service would keep the rules
+	err = serviceURL.SetCORSRules(ctx, nil)
+	c.Assert(err, chk.IsNil)
+	c.Assert(strings.Contains(setBody, "<Cors></Cors>"), chk.Equals, true)
+	_, err = serviceURL.SetProperties(ctx, azqueue.StorageServiceProperties{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(setBody, chk.Equals, "<StorageServiceProperties></StorageServiceProperties>")
+
+	err = serviceURL.SetCORSRules(ctx, make([]azqueue.CorsRule, azqueue.ServiceMaxCORSRules+1))
+	c.Assert(err, chk.NotNil)
+
+	// The logging retention policy's days are still validated
+	zero := int32(0)
+	_, err = serviceURL.SetProperties(ctx, azqueue.StorageServiceProperties{
+		Logging: &azqueue.Logging{RetentionPolicy: azqueue.RetentionPolicy{Enabled: true, Days: &zero}}})
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *queueSuite) TestListQueuesIteratorCancel(c *chk.C) {
+	segments := map[string]string{
+		"":   `<EnumerationResults><Queues><Queue><Name>q1</Name></Queue></Queues><NextMarker>m2</NextMarker></EnumerationResults>`,
+		"m2": `<EnumerationResults><Queues><Queue><Name>q2</Name></Queue></Queues><NextMarker>m3</NextMarker></EnumerationResults>`,
+	}
+	requests := 0
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		requests++
+		resp := newMockedResponse(http.StatusOK, nil)
+		resp.Body = ioutil.NopCloser(strings.NewReader(segments[request.URL.Query().Get("marker")]))
+		return resp, nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	it := serviceURL.NewListQueuesIterator(cancelCtx, azqueue.ListQueuesSegmentOptions{})
+	c.Assert(it.Next(), chk.Equals, true)
+	c.Assert(it.Value().QueueItems[0].Name, chk.Equals, "q1")
+	c.Assert(it.Next(), chk.Equals, true)
+	c.Assert(it.Value().QueueItems[0].Name, chk.Equals, "q2")
+
+	// Cancelling between pages stops the enumeration without another request
+	cancel()
+	c.Assert(it.Next(), chk.Equals, false)
+	c.Assert(it.Err(), chk.Equals, context.Canceled)
+	c.Assert(it.Value(), chk.IsNil)
+	c.Assert(requests, chk.Equals, 2)
+}
+
+func (s *queueSuite) TestListQueuesWithCounts(c *chk.C) {
+	segments := map[string]string{
+		"":   `<EnumerationResults><Queues><Queue><Name>q1</Name></Queue><Queue><Name>deleted</Name></Queue><Queue><Name>q2</Name></Queue></Queues><NextMarker>m2</NextMarker></EnumerationResults>`,
+		"m2": `<EnumerationResults><Queues><Queue><Name>q3</Name></Queue><Queue><Name>q4</Name></Queue></Queues><NextMarker /></EnumerationResults>`,
+	}
+	var inFlight, maxInFlight int32
+	p := newMockedPipeline(func(request pipeline.Request) (*http.Response, error) {
+		if request.URL.Query().Get("comp") == "list" {
+			resp := newMockedResponse(http.StatusOK, nil)
+			resp.Body = ioutil.NopCloser(strings.NewReader(segments[request.URL.Query().Get("marker")]))
+			return resp, nil
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for max := atomic.LoadInt32(&maxInFlight); n > max && !atomic.CompareAndSwapInt32(&maxInFlight, max, n); max = atomic.LoadInt32(&maxInFlight) {
+		}
+		time.Sleep(10 * time.Millisecond)
+		name := strings.Trim(request.URL.Path, "/")
+		if name == "deleted" {
+			return newMockedResponse(http.StatusNotFound, http.Header{"X-Ms-Error-Code": {string(azqueue.ServiceCodeQueueNotFound)}}), nil
+		}
+		return newMockedResponse(http.StatusOK, http.Header{"X-Ms-Approximate-Messages-Count": {strconv.Itoa(len(name))}}), nil
+	})
+	u, _ := url.Parse("https://fakeaccount.queue.core.windows.net")
+	serviceURL := azqueue.NewServiceURL(*u, p)
+
+	results, err := serviceURL.ListQueuesWithCounts(ctx, azqueue.ListQueuesSegmentOptions{}, 2)
+	c.Assert(err, chk.IsNil)
+	c.Assert(atomic.LoadInt32(&maxInFlight) <= 2, chk.Equals, true)
+	c.Assert(results, chk.HasLen, 5)
+	for i, name := range []string{"q1", "deleted", "q2", "q3", "q4"} {
+		c.Assert(results[i].Name, chk.Equals, name)
+		if name == "deleted" { // A queue deleted during the listing doesn't fail the listing
+			c.Assert(results[i].Err.(azqueue.StorageError).ServiceCode(), chk.Equals, azqueue.ServiceCodeQueueNotFound)
+			c.Assert(results[i].ApproximateMessagesCount, chk.Equals, int64(-1))
+			continue
+		}
+		c.Assert(results[i].Err, chk.IsNil)
+		c.Assert(results[i].ApproximateMessagesCount, chk.Equals, int64(2))
+	}
+
+	// Cancelling stops the fan-out promptly
+	cancelCtx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = serviceURL.ListQueuesWithCounts(cancelCtx, azqueue.ListQueuesSegmentOptions{}, 1)
+	c.Assert(err, chk.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < 40*time.Millisecond, chk.Equals, true)
+}
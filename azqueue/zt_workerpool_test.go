@@ -0,0 +1,97 @@
+package azqueue_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/azure-storage-queue-go/azqueue/mock"
+	chk "gopkg.in/check.v1"
+)
+
+func (s *queueSuite) TestAdaptiveWorkerPool(c *chk.C) {
+	q := mock.NewInMemoryQueue()
+	for i := 0; i < 20; i++ {
+		_, err := q.Enqueue(ctx, fmt.Sprint(i), 0, 0)
+		c.Assert(err, chk.IsNil)
+	}
+
+	lock := sync.Mutex{}
+	handled := map[string]bool{}
+	release := make(chan struct{})
+	var pool *azqueue.AdaptiveWorkerPool
+	maxWorkers := 0
+	var results []azqueue.MessageResult
+	pool = azqueue.NewAdaptiveWorkerPool(q.NewMessageIDURL("id").MessagesURL(), azqueue.AdaptivePoolOptions{
+		MinWorkers:         1,
+		MaxWorkers:         3,
+		ScaleUpThreshold:   5,
+		ScaleDownThreshold: 1,
+		ScaleInterval:      10 * time.Millisecond,
+		VisibilityTimeout:  2 * time.Second, // The in-memory queue's times have a resolution of 1 second
+		Handler: func(ctx context.Context, msg *azqueue.DequeuedMessage) error {
+			<-release // Hold messages until the pool has scaled up
+			lock.Lock()
+			defer lock.Unlock()
+			if n := pool.Workers(); n > maxWorkers {
+				maxWorkers = n
+			}
+			if msg.Text == "3" && !handled["retried"] {
+				handled["retried"] = true
+				return fmt.Errorf("fails once")
+			}
+			handled[msg.Text] = true
+			return nil
+		},
+		OnResult: func(result azqueue.MessageResult) {
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, result)
+		},
+	})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- pool.Run(runCtx) }()
+
+	for pool.Workers() < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+
+	// Every message is deleted (the one whose handler failed once, after it becomes visible again), and the pool
+	// scales back down
+	for {
+		peek, err := q.Peek(ctx, 32)
+		c.Assert(err, chk.IsNil)
+		lock.Lock()
+		n := len(handled)
+		lock.Unlock()
+		if peek.NumMessages() == 0 && n == 21 && pool.Workers() == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	c.Assert(<-done, chk.Equals, context.Canceled)
+	c.Assert(pool.Workers(), chk.Equals, 0)
+	c.Assert(maxWorkers, chk.Equals, 3)
+	c.Assert(handled["retried"], chk.Equals, true)
+
+	// Each handled message's result was reported, including the failure
+	c.Assert(results, chk.HasLen, 21)
+	for _, result := range results {
+		c.Assert(result.Deleted, chk.Equals, result.Err == nil)
+		if result.Err != nil {
+			c.Assert(result.Message.Text, chk.Equals, "3")
+			c.Assert(result.DequeueCount, chk.Equals, int64(1))
+		} else if result.Message.Text == "3" {
+			c.Assert(result.DequeueCount, chk.Equals, int64(2))
+			c.Assert(result.QueueLatency >= time.Second, chk.Equals, true) // It waited for its visibility timeout
+		}
+	}
+
+	c.Assert(azqueue.NewAdaptiveWorkerPool(q.NewMessageIDURL("id").MessagesURL(), azqueue.AdaptivePoolOptions{}).Run(ctx), chk.NotNil)
+}
@@ -8,7 +8,6 @@ import (
 	"errors"
 	"net/http"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -848,19 +847,6 @@ func (qgpr QueueGetPropertiesResponse) Status() string {
 	return qgpr.rawResponse.Status
 }
 
-// ApproximateMessagesCount returns the value for header x-ms-approximate-messages-count.
-func (qgpr QueueGetPropertiesResponse) ApproximateMessagesCount() int32 {
-	s := qgpr.rawResponse.Header.Get("x-ms-approximate-messages-count")
-	if s == "" {
-		return -1
-	}
-	i, err := strconv.ParseInt(s, 10, 32)
-	if err != nil {
-		i = 0
-	}
-	return int32(i)
-}
-
 // Date returns the value for header Date.
 func (qgpr QueueGetPropertiesResponse) Date() time.Time {
 	s := qgpr.rawResponse.Header.Get("Date")